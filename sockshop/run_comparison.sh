@@ -12,8 +12,11 @@ else
 fi
 
 DURATION=60
+WARMUP=10
 RATE=50
+REPETITIONS=5
 RESULTS_DIR="performance_results"
+BASELINE="cmp_grpc_nozipkin_micro"
 SOCKSHOP_DIR="$(pwd)"
 mkdir -p $RESULTS_DIR
 
@@ -24,10 +27,12 @@ SPECS=(
     "cmp_thrift_nozipkin_micro"
     "cmp_zipkin_mono"
     "cmp_nozipkin_mono"
+    "cmp_grpc_nozipkin_grouped"
+    "cmp_grpc_nozipkin_backend"
 )
 
 echo "=== SockShop Performance Comparison ==="
-echo "Duration: ${DURATION}s, Rate: ${RATE} req/s"
+echo "Duration: ${DURATION}s after ${WARMUP}s warm-up, Rate: ${RATE} req/s, Repetitions: ${REPETITIONS}"
 echo "Results will be saved to: ${RESULTS_DIR}/"
 echo ""
 
@@ -85,7 +90,7 @@ for spec in "${SPECS[@]}"; do
     
     # Detect frontend port from docker-compose ps output
     cd $BUILD_DIR/docker
-    # Both microservices and monolith use frontend_proc_ctr (due to goproc.Deploy naming)
+    # Every comparison spec deploys the frontend to frontend_proc_ctr (due to goproc.Deploy naming)
     FRONTEND_PORT=$(sudo docker-compose ps | grep frontend_proc_ctr | grep -oP '\d+(?=->2000/tcp)' | head -1)
     FRONTEND_CTR="frontend_proc_ctr"
     cd ../..
@@ -119,11 +124,11 @@ for spec in "${SPECS[@]}"; do
                 sudo docker-compose logs $FRONTEND_CTR | tail -100
             else
                 echo "\n=== Frontend logs ==="
-                sudo docker-compose logs frontend_ctr | tail -50
+                sudo docker-compose logs $FRONTEND_CTR | tail -50
                 echo "\n=== Catalogue logs ==="
-                sudo docker-compose logs catalogue_ctr | tail -30
+                sudo docker-compose logs catalogue_proc_ctr | tail -30
                 echo "\n=== User logs ==="
-                sudo docker-compose logs user_ctr | tail -30
+                sudo docker-compose logs user_proc_ctr | tail -30
             fi
             echo "Skipping this configuration..."
             sudo docker-compose down 2>/dev/null || true
@@ -142,7 +147,11 @@ for spec in "${SPECS[@]}"; do
     cd ..
     set -a
     source ../../.local.env
-    ./wlgen_proc/wlgen --duration $DURATION --rate $RATE > "${SOCKSHOP_DIR}/${RESULTS_DIR}/${spec}.txt" 2>&1
+    for run in $(seq 1 $REPETITIONS); do
+        echo "  Run $run/$REPETITIONS"
+        ./wlgen_proc/wlgen --duration $DURATION --warmup $WARMUP --rate $RATE --label $spec \
+            --out "${SOCKSHOP_DIR}/${RESULTS_DIR}/${spec}_run${run}.json" > "${SOCKSHOP_DIR}/${RESULTS_DIR}/${spec}_run${run}.txt" 2>&1
+    done
     
     # Stop containers
     echo "Stopping containers..."
@@ -156,9 +165,8 @@ done
 echo "=== All tests completed ==="
 echo "Results saved in: ${RESULTS_DIR}/"
 echo ""
-echo "Summary of final results:"
-for spec in "${SPECS[@]}"; do
-    echo "--- $spec ---"
-    grep "=== Final Results ===" -A 1 "${RESULTS_DIR}/${spec}.txt" 2>/dev/null || echo "No results found"
-    echo ""
-done
+
+# Compare the results of all specs
+cd "$SOCKSHOP_DIR/workload"
+go run ./cmd/report -baseline $BASELINE -o "${SOCKSHOP_DIR}/${RESULTS_DIR}/report.html" -cdf "${SOCKSHOP_DIR}/${RESULTS_DIR}/cdf.csv" "${SOCKSHOP_DIR}/${RESULTS_DIR}"
+go run ./cmd/report -baseline $BASELINE "${SOCKSHOP_DIR}/${RESULTS_DIR}" | tee "${SOCKSHOP_DIR}/${RESULTS_DIR}/report.md"
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/cart"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
 	"github.com/blueprint-uservices/blueprint/runtime/core/registry"
 	"github.com/blueprint-uservices/blueprint/runtime/plugins/simplenosqldb"
 	"github.com/stretchr/testify/require"
@@ -41,10 +42,15 @@ func TestNonExistentCart(t *testing.T) {
 	require.Len(t, items, 0)
 }
 
+// Returns an amount in cents as USD
+func usd(cents int64) money.Money {
+	return money.Money{Amount: cents, Currency: "USD"}
+}
+
 var myitem = cart.Item{
 	ID:        "myitem",
 	Quantity:  5,
-	UnitPrice: 37.75,
+	UnitPrice: usd(3775),
 }
 
 func TestAddItemToNonExistentCart(t *testing.T) {
@@ -115,8 +121,8 @@ func TestAddItemToNonExistentCart(t *testing.T) {
 func TestAddRemoveItems(t *testing.T) {
 	customerID := "TestAddRemoveItems"
 	items := []cart.Item{
-		cart.Item{ID: "firstitem", Quantity: 5, UnitPrice: 37.75},
-		cart.Item{ID: "seconditem", Quantity: 12, UnitPrice: 12.25},
+		cart.Item{ID: "firstitem", Quantity: 5, UnitPrice: usd(3775)},
+		cart.Item{ID: "seconditem", Quantity: 12, UnitPrice: usd(1225)},
 	}
 
 	ctx := context.Background()
@@ -217,7 +223,7 @@ func TestUpdateItem(t *testing.T) {
 	item := cart.Item{
 		ID:        "myitem",
 		Quantity:  5,
-		UnitPrice: 37.75,
+		UnitPrice: usd(3775),
 	}
 
 	ctx := context.Background()
@@ -260,7 +266,7 @@ func TestUpdateItem(t *testing.T) {
 	}
 
 	// The item is on sale, add more to the cart, because it's cheaper
-	itemUpdate := cart.Item{ID: item.ID, Quantity: 1, UnitPrice: 30}
+	itemUpdate := cart.Item{ID: item.ID, Quantity: 1, UnitPrice: usd(3000)}
 
 	{
 		// Update the cart
@@ -306,17 +312,17 @@ func TestNegativeUpdateItem(t *testing.T) {
 	item := cart.Item{
 		ID:        "myitem",
 		Quantity:  5,
-		UnitPrice: 37.75,
+		UnitPrice: usd(3775),
 	}
 	doubleItem := cart.Item{
 		ID:        "myitem",
 		Quantity:  10,
-		UnitPrice: 37.75,
+		UnitPrice: usd(3775),
 	}
 	negativeItem := cart.Item{
 		ID:        "myitem",
 		Quantity:  -5,
-		UnitPrice: 37.75,
+		UnitPrice: usd(3775),
 	}
 
 	ctx := context.Background()
@@ -426,7 +432,7 @@ func TestMergeCarts(t *testing.T) {
 	item := cart.Item{
 		ID:        "myitem",
 		Quantity:  5,
-		UnitPrice: 37.75,
+		UnitPrice: usd(3775),
 	}
 
 	ctx := context.Background()
@@ -520,12 +526,12 @@ func TestMergeCartsConcat(t *testing.T) {
 	firstitem := cart.Item{
 		ID:        "firstitem",
 		Quantity:  5,
-		UnitPrice: 37.75,
+		UnitPrice: usd(3775),
 	}
 	seconditem := cart.Item{
 		ID:        "seconditem",
 		Quantity:  7,
-		UnitPrice: 48,
+		UnitPrice: usd(4800),
 	}
 
 	ctx := context.Background()
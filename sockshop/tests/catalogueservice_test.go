@@ -2,9 +2,14 @@ package tests
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/catalogue"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
 	"github.com/blueprint-uservices/blueprint/runtime/core/registry"
 	"github.com/blueprint-uservices/blueprint/runtime/plugins/sqlitereldb"
 	"github.com/stretchr/testify/require"
@@ -24,7 +29,12 @@ func init() {
 			return nil, err
 		}
 
-		return catalogue.NewCatalogueService(ctx, db)
+		rates, err := money.NewFixedRates(ctx, money.DefaultExchangeRates)
+		if err != nil {
+			return nil, err
+		}
+
+		return catalogue.NewCatalogueService(ctx, db, rates, "")
 	})
 
 	// // Manually switch over to this implementation to test against a locally-deployed mysql server
@@ -36,7 +46,12 @@ func init() {
 	// 		return nil, err
 	// 	}
 
-	// 	return catalogue.NewCatalogueService(ctx, db)
+	// 	rates, err := money.NewFixedRates(ctx, money.DefaultExchangeRates)
+	// 	if err != nil {
+	// 		return nil, err
+	// 	}
+
+	// 	return catalogue.NewCatalogueService(ctx, db, rates, "")
 	// })
 }
 
@@ -98,7 +113,7 @@ func TestCatalogueService(t *testing.T) {
 
 	{
 		// List socks; should be empty
-		socks, err := service.List(ctx, tags2, "", 1, 1000)
+		socks, err := service.List(ctx, tags2, "", 1, 1000, "")
 		require.NoError(t, err)
 		require.Len(t, socks, 0)
 	}
@@ -113,7 +128,7 @@ func TestCatalogueService(t *testing.T) {
 	sock := catalogue.Sock{
 		Name:        "mysock",
 		Description: "A Sock",
-		Price:       1.99,
+		Price:       usd(199),
 		Quantity:    5,
 		Tags:        []string{"blue", "red"},
 	}
@@ -123,17 +138,31 @@ func TestCatalogueService(t *testing.T) {
 		id, err := service.AddSock(ctx, sock)
 		require.NoError(t, err)
 
-		res, err := service.Get(ctx, id)
+		res, err := service.Get(ctx, id, "")
 		require.NoError(t, err)
 		requireSocksEqual(t, sock, res)
+
+		// Get the sock priced in another currency
+		res, err = service.Get(ctx, id, "EUR")
+		require.NoError(t, err)
+		require.Equal(t, money.Money{Amount: 183, Currency: "EUR"}, res.Price)
+
+		_, err = service.Get(ctx, id, "XXX")
+		require.Error(t, err)
 	}
 
 	{
 		// List blue socks; should have 1
-		socks, err := service.List(ctx, []string{"blue"}, "", 1, 1000)
+		socks, err := service.List(ctx, []string{"blue"}, "", 1, 1000, "")
 		require.NoError(t, err)
 		require.Len(t, socks, 1)
 		requireSocksEqual(t, sock, socks[0])
+
+		// List them priced in yen
+		socks, err = service.List(ctx, []string{"blue"}, "", 1, 1000, "JPY")
+		require.NoError(t, err)
+		require.Len(t, socks, 1)
+		require.Equal(t, money.Money{Amount: 298, Currency: "JPY"}, socks[0].Price)
 	}
 
 	{
@@ -145,7 +174,7 @@ func TestCatalogueService(t *testing.T) {
 
 	{
 		// List blue, brown, or green socks; should have 1
-		socks, err := service.List(ctx, tags, "", 1, 1000)
+		socks, err := service.List(ctx, tags, "", 1, 1000, "")
 		require.NoError(t, err)
 		require.Len(t, socks, 1)
 		requireSocksEqual(t, sock, socks[0])
@@ -160,7 +189,7 @@ func TestCatalogueService(t *testing.T) {
 
 	{
 		// List green socks; should have 0
-		socks, err := service.List(ctx, []string{"green"}, "", 1, 1000)
+		socks, err := service.List(ctx, []string{"green"}, "", 1, 1000, "")
 		require.NoError(t, err)
 		require.Empty(t, socks)
 	}
@@ -174,7 +203,7 @@ func TestCatalogueService(t *testing.T) {
 
 	{
 		// List all socks; should have 1
-		socks, err := service.List(ctx, nil, "", 1, 1000)
+		socks, err := service.List(ctx, nil, "", 1, 1000, "")
 		require.NoError(t, err)
 		require.Len(t, socks, 1)
 		requireSocksEqual(t, sock, socks[0])
@@ -183,7 +212,7 @@ func TestCatalogueService(t *testing.T) {
 	sock2 := catalogue.Sock{
 		Name:        "my second sock",
 		Description: "B Sock",
-		Price:       3.49,
+		Price:       usd(349),
 		Quantity:    11,
 		Tags:        []string{"red", "green"},
 	}
@@ -193,14 +222,14 @@ func TestCatalogueService(t *testing.T) {
 		id, err := service.AddSock(ctx, sock2)
 		require.NoError(t, err)
 
-		res, err := service.Get(ctx, id)
+		res, err := service.Get(ctx, id, "")
 		require.NoError(t, err)
 		requireSocksEqual(t, sock2, res)
 	}
 
 	{
 		// List blue socks; should have 1
-		socks, err := service.List(ctx, []string{"blue"}, "", 1, 1000)
+		socks, err := service.List(ctx, []string{"blue"}, "", 1, 1000, "")
 		require.NoError(t, err)
 		require.Len(t, socks, 1)
 		requireSocksEqual(t, sock, socks[0])
@@ -208,7 +237,7 @@ func TestCatalogueService(t *testing.T) {
 
 	{
 		// List green socks; should have 1
-		socks, err := service.List(ctx, []string{"green"}, "", 1, 1000)
+		socks, err := service.List(ctx, []string{"green"}, "", 1, 1000, "")
 		require.NoError(t, err)
 		require.Len(t, socks, 1)
 		requireSocksEqual(t, sock2, socks[0])
@@ -216,7 +245,7 @@ func TestCatalogueService(t *testing.T) {
 
 	{
 		// List all socks; should have 2
-		socks, err := service.List(ctx, nil, "", 1, 1000)
+		socks, err := service.List(ctx, nil, "", 1, 1000, "")
 		require.NoError(t, err)
 		require.Len(t, socks, 2)
 		requireSock(t, sock, socks)
@@ -225,7 +254,7 @@ func TestCatalogueService(t *testing.T) {
 
 	{
 		// List blue, brown, or green socks; should have 2
-		socks, err := service.List(ctx, tags, "", 1, 1000)
+		socks, err := service.List(ctx, tags, "", 1, 1000, "")
 		require.NoError(t, err)
 		require.Len(t, socks, 2)
 		requireSock(t, sock, socks)
@@ -241,7 +270,7 @@ func TestCatalogueService(t *testing.T) {
 
 	{
 		// List red socks; should have 2
-		socks, err := service.List(ctx, []string{"red"}, "", 1, 1000)
+		socks, err := service.List(ctx, []string{"red"}, "", 1, 1000, "")
 		require.NoError(t, err)
 		require.Len(t, socks, 2)
 		requireSock(t, sock, socks)
@@ -249,7 +278,7 @@ func TestCatalogueService(t *testing.T) {
 	}
 
 	{
-		socks, err := service.List(ctx, nil, "", 1, 1000)
+		socks, err := service.List(ctx, nil, "", 1, 1000, "")
 		require.NoError(t, err)
 		require.Len(t, socks, 2)
 		requireSock(t, sock, socks)
@@ -264,7 +293,7 @@ func TestCatalogueService(t *testing.T) {
 
 			{
 				// Check remaining socks
-				remaining, err := service.List(ctx, nil, "", 1, 1000)
+				remaining, err := service.List(ctx, nil, "", 1, 1000, "")
 				require.NoError(t, err)
 				require.Len(t, remaining, len(socks)-i-1)
 				require.ElementsMatch(t, socks[i+1:], remaining)
@@ -274,6 +303,126 @@ func TestCatalogueService(t *testing.T) {
 
 }
 
+func TestCatalogueReservations(t *testing.T) {
+	ctx := context.Background()
+	service, err := catalogueRegistry.Get(ctx)
+	require.NoError(t, err)
+
+	sock := catalogue.Sock{Name: "reserved sock", Price: usd(9999), Quantity: 3, Tags: []string{"blue"}}
+	sock.ID, err = service.AddSock(ctx, sock)
+	require.NoError(t, err)
+	defer service.DeleteSock(ctx, sock.ID)
+
+	requireStock := func(quantity int) {
+		res, err := service.Get(ctx, sock.ID, "")
+		require.NoError(t, err)
+		require.Equal(t, quantity, res.Quantity)
+	}
+	reserve := func(reservationID string, quantity int) error {
+		return service.Reserve(ctx, sock.ID+reservationID, []catalogue.ReservedItem{{SockID: sock.ID, Quantity: quantity}})
+	}
+
+	{
+		// Reserve 2 of the 3 socks; reserving again has no effect
+		require.NoError(t, reserve("a", 2))
+		require.NoError(t, reserve("a", 2))
+		require.NoError(t, reserve("b", 1))
+		requireStock(3)
+	}
+
+	{
+		// The socks are all reserved
+		require.Error(t, reserve("c", 1))
+		require.NoError(t, service.Release(ctx, sock.ID+"b"))
+		require.Error(t, reserve("c", 2))
+	}
+
+	{
+		// Reserving is all-or-nothing
+		err := service.Reserve(ctx, sock.ID+"c", []catalogue.ReservedItem{{SockID: sock.ID, Quantity: 1}, {SockID: "nosuchsock", Quantity: 1}})
+		require.Error(t, err)
+		require.NoError(t, reserve("d", 1))
+		require.NoError(t, service.Release(ctx, sock.ID+"d"))
+	}
+
+	{
+		// Committing takes the socks out of stock, once
+		require.NoError(t, service.Commit(ctx, sock.ID+"a"))
+		requireStock(1)
+		require.NoError(t, service.Commit(ctx, sock.ID+"a"))
+		requireStock(1)
+		require.Error(t, service.Commit(ctx, sock.ID+"b"))
+	}
+
+	{
+		// Releasing a committed reservation returns the socks to stock, once
+		require.NoError(t, service.Release(ctx, sock.ID+"a"))
+		requireStock(3)
+		require.NoError(t, service.Release(ctx, sock.ID+"a"))
+		requireStock(3)
+	}
+}
+
+func TestCatalogueReservationsContended(t *testing.T) {
+	ctx := context.Background()
+	service, err := catalogueRegistry.Get(ctx)
+	require.NoError(t, err)
+
+	sock := catalogue.Sock{Name: "last sock", Price: usd(9999), Quantity: 1, Tags: []string{"blue"}}
+	sock.ID, err = service.AddSock(ctx, sock)
+	require.NoError(t, err)
+	defer service.DeleteSock(ctx, sock.ID)
+
+	// Only one of many concurrent reservations gets the last sock
+	var wg sync.WaitGroup
+	var reserved atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if service.Reserve(ctx, fmt.Sprintf("%v%v", sock.ID, i), []catalogue.ReservedItem{{SockID: sock.ID, Quantity: 1}}) == nil {
+				reserved.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), reserved.Load())
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, service.Release(ctx, fmt.Sprintf("%v%v", sock.ID, i)))
+	}
+}
+
+// Reservations are tested against a local catalogue with a short reservation timeout
+func TestCatalogueReservationsExpire(t *testing.T) {
+	ctx := context.Background()
+	db, err := sqlitereldb.NewSqliteRelDB(ctx)
+	require.NoError(t, err)
+	rates, err := money.NewFixedRates(ctx, money.DefaultExchangeRates)
+	require.NoError(t, err)
+	service, err := catalogue.NewCatalogueService(ctx, db, rates, "50ms")
+	require.NoError(t, err)
+
+	sock := catalogue.Sock{Name: "expiring sock", Price: usd(9999), Quantity: 1, Tags: []string{"blue"}}
+	sock.ID, err = service.AddSock(ctx, sock)
+	require.NoError(t, err)
+	defer service.DeleteSock(ctx, sock.ID)
+
+	items := []catalogue.ReservedItem{{SockID: sock.ID, Quantity: 1}}
+	require.NoError(t, service.Reserve(ctx, sock.ID+"a", items))
+	require.Error(t, service.Reserve(ctx, sock.ID+"b", items))
+
+	// Once the reservation expires, the sock can be reserved by somebody else, and the
+	// expired reservation can no longer be committed
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, service.Reserve(ctx, sock.ID+"b", items))
+	require.ErrorIs(t, service.Commit(ctx, sock.ID+"a"), catalogue.ErrNoReservation)
+	require.NoError(t, service.Release(ctx, sock.ID+"b"))
+
+	_, err = catalogue.NewCatalogueService(ctx, db, rates, "forever")
+	require.Error(t, err)
+}
+
 func requireSock(t *testing.T, a catalogue.Sock, bs []catalogue.Sock) {
 	require.True(t, hasSock(a, bs))
 }
@@ -2,11 +2,15 @@ package tests
 
 import (
 	"context"
+	"sort"
 	"testing"
 
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/cart"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/catalogue"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/frontend"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/order"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/token"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/user"
 	"github.com/blueprint-uservices/blueprint/runtime/core/registry"
 	"github.com/pkg/errors"
@@ -43,7 +47,12 @@ func init() {
 			return nil, err
 		}
 
-		return frontend.NewFrontend(ctx, user, catalogue, cart, order)
+		tokens, err := token.NewTokenService(ctx, "", token.DefaultTokenLifetime)
+		if err != nil {
+			return nil, err
+		}
+
+		return frontend.NewFrontend(ctx, user, catalogue, cart, order, tokens)
 	})
 }
 
@@ -57,7 +66,7 @@ func TestFrontend(t *testing.T) {
 
 	{
 		// Load the catalogue
-		items, err := fe.ListItems(ctx, nil, "", 1, 1000)
+		items, err := fe.ListItems(ctx, nil, "", 1, 1000, "")
 		require.NoError(t, err)
 		require.True(t, socksequal(items, socks))
 	}
@@ -65,7 +74,7 @@ func TestFrontend(t *testing.T) {
 	{
 		// Get socks by tags
 		for _, tag := range alltags {
-			items, err := fe.ListItems(ctx, []string{tag}, "", 1, 1000)
+			items, err := fe.ListItems(ctx, []string{tag}, "", 1, 1000, "")
 			require.NoError(t, err)
 
 			require.True(t, socksequal(items, getsocks(tag)), "ListItems tag=\"%v\"", tag)
@@ -76,7 +85,7 @@ func TestFrontend(t *testing.T) {
 		// Get socks with 2 tags
 		for _, tag1 := range alltags {
 			for _, tag2 := range alltags {
-				items, err := fe.ListItems(ctx, []string{tag1, tag2}, "", 1, 1000)
+				items, err := fe.ListItems(ctx, []string{tag1, tag2}, "", 1, 1000, "")
 				require.NoError(t, err)
 
 				require.True(t, socksequal(items, getsocks(tag1, tag2)), "ListItems tag1=\"%v\" tag2=\"%v\"", tag1, tag2)
@@ -86,7 +95,7 @@ func TestFrontend(t *testing.T) {
 
 	{
 		// Get socks with all tags
-		items, err := fe.ListItems(ctx, alltags, "", 1, 1000)
+		items, err := fe.ListItems(ctx, alltags, "", 1, 1000, "")
 		require.NoError(t, err)
 
 		require.True(t, socksequal(items, socks), "ListItems tags=[%v]", alltags)
@@ -101,11 +110,11 @@ func TestFrontend(t *testing.T) {
 
 	{
 		// Get socks individually
-		items, err := fe.ListItems(ctx, nil, "", 1, 1000)
+		items, err := fe.ListItems(ctx, nil, "", 1, 1000, "")
 		require.NoError(t, err)
 		require.True(t, socksequal(items, socks))
 		for _, sock := range items {
-			sock2, err := fe.GetSock(ctx, sock.ID)
+			sock2, err := fe.GetSock(ctx, sock.ID, "")
 			require.NoError(t, err)
 			require.True(t, sockequal(sock, sock2), "GetSock sock=\"%v\"", sock.Name)
 		}
@@ -113,13 +122,13 @@ func TestFrontend(t *testing.T) {
 
 	{
 		// Get non-existent sock
-		_, err := fe.GetSock(ctx, "hello world")
+		_, err := fe.GetSock(ctx, "hello world", "")
 		require.Error(t, err)
 	}
 
 	{
 		// Get non-existent tag
-		items, err := fe.ListItems(ctx, []string{"nonexistent tag"}, "", 1, 1000)
+		items, err := fe.ListItems(ctx, []string{"nonexistent tag"}, "", 1, 1000, "")
 		require.NoError(t, err)
 		require.Empty(t, items)
 	}
@@ -129,25 +138,28 @@ func TestFrontend(t *testing.T) {
 
 	{
 		// Logging in should fail
-		sessionID, _, err := fe.Login(ctx, "", username, password)
+		sessionToken, _, err := fe.Login(ctx, "", username, password)
 		require.Error(t, err)
-		require.Equal(t, sessionID, "")
+		require.Equal(t, sessionToken, "")
 	}
 
 	{
 		// Get the catalogue
-		items, err := fe.ListItems(ctx, nil, "", 1, 1000)
+		items, err := fe.ListItems(ctx, nil, "", 1, 1000, "")
 		require.NoError(t, err)
 		require.True(t, socksequal(items, socks))
 
+		// Order the best-stocked socks, as some are nearly out of stock
+		sort.Slice(items, func(i, j int) bool { return items[i].Quantity > items[j].Quantity })
+
 		// Add a sock to the cart
-		sessionID, err := fe.AddItem(ctx, "", items[0].ID)
+		sessionToken, err := fe.AddItem(ctx, "", items[0].ID, "")
 		require.NoError(t, err)
-		require.NotEqual(t, "", sessionID)
+		require.NotEqual(t, "", sessionToken)
 
 		// Get the cart; should have 1 item
 		{
-			crt, err := fe.GetCart(ctx, sessionID)
+			crt, err := fe.GetCart(ctx, sessionToken)
 			require.NoError(t, err)
 			require.Len(t, crt, 1)
 			require.Contains(t, crt, cart.Item{ID: items[0].ID, Quantity: 1, UnitPrice: items[0].Price})
@@ -155,20 +167,20 @@ func TestFrontend(t *testing.T) {
 
 		// Add a few more socks
 		for i := 0; i < 3; i++ {
-			newSessionID, err := fe.AddItem(ctx, sessionID, items[0].ID)
+			newSessionToken, err := fe.AddItem(ctx, sessionToken, items[0].ID, "")
 			require.NoError(t, err)
-			require.Equal(t, sessionID, newSessionID)
+			require.Equal(t, sessionToken, newSessionToken)
 		}
 
 		{
-			newSessionID, err := fe.AddItem(ctx, sessionID, items[3].ID)
+			newSessionToken, err := fe.AddItem(ctx, sessionToken, items[3].ID, "")
 			require.NoError(t, err)
-			require.Equal(t, sessionID, newSessionID)
+			require.Equal(t, sessionToken, newSessionToken)
 		}
 
 		{
 			// Get and check the cart contents
-			crt, err := fe.GetCart(ctx, sessionID)
+			crt, err := fe.GetCart(ctx, sessionToken)
 			require.NoError(t, err)
 			require.Len(t, crt, 2)
 			require.Contains(t, crt, cart.Item{ID: items[0].ID, Quantity: 4, UnitPrice: items[0].Price})
@@ -176,13 +188,16 @@ func TestFrontend(t *testing.T) {
 		}
 
 		// Register a user
-		userSessionID, err := fe.Register(ctx, sessionID, username, password, "my@email", "firstn", "lastn")
+		userToken, err := fe.Register(ctx, sessionToken, username, password, "my@email", "firstn", "lastn")
+		require.NoError(t, err)
+		require.NotEqual(t, sessionToken, userToken)
+		u, err := fe.GetUser(ctx, userToken)
 		require.NoError(t, err)
-		require.NotEqual(t, sessionID, userSessionID)
+		userID := u.UserID
 
 		{
 			// Check the cart was migrated to the user
-			crt, err := fe.GetCart(ctx, userSessionID)
+			crt, err := fe.GetCart(ctx, userToken)
 			require.NoError(t, err)
 			require.Len(t, crt, 2)
 			require.Contains(t, crt, cart.Item{ID: items[0].ID, Quantity: 4, UnitPrice: items[0].Price})
@@ -191,14 +206,14 @@ func TestFrontend(t *testing.T) {
 
 		// Update item quantity
 		{
-			newSessionID, err := fe.UpdateItem(ctx, userSessionID, items[0].ID, 2)
+			newSessionToken, err := fe.UpdateItem(ctx, userToken, items[0].ID, 2, "")
 			require.NoError(t, err)
-			require.Equal(t, userSessionID, newSessionID)
+			require.Equal(t, userToken, newSessionToken)
 		}
 
 		{
 			// Get and check the cart contents
-			crt, err := fe.GetCart(ctx, userSessionID)
+			crt, err := fe.GetCart(ctx, userToken)
 			require.NoError(t, err)
 			require.Len(t, crt, 2)
 			require.Contains(t, crt, cart.Item{ID: items[0].ID, Quantity: 2, UnitPrice: items[0].Price})
@@ -207,21 +222,22 @@ func TestFrontend(t *testing.T) {
 
 		{
 			// Get customer's orders
-			orders, err := fe.GetOrders(ctx, userSessionID)
+			orders, err := fe.GetOrders(ctx, userToken, order.OrderQuery{})
 			require.NoError(t, err)
-			require.Empty(t, orders)
+			require.Empty(t, orders.Orders)
+			require.Empty(t, orders.Cursor)
 		}
 
 		{
 			// Add a card and an address
-			addressID, err := fe.PostAddress(ctx, userSessionID, user.Address{Street: "Home"})
+			addressID, err := fe.PostAddress(ctx, userToken, user.Address{Street: "Home"})
 			require.NoError(t, err)
 
-			cardID, err := fe.PostCard(ctx, userSessionID, user.Card{LongNum: "1234123412341234", CCV: "574"})
+			cardID, err := fe.PostCard(ctx, userToken, user.Card{LongNum: "4111111111111111", Expires: "1239", CCV: "574"})
 			require.NoError(t, err)
 
 			// Check they exist on the user
-			u, err := fe.GetUser(ctx, userSessionID)
+			u, err = fe.GetUser(ctx, userToken)
 			require.NoError(t, err)
 			require.Len(t, u.Cards, 1)
 			require.Equal(t, cardID, u.Cards[0].ID)
@@ -229,43 +245,110 @@ func TestFrontend(t *testing.T) {
 			require.Equal(t, addressID, u.Addresses[0].ID)
 
 			// Check we can get the card
-			crd, err := fe.GetCard(ctx, cardID)
+			crd, err := fe.GetCard(ctx, userToken, cardID)
 			require.NoError(t, err)
 			require.Equal(t, cardID, crd.ID)
-			require.Equal(t, "1234123412341234", crd.LongNum)
+			require.Equal(t, "4111111111111111", crd.LongNum)
 
 			// Check we can get the address
-			addr, err := fe.GetAddress(ctx, addressID)
+			addr, err := fe.GetAddress(ctx, userToken, addressID)
 			require.NoError(t, err)
 			require.Equal(t, addressID, addr.ID)
 			require.Equal(t, "Home", addr.Street)
 
 			// Place an order
-			ordr, err := fe.NewOrder(ctx, userSessionID, addressID, cardID, userSessionID)
+			ordr, err := fe.NewOrder(ctx, userToken, addressID, cardID, "", "checkout")
 			require.NoError(t, err)
 			require.Equal(t, "Home", ordr.Address.Street)
-			require.Equal(t, "1234123412341234", ordr.Card.LongNum)
+			require.Equal(t, "4111111111111111", ordr.Card.LongNum)
 			require.Len(t, ordr.Items, 2)
-			require.Equal(t, 2*items[0].Price+items[3].Price+4.99, ordr.Total)
-			require.Equal(t, userSessionID, ordr.CustomerID)
+			require.Equal(t, money.Money{Amount: 2*items[0].Price.Amount + items[3].Price.Amount + 499, Currency: "USD"}, ordr.Total)
+			require.Equal(t, userID, ordr.CustomerID)
+
+			// Retrying the order returns the original order
+			retried, err := fe.NewOrder(ctx, userToken, addressID, cardID, "", "checkout")
+			require.NoError(t, err)
+			require.Equal(t, ordr.ID, retried.ID)
 
 			// Cart should be empty
-			crt, err := fe.GetCart(ctx, userSessionID)
+			crt, err := fe.GetCart(ctx, userToken)
 			require.NoError(t, err)
 			require.Empty(t, crt)
 
 			// User should have 1 order
-			orders, err := fe.GetOrders(ctx, userSessionID)
+			orders, err := fe.GetOrders(ctx, userToken, order.OrderQuery{})
+			require.NoError(t, err)
+			require.Len(t, orders.Orders, 1)
+			require.Equal(t, ordr.ID, orders.Orders[0].ID) // The order may have shipped since
+
+			// Filtering by status excludes the order
+			orders, err = fe.GetOrders(ctx, userToken, order.OrderQuery{Statuses: []string{"refunded"}})
+			require.NoError(t, err)
+			require.Empty(t, orders.Orders)
+
+			// The order can be refunded whether or not it has been shipped yet
+			refunded, err := fe.RefundOrder(ctx, userToken, ordr.ID)
+			require.NoError(t, err)
+			require.Equal(t, "refunded", refunded.Status)
+			_, err = fe.CancelOrder(ctx, userToken, ordr.ID)
+			require.Error(t, err)
+
+			// Logging in again starts a new session for the same user
+			loginToken, _, err := fe.Login(ctx, "", username, password)
+			require.NoError(t, err)
+			orders, err = fe.GetOrders(ctx, loginToken, order.OrderQuery{})
+			require.NoError(t, err)
+			require.Len(t, orders.Orders, 1)
+
+			// Another user can't see or act on the user's orders, addresses, or cards
+			otherToken, err := fe.Register(ctx, "", "anna", "anothersecret", "other@email", "firstn", "lastn")
 			require.NoError(t, err)
-			require.Len(t, orders, 1)
-			require.Equal(t, ordr, orders[0])
+			other, err := fe.GetUser(ctx, otherToken)
+			require.NoError(t, err)
+			defer func() {
+				usr, err := userServiceRegistry.Get(ctx)
+				require.NoError(t, err)
+				require.NoError(t, usr.Delete(ctx, "customers", other.UserID))
+			}()
+
+			orders, err = fe.GetOrders(ctx, otherToken, order.OrderQuery{})
+			require.NoError(t, err)
+			require.Empty(t, orders.Orders)
+			_, err = fe.GetOrder(ctx, otherToken, ordr.ID)
+			require.Error(t, err)
+			_, err = fe.CancelOrder(ctx, otherToken, ordr.ID)
+			require.Error(t, err)
+			_, err = fe.RefundOrder(ctx, otherToken, ordr.ID)
+			require.Error(t, err)
+			_, err = fe.GetAddress(ctx, otherToken, addressID)
+			require.Error(t, err)
+			_, err = fe.GetCard(ctx, otherToken, cardID)
+			require.Error(t, err)
+			_, err = fe.NewOrder(ctx, otherToken, addressID, cardID, "", "")
+			require.Error(t, err)
+
+			// Requests without the token of a logged in session are refused, including
+			// with the session of a customer who isn't logged in, with the user's ID in
+			// place of a token, and with a tampered token
+			for _, invalid := range []string{"", sessionToken, userID, userToken + "x"} {
+				_, err = fe.GetUser(ctx, invalid)
+				require.Error(t, err)
+				_, err = fe.GetOrders(ctx, invalid, order.OrderQuery{})
+				require.Error(t, err)
+				_, err = fe.GetOrder(ctx, invalid, ordr.ID)
+				require.Error(t, err)
+				_, err = fe.GetCard(ctx, invalid, cardID)
+				require.Error(t, err)
+				_, err = fe.PostAddress(ctx, invalid, user.Address{Street: "Elsewhere"})
+				require.Error(t, err)
+			}
 		}
 
 		{
 			// Delete the user
 			usr, err := userServiceRegistry.Get(ctx)
 			require.NoError(t, err)
-			err = usr.Delete(ctx, "customers", userSessionID)
+			err = usr.Delete(ctx, "customers", userID)
 			require.NoError(t, err)
 		}
 
@@ -290,9 +373,9 @@ func initCatalogue() error {
 	return nil
 }
 
-func sock(name, description string, price float32, qty int, url1, url2 string, tags ...string) catalogue.Sock {
+func sock(name, description string, price int64, qty int, url1, url2 string, tags ...string) catalogue.Sock {
 	return catalogue.Sock{Name: name, Description: description,
-		Price: price, Quantity: qty, ImageURL_1: url1, ImageURL_2: url2, Tags: tags}
+		Price: usd(price), Quantity: qty, ImageURL_1: url1, ImageURL_2: url2, Tags: tags}
 }
 
 func sockmap(socks []catalogue.Sock) map[string]catalogue.Sock {
@@ -331,16 +414,16 @@ outer:
 var alltags = []string{"brown", "geek", "formal", "blue", "skin", "red", "action", "sport", "black", "magic", "green"}
 
 var socks = []catalogue.Sock{
-	sock("Weave special", "Limited issue Weave socks.", 17.15, 33, "/catalogue/images/weave1.jpg", "/catalogue/images/weave2.jpg", "geek", "black"),
-	sock("Nerd leg", "For all those leg lovers out there. A perfect example of a swivel chair trained calf. Meticulously trained on a diet of sitting and Pina Coladas. Phwarr...", 7.99, 115, "/catalogue/images/bit_of_leg_1.jpeg", "/catalogue/images/bit_of_leg_2.jpeg", "blue", "skin"),
-	sock("Crossed", "A mature sock, crossed, with an air of nonchalance.", 17.32, 738, "/catalogue/images/cross_1.jpeg", "/catalogue/images/cross_2.jpeg", "formal", "blue", "red", "action"),
-	sock("SuperSport XL", "Ready for action. Engineers: be ready to smash that next bug! Be ready, with these super-action-sport-masterpieces. This particular engineer was chased away from the office with a stick.", 15.00, 820, "/catalogue/images/puma_1.jpeg", "/catalogue/images/puma_2.jpeg", "formal", "sport", "black"),
-	sock("Holy", "Socks fit for a Messiah. You too can experience walking in water with these special edition beauties. Each hole is lovingly proggled to leave smooth edges. The only sock approved by a higher power.", 99.99, 1, "/catalogue/images/holy_1.jpeg", "/catalogue/images/holy_2.jpeg", "action", "magic"),
-	sock("YouTube.sock", "We were not paid to sell this sock. It's just a bit geeky.", 10.99, 801, "/catalogue/images/youtube_1.jpeg", "/catalogue/images/youtube_2.jpeg", "geek", "formal"),
-	sock("Figueroa", "enim officia aliqua excepteur esse deserunt quis aliquip nostrud anim", 14, 808, "/catalogue/images/WAT.jpg", "/catalogue/images/WAT2.jpg", "formal", "blue", "green"),
-	sock("Classic", "Keep it simple.", 12, 127, "/catalogue/images/classic.jpg", "/catalogue/images/classic2.jpg", "brown", "green"),
-	sock("Colourful", "proident occaecat irure et excepteur labore minim nisi amet irure", 18, 438, "/catalogue/images/colourful_socks.jpg", "/catalogue/images/colourful_socks.jpg", "brown", "blue"),
-	sock("Cat socks", "consequat amet cupidatat minim laborum tempor elit ex consequat in", 15, 175, "/catalogue/images/catsocks.jpg", "/catalogue/images/catsocks2.jpg", "brown", "formal", "green"),
+	sock("Weave special", "Limited issue Weave socks.", 1715, 33, "/catalogue/images/weave1.jpg", "/catalogue/images/weave2.jpg", "geek", "black"),
+	sock("Nerd leg", "For all those leg lovers out there. A perfect example of a swivel chair trained calf. Meticulously trained on a diet of sitting and Pina Coladas. Phwarr...", 799, 115, "/catalogue/images/bit_of_leg_1.jpeg", "/catalogue/images/bit_of_leg_2.jpeg", "blue", "skin"),
+	sock("Crossed", "A mature sock, crossed, with an air of nonchalance.", 1732, 738, "/catalogue/images/cross_1.jpeg", "/catalogue/images/cross_2.jpeg", "formal", "blue", "red", "action"),
+	sock("SuperSport XL", "Ready for action. Engineers: be ready to smash that next bug! Be ready, with these super-action-sport-masterpieces. This particular engineer was chased away from the office with a stick.", 1500, 820, "/catalogue/images/puma_1.jpeg", "/catalogue/images/puma_2.jpeg", "formal", "sport", "black"),
+	sock("Holy", "Socks fit for a Messiah. You too can experience walking in water with these special edition beauties. Each hole is lovingly proggled to leave smooth edges. The only sock approved by a higher power.", 9999, 1, "/catalogue/images/holy_1.jpeg", "/catalogue/images/holy_2.jpeg", "action", "magic"),
+	sock("YouTube.sock", "We were not paid to sell this sock. It's just a bit geeky.", 1099, 801, "/catalogue/images/youtube_1.jpeg", "/catalogue/images/youtube_2.jpeg", "geek", "formal"),
+	sock("Figueroa", "enim officia aliqua excepteur esse deserunt quis aliquip nostrud anim", 1400, 808, "/catalogue/images/WAT.jpg", "/catalogue/images/WAT2.jpg", "formal", "blue", "green"),
+	sock("Classic", "Keep it simple.", 1200, 127, "/catalogue/images/classic.jpg", "/catalogue/images/classic2.jpg", "brown", "green"),
+	sock("Colourful", "proident occaecat irure et excepteur labore minim nisi amet irure", 1800, 438, "/catalogue/images/colourful_socks.jpg", "/catalogue/images/colourful_socks.jpg", "brown", "blue"),
+	sock("Cat socks", "consequat amet cupidatat minim laborum tempor elit ex consequat in", 1500, 175, "/catalogue/images/catsocks.jpg", "/catalogue/images/catsocks2.jpg", "brown", "formal", "green"),
 }
 
 func socksequal(as, bs []catalogue.Sock) bool {
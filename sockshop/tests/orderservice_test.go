@@ -5,8 +5,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/catalogue"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/order"
 	"github.com/blueprint-uservices/blueprint/runtime/core/registry"
+	"github.com/blueprint-uservices/blueprint/runtime/plugins/golang"
 	"github.com/blueprint-uservices/blueprint/runtime/plugins/simplenosqldb"
 	"github.com/stretchr/testify/require"
 )
@@ -30,6 +33,11 @@ func init() {
 			return nil, err
 		}
 
+		catalogue, err := catalogueRegistry.Get(ctx)
+		if err != nil {
+			return nil, err
+		}
+
 		payment, err := paymentServiceRegistry.Get(ctx)
 		if err != nil {
 			return nil, err
@@ -45,7 +53,22 @@ func init() {
 			return nil, err
 		}
 
-		return order.NewOrderService(ctx, user, cart, payment, shipping, orderdb)
+		rates, err := money.NewFixedRates(ctx, money.DefaultExchangeRates)
+		if err != nil {
+			return nil, err
+		}
+
+		orders, err := order.NewOrderService(ctx, user, cart, catalogue, payment, shipping, shipmentEvents, orderdb, rates, "")
+		if err != nil {
+			return nil, err
+		}
+
+		// Make sure the order service is started if it's local; Blueprint starts it otherwise
+		go func() {
+			orders.(golang.Runnable).Run(ctx)
+		}()
+
+		return orders, nil
 	})
 }
 
@@ -57,19 +80,25 @@ func TestOrderService(t *testing.T) {
 	orderService, err := ordersRegistry.Get(ctx)
 
 	// Try placing an empty order
-	_, err = orderService.NewOrder(ctx, "", "", "", "")
+	_, err = orderService.NewOrder(ctx, "", "", "", "", "", "")
 	require.Error(t, err)
 
 	// Try placing an order without a user
-	_, err = orderService.NewOrder(ctx, "jon", "jonsaddress", "jonscard", "jon")
+	_, err = orderService.NewOrder(ctx, "jon", "jonsaddress", "jonscard", "jon", "", "")
 	require.Error(t, err)
 
 	// Add our user
 	user, err := userServiceRegistry.Get(ctx)
 	require.NoError(t, err)
-	userId, err := user.PostUser(ctx, deepak)
+	// Passwords aren't sent to a deployed user service in a User, so the user is
+	// registered, then given an address and card
+	userId, err := user.Register(ctx, deepak.Username, deepak.Password, deepak.Email, deepak.FirstName, deepak.LastName)
 	require.NoError(t, err)
 	defer user.Delete(ctx, "customers", userId)
+	_, err = user.PostAddress(ctx, userId, deepak.Addresses[0])
+	require.NoError(t, err)
+	_, err = user.PostCard(ctx, userId, deepak.Cards[0])
+	require.NoError(t, err)
 
 	// Get the card and address IDs
 	users, err := user.GetUsers(ctx, userId)
@@ -79,7 +108,7 @@ func TestOrderService(t *testing.T) {
 	addressId := users[0].Addresses[0].ID
 
 	// Try placing an order without an item
-	_, err = orderService.NewOrder(ctx, userId, addressId, cardId, userId)
+	_, err = orderService.NewOrder(ctx, userId, addressId, cardId, userId, "", "")
 	require.Error(t, err)
 
 	// Put some items in the cart
@@ -87,15 +116,59 @@ func TestOrderService(t *testing.T) {
 	require.NoError(t, err)
 	cart.AddItem(ctx, userId, myitem)
 
+	// The item's price has gone up in the catalogue since it was added to the cart
+	catalogueService, err := catalogueRegistry.Get(ctx)
+	require.NoError(t, err)
+	mysock := catalogue.Sock{ID: myitem.ID, Name: "My Sock", Price: usd(myitem.UnitPrice.Amount + 200), Quantity: 100, Tags: []string{"blue"}}
+	_, err = catalogueService.AddSock(ctx, mysock)
+	require.NoError(t, err)
+	defer catalogueService.DeleteSock(ctx, mysock.ID)
+
+	// The order is refused until the cart is updated to the new price
+	_, staleErr := orderService.NewOrder(ctx, userId, addressId, cardId, userId, "", "")
+	require.Error(t, staleErr)
+	repriced := myitem
+	repriced.UnitPrice = mysock.Price
+	require.NoError(t, cart.UpdateItem(ctx, userId, repriced))
+
+	// An unknown coupon is rejected
+	_, couponErr := orderService.NewOrder(ctx, userId, addressId, cardId, userId, "FREESOCKS", "")
+	require.Error(t, couponErr)
+
 	// Place the order
 	require.NoError(t, err)
-	order, err := orderService.NewOrder(ctx, userId, addressId, cardId, userId)
+	order, err := orderService.NewOrder(ctx, userId, addressId, cardId, userId, "", "deepaks-order")
 	require.NoError(t, err)
 	require.Equal(t, userId, order.CustomerID)
+	require.Equal(t, usd(499), order.Price.Shipping)
+	require.Equal(t, usd(order.Price.Subtotal.Amount+order.Price.Shipping.Amount), order.Price.Total)
+	require.Equal(t, "deepaks-order", order.IdempotencyKey)
+	require.Equal(t, "paid", order.Status)
+
+	// Retrying the order returns the original order rather than placing another
+	retried, err := orderService.NewOrder(ctx, userId, addressId, cardId, userId, "", "deepaks-order")
+	require.NoError(t, err)
+	require.Equal(t, order.ID, retried.ID)
+
+	// The order was paid for once
+	payments, err := paymentServiceRegistry.Get(ctx)
+	require.NoError(t, err)
+	paid, err := payments.GetPayment(ctx, order.AuthorisationID)
+	require.NoError(t, err)
+	require.Equal(t, "captured", paid.Status)
+	require.Equal(t, order.Total, paid.Amount)
+	require.Equal(t, cardId, paid.CardID)
+
+	// The order's items were taken out of stock once
+	stocked, err := catalogueService.Get(ctx, mysock.ID, "")
+	require.NoError(t, err)
+	require.Equal(t, mysock.Quantity-myitem.Quantity, stocked.Quantity)
 
-	// Check we can look up the order
+	// Check we can look up the order; the shipment might already have been shipped
 	order2, err := orderService.GetOrder(ctx, order.ID)
 	require.NoError(t, err)
+	require.Equal(t, order.Shipment.ID, order2.Shipment.ID)
+	order2.Shipment, order2.Status, order2.History = order.Shipment, order.Status, order.History
 	require.Equal(t, order, order2)
 
 	// Wait up to 30 seconds for the status to change
@@ -111,4 +184,32 @@ func TestOrderService(t *testing.T) {
 		require.Equal(t, "shipped", shipment2.Status)
 	}
 
+	// The order reflects the shipment's status
+	order3, err := orderService.GetOrder(ctx, order.ID)
+	require.NoError(t, err)
+	require.Equal(t, "shipped", order3.Shipment.Status)
+
+	// The order's status follows the shipment's status
+	require.Eventually(t, func() bool {
+		order3, err = orderService.GetOrder(ctx, order.ID)
+		return err == nil && order3.Status == "shipped"
+	}, 5*time.Second, 100*time.Millisecond)
+	require.Len(t, order3.History, 3)
+
+	// A shipped order can't be cancelled, but it can be refunded
+	_, err = orderService.CancelOrder(ctx, order.ID)
+	require.Error(t, err)
+	order4, err := orderService.RefundOrder(ctx, order.ID)
+	require.NoError(t, err)
+	require.Equal(t, "refunded", order4.Status)
+	require.Len(t, order4.History, 4)
+	paid, err = payments.GetPayment(ctx, order.AuthorisationID)
+	require.NoError(t, err)
+	require.Equal(t, "refunded", paid.Status)
+
+	// Refunding again has no effect
+	order4, err = orderService.RefundOrder(ctx, order.ID)
+	require.NoError(t, err)
+	require.Equal(t, "refunded", order4.Status)
+	require.Len(t, order4.History, 4)
 }
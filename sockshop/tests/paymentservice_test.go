@@ -4,8 +4,11 @@ import (
 	"context"
 	"testing"
 
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/payment"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/user"
 	"github.com/blueprint-uservices/blueprint/runtime/core/registry"
+	"github.com/blueprint-uservices/blueprint/runtime/plugins/simplenosqldb"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -18,10 +21,36 @@ var paymentServiceRegistry = registry.NewServiceRegistry[payment.PaymentService]
 func init() {
 	// If the tests are run locally, we fall back to this PaymentService implementation
 	paymentServiceRegistry.Register("local", func(ctx context.Context) (payment.PaymentService, error) {
-		return payment.NewPaymentService(ctx, "500")
+		gateway, err := payment.NewFakeGateway(ctx, payment.DefaultGatewayScript)
+		if err != nil {
+			return nil, err
+		}
+
+		db, err := simplenosqldb.NewSimpleNoSQLDB(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		fraud, err := payment.NewFraudScorer(ctx, db, payment.DefaultFraudRules)
+		if err != nil {
+			return nil, err
+		}
+
+		rates, err := money.NewFixedRates(ctx, money.DefaultExchangeRates)
+		if err != nil {
+			return nil, err
+		}
+
+		return payment.NewPaymentService(ctx, gateway, fraud, db, rates, "500.00 USD")
 	})
 }
 
+// Cards with scripted outcomes in the default fake gateway
+var (
+	declinedCard = user.Card{ID: "declined", LongNum: "4000000000000002", Expires: "1239"}
+	timeoutCard  = user.Card{ID: "timeout", LongNum: "4000000000000119", Expires: "1239"}
+)
+
 // We write the service test as a single test because we don't want to tear down and
 // spin up the Mongo backends between tests, so state will persist in the database
 // between tests.
@@ -30,11 +59,90 @@ func TestPaymentService(t *testing.T) {
 	service, err := paymentServiceRegistry.Get(ctx)
 	assert.NoError(t, err)
 
-	rsp, err := service.Authorise(ctx, 1000)
+	rsp, err := service.Authorise(ctx, "deepak", mpisb, visa, usd(100000))
+	assert.NoError(t, err)
+	assert.False(t, rsp.Authorised)
+	if assert.Len(t, rsp.Reasons, 1) {
+		assert.Equal(t, payment.ReasonAmountLimit, rsp.Reasons[0].Code)
+	}
+
+	// Invalid and expired cards are declined, with the reasons why
+	invalid := visa
+	invalid.LongNum = "4012888888881882"
+	invalid.Expires = "0120"
+	rsp, err = service.Authorise(ctx, "deepak", mpisb, invalid, usd(10000))
 	assert.NoError(t, err)
 	assert.False(t, rsp.Authorised)
+	if assert.Len(t, rsp.Reasons, 2) {
+		assert.Equal(t, payment.ReasonInvalidCardNumber, rsp.Reasons[0].Code)
+		assert.Equal(t, payment.ReasonCardExpired, rsp.Reasons[1].Code)
+	}
 
-	rsp, err = service.Authorise(ctx, 100)
+	// Payments are scored for fraud, but no one risk declines a payment
+	foreign := visa
+	foreign.Country = "France"
+	rsp, err = service.Authorise(ctx, "deepak", mpisb, foreign, usd(10000))
 	assert.NoError(t, err)
 	assert.True(t, rsp.Authorised)
+	assert.Equal(t, 30, rsp.RiskScore)
+	if assert.Len(t, rsp.Reasons, 1) {
+		assert.Equal(t, payment.ReasonCountryMismatch, rsp.Reasons[0].Code)
+	}
+	assert.NoError(t, service.Void(ctx, rsp.ID))
+
+	rsp, err = service.Authorise(ctx, "deepak", mpisb, visa, usd(10000))
+	assert.NoError(t, err)
+	assert.True(t, rsp.Authorised)
+	assert.NotEmpty(t, rsp.ID)
+
+	// Authorisations can be voided, repeatedly
+	assert.NoError(t, service.Void(ctx, rsp.ID))
+	assert.NoError(t, service.Void(ctx, rsp.ID))
+	assert.Error(t, service.Void(ctx, "nonexistent"))
+	assert.Error(t, service.Capture(ctx, rsp.ID))
+
+	// Captured payments can be refunded, repeatedly
+	rsp, err = service.Authorise(ctx, "deepak", mpisb, visa, usd(10000))
+	assert.NoError(t, err)
+	assert.True(t, rsp.Authorised)
+	assert.NoError(t, service.Capture(ctx, rsp.ID))
+	assert.Error(t, service.Void(ctx, rsp.ID))
+	assert.NoError(t, service.Refund(ctx, rsp.ID))
+	assert.NoError(t, service.Refund(ctx, rsp.ID))
+
+	// The ledger records the payment and its transactions
+	paid, err := service.GetPayment(ctx, rsp.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, payment.StatusRefunded, paid.Status)
+	assert.Equal(t, "************1881", paid.Card)
+	assert.Equal(t, usd(10000), paid.Amount)
+	assert.Equal(t, "deepak", paid.CustomerID)
+	if assert.Len(t, paid.Transactions, 3) {
+		assert.Equal(t, payment.TransactionAuthorise, paid.Transactions[0].Type)
+		assert.Equal(t, payment.TransactionCapture, paid.Transactions[1].Type)
+		assert.Equal(t, payment.TransactionRefund, paid.Transactions[2].Type)
+	}
+
+	// Payments are made in the order's currency; 500.00 EUR is above the limit of
+	// 500.00 USD once converted, but 100.00 EUR is not
+	rsp, err = service.Authorise(ctx, "deepak", mpisb, visa, money.Money{Amount: 50000, Currency: "EUR"})
+	assert.NoError(t, err)
+	assert.False(t, rsp.Authorised)
+	rsp, err = service.Authorise(ctx, "deepak", mpisb, visa, money.Money{Amount: 10000, Currency: "EUR"})
+	assert.NoError(t, err)
+	assert.True(t, rsp.Authorised)
+	paid, err = service.GetPayment(ctx, rsp.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, money.Money{Amount: 10000, Currency: "EUR"}, paid.Amount)
+	assert.NoError(t, service.Void(ctx, rsp.ID))
+
+	// The gateway declines some cards, and times out for others
+	rsp, err = service.Authorise(ctx, "deepak", mpisb, declinedCard, usd(10000))
+	assert.NoError(t, err)
+	assert.False(t, rsp.Authorised)
+	if assert.Len(t, rsp.Reasons, 1) {
+		assert.Equal(t, payment.ReasonIssuerDeclined, rsp.Reasons[0].Code)
+	}
+	_, err = service.Authorise(ctx, "deepak", mpisb, timeoutCard, usd(10000))
+	assert.Error(t, err)
 }
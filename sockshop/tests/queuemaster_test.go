@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/queuemaster"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/shipping"
+	"github.com/blueprint-uservices/blueprint/runtime/core/registry"
+	"github.com/stretchr/testify/require"
+)
+
+// Tests acquire a QueueMaster instance using a service registry.
+// This enables us to run local unit tests, while also enabling
+// the Blueprint test plugin to auto-generate tests
+// for different deployments when compiling an application.
+var queueMasterRegistry = registry.NewServiceRegistry[queuemaster.QueueMaster]("queue_master")
+
+func init() {
+	// If the tests are run locally, we use the queue master started along with the local ShippingService
+	queueMasterRegistry.Register("local", func(ctx context.Context) (queuemaster.QueueMaster, error) {
+		if _, err := shippingRegistry.Get(ctx); err != nil {
+			return nil, err
+		}
+		return queueMaster, nil
+	})
+}
+
+func TestQueueMasterDeadLetters(t *testing.T) {
+	ctx := context.Background()
+
+	qmaster, err := queueMasterRegistry.Get(ctx)
+	require.NoError(t, err)
+	shipService, err := shippingRegistry.Get(ctx)
+	require.NoError(t, err)
+
+	// A shipment that can be shipped is not dead-lettered
+	shipment := shipping.Shipment{ID: "deadletters", Name: "jon", Status: shipping.StatusAwaiting}
+	_, err = shipService.PostShipping(ctx, shipment)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		shipped, err := shipService.GetShipment(ctx, shipment.ID)
+		return err == nil && shipped.Status == shipping.StatusShipped
+	}, 5*time.Second, 10*time.Millisecond)
+
+	letters, err := qmaster.DeadLetters(ctx)
+	require.NoError(t, err)
+	for _, letter := range letters {
+		require.NotEqual(t, shipment.ID, letter.Shipment.ID)
+	}
+
+	// Only dead-lettered shipments can be replayed
+	require.Error(t, qmaster.ReplayDeadLetter(ctx, shipment.ID))
+}
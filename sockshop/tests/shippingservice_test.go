@@ -7,7 +7,9 @@ import (
 
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/queuemaster"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/shipping"
+	"github.com/blueprint-uservices/blueprint/runtime/core/backend"
 	"github.com/blueprint-uservices/blueprint/runtime/core/registry"
+	"github.com/blueprint-uservices/blueprint/runtime/plugins/golang"
 	"github.com/blueprint-uservices/blueprint/runtime/plugins/simplenosqldb"
 	"github.com/blueprint-uservices/blueprint/runtime/plugins/simplequeue"
 	"github.com/stretchr/testify/require"
@@ -19,31 +21,51 @@ import (
 // for different deployments when compiling an application.
 var shippingRegistry = registry.NewServiceRegistry[shipping.ShippingService]("shipping_service")
 
+// The queue master that is created and started along with the local ShippingService
+var queueMaster queuemaster.QueueMaster
+
+// The queue that the local ShippingService publishes shipment status events to, and that
+// the local OrderService consumes them from
+var shipmentEvents backend.Queue
+
 func init() {
 
 	// If the tests are run locally, we fall back to this ShippingService implementation
 	shippingRegistry.Register("local", func(ctx context.Context) (shipping.ShippingService, error) {
 		queue, err := simplequeue.NewSimpleQueue(ctx)
 
+		shipmentEvents, err = simplequeue.NewSimpleQueue(ctx)
+		if err != nil {
+			return nil, err
+		}
+
 		db, err := simplenosqldb.NewSimpleNoSQLDB(ctx)
 		if err != nil {
 			return nil, err
 		}
 
-		ship, err := shipping.NewShippingService(ctx, queue, db)
+		ship, err := shipping.NewShippingService(ctx, queue, shipmentEvents, db)
 		if err != nil {
 			return nil, err
 		}
 
 		// Also create and start the queue master
-		qmaster, err := queuemaster.NewQueueMaster(ctx, queue, ship)
+		deadLetters, err := simplequeue.NewSimpleQueue(ctx)
+		if err != nil {
+			return nil, err
+		}
+		queueMaster, err = queuemaster.NewQueueMaster(ctx, queue, deadLetters, ship, "", "")
 		if err != nil {
 			return nil, err
 		}
 
-		// Make sure the queue master is started if it's local
+		// Make sure the shipping service's relay and the queue master are started if
+		// they're local; Blueprint starts them otherwise
+		go func() {
+			ship.(golang.Runnable).Run(ctx)
+		}()
 		go func() {
-			qmaster.Run(ctx)
+			queueMaster.(golang.Runnable).Run(ctx)
 		}()
 
 		return ship, nil
@@ -85,3 +107,31 @@ func TestShippingService(t *testing.T) {
 	}
 
 }
+
+func TestCancelShipment(t *testing.T) {
+	ctx := context.Background()
+
+	service, err := shippingRegistry.Get(ctx)
+	require.NoError(t, err)
+
+	shipment := shipping.Shipment{
+		ID:     "cancelled",
+		Name:   "world",
+		Status: "awaiting shipment",
+	}
+	_, err = service.PostShipping(ctx, shipment)
+	require.NoError(t, err)
+	require.NoError(t, service.CancelShipment(ctx, shipment.ID))
+
+	// The queue master does not ship a cancelled shipment
+	time.Sleep(100 * time.Millisecond)
+	shipment2, err := service.GetShipment(ctx, shipment.ID)
+	require.NoError(t, err)
+	require.Equal(t, shipping.StatusCancelled, shipment2.Status)
+
+	// A shipment cancelled before it is posted is recorded as cancelled
+	require.NoError(t, service.CancelShipment(ctx, "unposted"))
+	shipment3, err := service.GetShipment(ctx, "unposted")
+	require.NoError(t, err)
+	require.Equal(t, shipping.StatusCancelled, shipment3.Status)
+}
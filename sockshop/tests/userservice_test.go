@@ -26,7 +26,7 @@ func init() {
 	// 		return nil, err
 	// 	}
 
-	// 	return user.NewUserServiceImpl(ctx, db)
+	// 	return user.NewUserServiceImpl(ctx, db, user.DefaultPasswordPolicy)
 	// })
 
 	// If the tests are run locally, we fall back to this user service implementation
@@ -36,7 +36,7 @@ func init() {
 			return nil, err
 		}
 
-		return user.NewUserServiceImpl(ctx, db)
+		return user.NewUserServiceImpl(ctx, db, user.DefaultPasswordPolicy)
 	})
 }
 
@@ -45,7 +45,7 @@ var jon = user.User{
 	LastName:  "Mace",
 	Email:     "jon@mpi",
 	Username:  "jon",
-	Password:  "secret",
+	Password:  "secretsauce",
 }
 
 var vaastav = user.User{
@@ -151,6 +151,28 @@ func TestAll(t *testing.T) {
 		expectCards(t, service, 0)
 	}
 
+	{
+		// Passwords that don't meet the password policy are refused
+		_, err := service.Register(ctx, "weak", "secret", "weak@mpi", "Weak", "Password")
+		require.Error(t, err)
+		_, err = service.Register(ctx, "weakpassword", "WeakPassword", "weak@mpi", "Weak", "Password")
+		require.Error(t, err)
+
+		u := deepak
+		u.Password = ""
+		_, err = service.PostUser(ctx, u)
+		require.Error(t, err)
+
+		// Passwords are not stored in the clear
+		users := expectUsers(t, service, 2)
+		for _, u := range users {
+			require.NotContains(t, []string{jon.Password, vaastav.Password}, u.Password)
+		}
+
+		expectAddresses(t, service, 0)
+		expectCards(t, service, 0)
+	}
+
 	{
 		// Register an address
 		aid, err := service.PostAddress(ctx, "", deepak.Addresses[1])
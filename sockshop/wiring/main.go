@@ -20,20 +20,13 @@ func main() {
 
 	// Build a supported wiring spec
 	name := "SockShop"
-	cmdbuilder.MakeAndExecute(
-		name,
+	specOptions := []cmdbuilder.SpecOption{
 		specs.Basic,
 		specs.GRPC,
 		specs.Docker,
 		specs.DockerRabbit,
-		// Comparison specs for performance experiments
-		specs.Cmp_gRPC_Zipkin_Micro,
-		specs.Cmp_Thrift_Zipkin_Micro,
-		specs.Cmp_gRPC_NoZipkin_Micro,
-		specs.Cmp_Thrift_NoZipkin_Micro,
-		specs.Cmp_gRPC_Zipkin_Mono,
-		specs.Cmp_Thrift_Zipkin_Mono,
-		specs.Cmp_gRPC_NoZipkin_Mono,
-		specs.Cmp_Thrift_NoZipkin_Mono,
-	)
+	}
+	// Comparison specs for performance experiments
+	specOptions = append(specOptions, specs.Comparisons...)
+	cmdbuilder.MakeAndExecute(name, specOptions...)
 }
@@ -8,10 +8,12 @@ import (
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/cart"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/catalogue"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/frontend"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/order"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/payment"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/queuemaster"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/shipping"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/token"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/user"
 	"github.com/blueprint-uservices/blueprint/plugins/cmdbuilder"
 	"github.com/blueprint-uservices/blueprint/plugins/simple"
@@ -28,26 +30,35 @@ var Basic = cmdbuilder.SpecOption{
 
 func makeBasicSpec(spec wiring.WiringSpec) ([]string, error) {
 	user_db := simple.NoSQLDB(spec, "user_db")
-	user_service := workflow.Service[user.UserService](spec, "user_service", user_db)
+	user_service := workflow.Service[user.UserService](spec, "user_service", user_db, user.DefaultPasswordPolicy)
 
-	payment_service := workflow.Service[payment.PaymentService](spec, "payment_service", "500")
+	payment_rates := workflow.Service[money.ExchangeRates](spec, "payment_rates", money.DefaultExchangeRates)
+	payment_gateway := workflow.Service[payment.Gateway](spec, "payment_gateway", payment.DefaultGatewayScript)
+	payment_db := simple.NoSQLDB(spec, "payment_db")
+	fraud_scorer := workflow.Service[payment.FraudScorer](spec, "fraud_scorer", payment_db, payment.DefaultFraudRules)
+	payment_service := workflow.Service[payment.PaymentService](spec, "payment_service", payment_gateway, fraud_scorer, payment_db, payment_rates, "500.00 USD")
 
 	cart_db := simple.NoSQLDB(spec, "cart_db")
 	cart_service := workflow.Service[cart.CartService](spec, "cart_service", cart_db)
 
 	shipqueue := simple.Queue(spec, "shipping_queue")
+	shipevents := simple.Queue(spec, "shipping_events")
 	shipdb := simple.NoSQLDB(spec, "shipping_db")
-	shipping_service := workflow.Service[shipping.ShippingService](spec, "shipping_service", shipqueue, shipdb)
+	shipping_service := workflow.Service[shipping.ShippingService](spec, "shipping_service", shipqueue, shipevents, shipdb)
 
-	queue_master := workflow.Service[queuemaster.QueueMaster](spec, "queue_master", shipqueue, shipping_service)
-
-	order_db := simple.NoSQLDB(spec, "order_db")
-	order_service := workflow.Service[order.OrderService](spec, "order_service", user_service, cart_service, payment_service, shipping_service, order_db)
+	shipdeadletters := simple.Queue(spec, "shipping_dead_letters")
+	queue_master := workflow.Service[queuemaster.QueueMaster](spec, "queue_master", shipqueue, shipdeadletters, shipping_service, queuemaster.DefaultMaxAttempts, queuemaster.DefaultBackoff)
 
 	catalogue_db := simple.RelationalDB(spec, "catalogue_db")
-	catalogue_service := workflow.Service[catalogue.CatalogueService](spec, "catalogue_service", catalogue_db)
+	catalogue_rates := workflow.Service[money.ExchangeRates](spec, "catalogue_rates", money.DefaultExchangeRates)
+	catalogue_service := workflow.Service[catalogue.CatalogueService](spec, "catalogue_service", catalogue_db, catalogue_rates, catalogue.DefaultReservationTimeout)
+
+	order_db := simple.NoSQLDB(spec, "order_db")
+	order_rates := workflow.Service[money.ExchangeRates](spec, "order_rates", money.DefaultExchangeRates)
+	order_service := workflow.Service[order.OrderService](spec, "order_service", user_service, cart_service, catalogue_service, payment_service, shipping_service, shipevents, order_db, order_rates, order.DefaultPricing)
 
-	frontend_service := workflow.Service[frontend.Frontend](spec, "frontend", user_service, catalogue_service, cart_service, order_service)
+	token_service := workflow.Service[token.TokenService](spec, "token_service", "", token.DefaultTokenLifetime)
+	frontend_service := workflow.Service[frontend.Frontend](spec, "frontend", user_service, catalogue_service, cart_service, order_service, token_service)
 
 	return []string{user_service, payment_service, cart_service, shipping_service, queue_master, order_service, catalogue_service, frontend_service}, nil
 }
@@ -0,0 +1,19 @@
+package specs
+
+import (
+	"github.com/blueprint-uservices/blueprint/blueprint/pkg/wiring"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/broker"
+	"github.com/blueprint-uservices/blueprint/plugins/workflow"
+)
+
+// Defines a [broker.Queue] called name, a client of the stand-in message broker brokerName
+// that can be used wherever a queue backend is expected.
+//
+// Workflow services are normally unique within an application, but each process that uses
+// the queue needs a client of its own; so uniqueness is disabled for the client, and the
+// broker itself should be deployed over RPC.
+func brokerQueue(spec wiring.WiringSpec, name string, brokerName string) string {
+	queue := workflow.Service[broker.Queue](spec, name, brokerName)
+	spec.Alias(queue+".dst", queue+".handler")
+	return queue
+}
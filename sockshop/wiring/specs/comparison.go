@@ -1,14 +1,23 @@
 package specs
 
 import (
+	"flag"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/blueprint-uservices/blueprint/blueprint/pkg/blueprint"
 	"github.com/blueprint-uservices/blueprint/blueprint/pkg/wiring"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/broker"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/cart"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/catalogue"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/frontend"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/order"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/payment"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/queuemaster"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/shipping"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/token"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/user"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workload/workloadgen"
 	"github.com/blueprint-uservices/blueprint/plugins/clientpool"
@@ -21,6 +30,7 @@ import (
 	"github.com/blueprint-uservices/blueprint/plugins/mongodb"
 	"github.com/blueprint-uservices/blueprint/plugins/mysql"
 	"github.com/blueprint-uservices/blueprint/plugins/opentelemetry"
+	"github.com/blueprint-uservices/blueprint/plugins/rabbitmq"
 	"github.com/blueprint-uservices/blueprint/plugins/retries"
 	"github.com/blueprint-uservices/blueprint/plugins/simple"
 	"github.com/blueprint-uservices/blueprint/plugins/thrift"
@@ -29,159 +39,430 @@ import (
 	"github.com/blueprint-uservices/blueprint/plugins/zipkin"
 )
 
-// Comparison specs for measuring performance impact of different architectural choices
-// Each spec varies along three dimensions:
-// 1. RPC Framework: gRPC vs Thrift
-// 2. Tracing: Zipkin enabled vs disabled
-// 3. Architecture: Microservices vs Monolith
-
-// 1. gRPC + Zipkin + Microservices (baseline)
-var Cmp_gRPC_Zipkin_Micro = cmdbuilder.SpecOption{
-	Name:        "cmp_grpc_zipkin_micro",
-	Description: "Microservices with gRPC and Zipkin tracing",
-	Build:       makeComparisonSpec(true, true, true),
+// Comparison specs for measuring the performance impact of different architectural choices.
+//
+// The specs vary along the dimensions in [comparisonDimensions]; one spec is generated for
+// every valid combination of options.  The deployment dimension groups services into
+// processes and containers: microservices deploy every service separately, a monolith
+// deploys all services together, and partial groupings such as "grouped" sit in between;
+// see [deployment].  The custom deployment takes its grouping from the -groups flag, in the
+// form described by [parseGrouping], e.g.
+//
+//	-w cmp_grpc_nozipkin_custom -groups "users=user_service,cart_service;orders=order_service,payment_service"
+//
+// An in-memory queue whose producer and consumer are deployed apart, such as the shipping
+// queue when the queue master is deployed apart from the shipping service, is held by a
+// stand-in message broker deployed alone.  Specs are named cmp_<option>_<option>_..., listing
+// the chosen option of the RPC framework, tracing, and deployment dimensions, followed by
+// any other dimension whose option is not the default.  For example, cmp_grpc_zipkin_micro
+// uses the default database, queue, retries, and client pool, while
+// cmp_thrift_nozipkin_micro_rabbitmq_noretries uses RabbitMQ and no retries.  Monoliths have
+// no RPC, so their names omit the RPC-only dimensions, e.g. cmp_zipkin_mono.
+var Comparisons = makeComparisonSpecs()
+
+// The configuration of a comparison spec
+type comparisonConfig struct {
+	rpc        string // "grpc" or "thrift"
+	tracing    bool
+	groups     []serviceGroup // Services deployed together in one process and container; other services are deployed alone
+	custom     bool           // Whether groups are instead given by the -groups flag when the spec is built
+	rpcBackend bool           // Whether any backend services are called over RPC, i.e. the spec is not a monolith
+	mongodb    bool           // Otherwise in-memory databases
+	rabbitmq   bool           // Otherwise an in-memory queue
+	retries    int            // 0 for no retries
+	poolSize   int            // 0 for no client pool
+}
+
+// Services deployed together to the process <name>_proc, and its container
+type serviceGroup struct {
+	name     string
+	services []string
+}
+
+// A dimension along which comparison specs vary
+type dimension struct {
+	options []option // The first option is the default
+	named   bool     // Whether the chosen option always appears in spec names, or only if it is not the default
+	rpcOnly bool     // Whether the dimension only applies to specs whose services communicate over RPC
 }
 
-// 2. Thrift + Zipkin + Microservices
-var Cmp_Thrift_Zipkin_Micro = cmdbuilder.SpecOption{
-	Name:        "cmp_thrift_zipkin_micro",
-	Description: "Microservices with Thrift and Zipkin tracing",
-	Build:       makeComparisonSpec(false, true, true),
+// An option of a dimension
+type option struct {
+	name        string // Used in spec names
+	description string // Used in spec descriptions
+	apply       func(*comparisonConfig)
 }
 
-// 3. gRPC + NoZipkin + Microservices
-var Cmp_gRPC_NoZipkin_Micro = cmdbuilder.SpecOption{
-	Name:        "cmp_grpc_nozipkin_micro",
-	Description: "Microservices with gRPC, no tracing",
-	Build:       makeComparisonSpec(true, false, true),
+// The services of a comparison spec that can be grouped.  If the queue master or the order
+// service is not grouped with the shipping service, the in-memory queue between them is held
+// by a stand-in message broker, which is deployed alone.
+var comparisonServices = []string{"frontend", "user_service", "cart_service", "order_service", "payment_service", "shipping_service", "queue_master", "catalogue_service"}
+
+// The grouping of services deployed by the comparison specs with the custom deployment
+var customGrouping = flag.String("groups", "", "Grouping of services for the cmp_*_custom wiring specs, e.g. \"users=user_service,cart_service;orders=order_service,payment_service\".  Services not in any group are deployed alone.")
+
+var comparisonDimensions = []dimension{
+	{named: true, rpcOnly: true, options: []option{
+		{"grpc", "gRPC", func(c *comparisonConfig) { c.rpc = "grpc" }},
+		{"thrift", "Thrift", func(c *comparisonConfig) { c.rpc = "thrift" }},
+	}},
+	{named: true, options: []option{
+		{"zipkin", "Zipkin tracing", func(c *comparisonConfig) { c.tracing = true }},
+		{"nozipkin", "no tracing", func(c *comparisonConfig) { c.tracing = false }},
+	}},
+	{named: true, options: []option{
+		deployment("micro", "microservices", ""),
+		deployment("mono", "monolith", "frontend="+strings.Join(comparisonServices, ",")),
+		deployment("grouped", "user and cart services together, and order, payment, shipping, and queue master services together",
+			"user=user_service,cart_service;order=order_service,payment_service,shipping_service,queue_master"),
+		deployment("backend", "frontend in front of a monolithic backend",
+			"backend=user_service,cart_service,order_service,payment_service,shipping_service,queue_master,catalogue_service"),
+		{"custom", "services grouped as given by -groups", func(c *comparisonConfig) { c.custom, c.rpcBackend = true, true }},
+	}},
+	{options: []option{
+		{"mongodb", "MongoDB and MySQL databases", func(c *comparisonConfig) { c.mongodb = true }},
+		{"memdb", "in-memory databases", func(c *comparisonConfig) { c.mongodb = false }},
+	}},
+	{options: []option{
+		{"simplequeue", "in-memory queue", func(c *comparisonConfig) { c.rabbitmq = false }},
+		{"rabbitmq", "RabbitMQ queue", func(c *comparisonConfig) { c.rabbitmq = true }},
+	}},
+	{rpcOnly: true, options: []option{
+		{"retries", "3 retries", func(c *comparisonConfig) { c.retries = 3 }},
+		{"noretries", "no retries", func(c *comparisonConfig) { c.retries = 0 }},
+	}},
+	{rpcOnly: true, options: []option{
+		{"pool", "client pools of 10", func(c *comparisonConfig) { c.poolSize = 10 }},
+		{"nopool", "no client pools", func(c *comparisonConfig) { c.poolSize = 0 }},
+	}},
+}
+
+// Returns an option of the deployment dimension that deploys each group of the grouping to
+// its own process and container.  Services that are not in any group are each deployed
+// alone, so with no groups every service is deployed separately.  A group containing every
+// service is a monolith, in which only the frontend is called over the network.
+func deployment(name, description, grouping string) option {
+	groups, err := parseGrouping(grouping)
+	if err != nil {
+		panic(err)
+	}
+	return option{name, description, func(c *comparisonConfig) {
+		c.groups = groups
+		c.rpcBackend = !monolith(groups)
+	}}
 }
 
-// 4. Thrift + NoZipkin + Microservices
-var Cmp_Thrift_NoZipkin_Micro = cmdbuilder.SpecOption{
-	Name:        "cmp_thrift_nozipkin_micro",
-	Description: "Microservices with Thrift, no tracing",
-	Build:       makeComparisonSpec(false, false, true),
+// The names that groups can be given; a group's process is named <name>_proc
+var groupName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Parses a grouping of services of the form name=service,service,...;name=service,...
+// Each group is deployed to its own process, named <name>_proc.  A service can be in at
+// most one group, and the frontend's group must be named frontend, so that the frontend
+// is always deployed to frontend_proc.
+func parseGrouping(grouping string) ([]serviceGroup, error) {
+	var groups []serviceGroup
+	grouped := make(map[string]bool)
+	for _, g := range strings.Split(grouping, ";") {
+		if strings.TrimSpace(g) == "" {
+			continue
+		}
+		name, services, found := strings.Cut(g, "=")
+		group := serviceGroup{name: strings.TrimSpace(name)}
+		if !found || !groupName.MatchString(group.name) {
+			return nil, blueprint.Errorf("invalid group %q; expected name=service,service,...", g)
+		}
+		for _, service := range strings.Split(services, ",") {
+			service = strings.TrimSpace(service)
+			if !slices.Contains(comparisonServices, service) {
+				return nil, blueprint.Errorf("unknown service %q in group %v; expected one of %v", service, group.name, strings.Join(comparisonServices, ", "))
+			} else if grouped[service] {
+				return nil, blueprint.Errorf("service %v is in more than one group", service)
+			} else if service == "frontend" && group.name != "frontend" {
+				return nil, blueprint.Errorf("group %v contains the frontend, so must be named frontend", group.name)
+			}
+			grouped[service] = true
+			group.services = append(group.services, service)
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
 }
 
-// 5. Zipkin + Monolith
-var Cmp_Zipkin_Mono = cmdbuilder.SpecOption{
-	Name:        "cmp_zipkin_mono",
-	Description: "Monolith with Zipkin tracing",
-	Build:       makeComparisonSpec(true, true, false),
+// Reports whether the grouping deploys every service together
+func monolith(groups []serviceGroup) bool {
+	return len(groups) == 1 && len(groups[0].services) == len(comparisonServices)
 }
 
-// 7. NoZipkin + Monolith
-var Cmp_NoZipkin_Mono = cmdbuilder.SpecOption{
-	Name:        "cmp_nozipkin_mono",
-	Description: "Monolith with no tracing",
-	Build:       makeComparisonSpec(true, false, false),
+// Generates a spec for every valid combination of options
+func makeComparisonSpecs() []cmdbuilder.SpecOption {
+	var specs []cmdbuilder.SpecOption
+	choices := make([]int, len(comparisonDimensions)) // The chosen option of each dimension
+	for {
+		if spec, valid := makeComparisonSpecOption(choices); valid {
+			specs = append(specs, spec)
+		}
+
+		// Advance to the next combination
+		i := len(choices) - 1
+		for ; i >= 0; i-- {
+			choices[i]++
+			if choices[i] < len(comparisonDimensions[i].options) {
+				break
+			}
+			choices[i] = 0
+		}
+		if i < 0 {
+			return specs
+		}
+	}
 }
 
+// Returns the spec for the chosen option of each dimension.  A combination is invalid if it
+// chooses a non-default option of a dimension that does not apply.
+func makeComparisonSpecOption(choices []int) (cmdbuilder.SpecOption, bool) {
+	var c comparisonConfig
+	for i, d := range comparisonDimensions {
+		d.options[choices[i]].apply(&c)
+	}
 
-// Helper function to generate comparison specs
-func makeComparisonSpec(useGRPC bool, useZipkin bool, useMicroservices bool) func(spec wiring.WiringSpec) ([]string, error) {
+	var names, descriptions []string
+	for i, d := range comparisonDimensions {
+		if d.rpcOnly && !c.rpcBackend {
+			if choices[i] != 0 {
+				return cmdbuilder.SpecOption{}, false
+			}
+			continue
+		}
+		o := d.options[choices[i]]
+		if d.named || choices[i] != 0 {
+			names = append(names, o.name)
+		}
+		descriptions = append(descriptions, o.description)
+	}
+
+	return cmdbuilder.SpecOption{
+		Name:        "cmp_" + strings.Join(names, "_"),
+		Description: "Comparison spec with " + strings.Join(descriptions, ", "),
+		Build:       makeComparisonSpec(c),
+	}, true
+}
+
+// Builds a comparison spec with the given configuration
+func makeComparisonSpec(c comparisonConfig) func(spec wiring.WiringSpec) ([]string, error) {
 	return func(spec wiring.WiringSpec) ([]string, error) {
+		grouping := c.groups
+		if c.custom {
+			var err error
+			if grouping, err = parseGrouping(*customGrouping); err != nil {
+				return nil, err
+			} else if len(grouping) == 0 {
+				return nil, blueprint.Errorf("no grouping of services given; specify with -groups")
+			} else if monolith(grouping) {
+				return nil, blueprint.Errorf("-groups deploys every service together; use a mono spec instead")
+			}
+		}
+
 		// Define the trace collector if needed
 		var trace_collector string
-		if useZipkin {
+		if c.tracing {
 			trace_collector = zipkin.Collector(spec, "zipkin")
 		}
 
-		// Modifiers that will be applied to all services
-		applyDefaults := func(serviceName string, useHTTP ...bool) {
+		noSQLDB := func(name string) string {
+			if c.mongodb {
+				return mongodb.Container(spec, name)
+			}
+			return simple.NoSQLDB(spec, name)
+		}
 
-			// Add tracing first (if enabled)
-			if useZipkin {
-				opentelemetry.Instrument(spec, serviceName, trace_collector)
+		// The services that call each service, used to decide which services need RPC
+		callers := make(map[string][]string)
+		calls := func(caller string, callees ...string) {
+			for _, callee := range callees {
+				callers[callee] = append(callers[callee], caller)
+			}
+		}
+
+		user_db := noSQLDB("user_db")
+		user_service := workflow.Service[user.UserService](spec, "user_service", user_db, user.DefaultPasswordPolicy)
+
+		payment_rates := workflow.Service[money.ExchangeRates](spec, "payment_rates", money.DefaultExchangeRates)
+		payment_gateway := workflow.Service[payment.Gateway](spec, "payment_gateway", payment.DefaultGatewayScript)
+		payment_db := noSQLDB("payment_db")
+		fraud_scorer := workflow.Service[payment.FraudScorer](spec, "fraud_scorer", payment_db, payment.DefaultFraudRules)
+		payment_service := workflow.Service[payment.PaymentService](spec, "payment_service", payment_gateway, fraud_scorer, payment_db, payment_rates, "500.00 USD")
+
+		cart_db := noSQLDB("cart_db")
+		cart_service := workflow.Service[cart.CartService](spec, "cart_service", cart_db)
+
+		// An in-memory queue can only be shared within a process, so if a queue's producer is
+		// deployed apart from its consumer, the queue is held by a stand-in broker
+		var brokers []string
+		unpooled := make(map[string]bool)
+		queue := func(name, brokerName, rabbitQueue, producer, consumer string) string {
+			if c.rabbitmq {
+				return rabbitmq.Container(spec, name, rabbitQueue)
+			} else if together(grouping, producer, consumer) {
+				return simple.Queue(spec, name)
 			}
+			queueBroker := workflow.Service[broker.Broker](spec, brokerName, simple.Queue(spec, brokerName+"_queue"))
+			calls(producer, queueBroker)
+			calls(consumer, queueBroker)
 
-			// For microservices, deploy to separate processes with RPC
-			if useMicroservices {
-				// Apply modifiers in correct order: tracing -> retries -> pooling -> RPC -> process -> container
-				
-				// RPC-related modifiers
-				retries.AddRetries(spec, serviceName, 3)
-				clientpool.Create(spec, serviceName, 10)
-				
-				// Choose RPC framework
-				if len(useHTTP) > 0 && useHTTP[0] {
-					http.Deploy(spec, serviceName)
-				} else if useGRPC {
-					grpc.Deploy(spec, serviceName)
-				} else {
-					thrift.Deploy(spec, serviceName)
+			// Blueprint names generated client pools after the service interface, so a
+			// process can only pool the clients of one broker
+			if len(brokers) > 0 {
+				unpooled[queueBroker] = true
+			}
+			brokers = append(brokers, queueBroker)
+			return brokerQueue(spec, name, queueBroker)
+		}
+
+		shipqueue := queue("shipping_queue", "shipping_broker", "shippingq", "shipping_service", "queue_master")
+		shipevents := queue("shipping_events", "shipping_events_broker", "shippingevents", "shipping_service", "order_service")
+		shipdb := noSQLDB("shipping_db")
+		shipping_service := workflow.Service[shipping.ShippingService](spec, "shipping_service", shipqueue, shipevents, shipdb)
+
+		shipdeadletters := queue("shipping_dead_letters", "shipping_dead_letters_broker", "shippingdeadletters", "queue_master", "queue_master")
+		queue_master := workflow.Service[queuemaster.QueueMaster](spec, "queue_master", shipqueue, shipdeadletters, shipping_service, queuemaster.DefaultMaxAttempts, queuemaster.DefaultBackoff)
+		calls(queue_master, shipping_service)
+
+		backends := []string{user_service, payment_service, cart_service, shipping_service, queue_master}
+		backends = append(backends, brokers...)
+
+		var catalogue_db string
+		if c.mongodb {
+			catalogue_db = mysql.Container(spec, "catalogue_db")
+		} else {
+			catalogue_db = simple.RelationalDB(spec, "catalogue_db")
+		}
+		catalogue_rates := workflow.Service[money.ExchangeRates](spec, "catalogue_rates", money.DefaultExchangeRates)
+		catalogue_service := workflow.Service[catalogue.CatalogueService](spec, "catalogue_service", catalogue_db, catalogue_rates, catalogue.DefaultReservationTimeout)
+
+		order_db := noSQLDB("order_db")
+		order_rates := workflow.Service[money.ExchangeRates](spec, "order_rates", money.DefaultExchangeRates)
+		order_service := workflow.Service[order.OrderService](spec, "order_service", user_service, cart_service, catalogue_service, payment_service, shipping_service, shipevents, order_db, order_rates, order.DefaultPricing)
+		calls(order_service, user_service, cart_service, catalogue_service, payment_service, shipping_service)
+		backends = append(backends, order_service, catalogue_service)
+
+		token_service := workflow.Service[token.TokenService](spec, "token_service", "", token.DefaultTokenLifetime)
+		frontend_service := workflow.Service[frontend.Frontend](spec, "frontend", user_service, catalogue_service, cart_service, order_service, token_service)
+		calls(frontend_service, user_service, catalogue_service, cart_service, order_service)
+
+		// Assign services to groups.  Services not in any group of the grouping, including
+		// the brokers, are deployed alone.
+		groups, err := groupServices(grouping, frontend_service, backends...)
+		if err != nil {
+			return nil, err
+		}
+		groupOf := make(map[string]int)
+		for i, group := range groups {
+			for _, service := range group.services {
+				groupOf[service] = i
+			}
+		}
+
+		// Services called from another group communicate over RPC; calls within a group
+		// are direct, unless the callee is also called from another group.
+		exposed := func(serviceName string) bool {
+			for _, caller := range callers[serviceName] {
+				if groupOf[caller] != groupOf[serviceName] {
+					return true
 				}
-				
-				goproc.Deploy(spec, serviceName)
-				linuxcontainer.Deploy(spec, serviceName)
-				
-				// Add tests (each service can be tested independently in microservices)
-				gotests.Test(spec, serviceName)
 			}
+			return false
 		}
 
-		user_db := mongodb.Container(spec, "user_db")
-		user_service := workflow.Service[user.UserService](spec, "user_service", user_db)
-		applyDefaults(user_service)
+		// Apply modifiers in correct order: tracing -> retries -> pooling -> RPC
+		for _, serviceName := range backends {
+			if c.tracing {
+				opentelemetry.Instrument(spec, serviceName, trace_collector)
+			}
+			if !exposed(serviceName) {
+				continue
+			}
+			if c.retries > 0 {
+				retries.AddRetries(spec, serviceName, int64(c.retries))
+			}
+			if c.poolSize > 0 && !unpooled[serviceName] {
+				clientpool.Create(spec, serviceName, c.poolSize)
+			}
+			if c.rpc == "grpc" {
+				grpc.Deploy(spec, serviceName)
+			} else {
+				thrift.Deploy(spec, serviceName)
+			}
 
-		payment_service := workflow.Service[payment.PaymentService](spec, "payment_service", "500")
-		applyDefaults(payment_service)
+			// Add tests (each exposed service can be tested independently)
+			gotests.Test(spec, serviceName)
+		}
 
-		cart_db := mongodb.Container(spec, "cart_db")
-		cart_service := workflow.Service[cart.CartService](spec, "cart_service", cart_db)
-		applyDefaults(cart_service)
-
-		shipqueue := simple.Queue(spec, "shipping_queue")
-		shipdb := mongodb.Container(spec, "shipping_db")
-		shipping_service := workflow.Service[shipping.ShippingService](spec, "shipping_service", shipqueue, shipdb)
-		applyDefaults(shipping_service)
-
-		// Deploy queue master to the same process as the shipping service
-		queue_master := workflow.Service[queuemaster.QueueMaster](spec, "queue_master", shipqueue, shipping_service)
-		if useMicroservices {
-			goproc.AddToProcess(spec, "shipping_proc", queue_master)
-		}
-
-		order_db := mongodb.Container(spec, "order_db")
-		order_service := workflow.Service[order.OrderService](spec, "order_service", user_service, cart_service, payment_service, shipping_service, order_db)
-		applyDefaults(order_service)
-
-		catalogue_db := mysql.Container(spec, "catalogue_db")
-		catalogue_service := workflow.Service[catalogue.CatalogueService](spec, "catalogue_service", catalogue_db)
-		applyDefaults(catalogue_service)
-
-		frontend_service := workflow.Service[frontend.Frontend](spec, "frontend", user_service, catalogue_service, cart_service, order_service)
-		
-		// Apply modifiers and deployment based on architecture
-		if useMicroservices {
-			// Microservices: frontend with tracing, HTTP, retries, pooling, separate process/container
-			// Apply in correct order
-			if useZipkin {
+		// The frontend is always exposed over HTTP, for the workload generator.
+		// Note: Dont apply tracing to frontend in monolith - causes conflicts with HTTP
+		if c.rpcBackend {
+			if c.tracing {
 				opentelemetry.Instrument(spec, frontend_service, trace_collector)
 			}
-			retries.AddRetries(spec, frontend_service, 3)
-			clientpool.Create(spec, frontend_service, 10)
-			http.Deploy(spec, frontend_service)
-			frontend_proc := goproc.Deploy(spec, frontend_service)
-			linuxcontainer.Deploy(spec, frontend_proc)
-			gotests.Test(spec, frontend_service)
-			
-			wlgen := workload.Generator[workloadgen.SimpleWorkload](spec, "wlgen", frontend_service)
-			return []string{frontend_proc + "_ctr", wlgen, "gotests"}, nil
-		} else {
-			// Monolith: frontend with HTTP for external access, deployed as single process
-			// Internal services use direct calls (no RPC) since they have no Deploy modifiers
-			// Note: Dont apply tracing to frontend in monolith - causes conflicts with HTTP
-			// Backend services already have tracing from applyDefaults
-			http.Deploy(spec, frontend_service)
-			gotests.Test(spec, frontend_service)
-			
-			// Deploy to single process and container (goproc.Deploy bundles all dependencies)
-			frontend_proc := goproc.Deploy(spec, frontend_service)
-			linuxcontainer.Deploy(spec, frontend_proc)
-			
-			wlgen := workload.Generator[workloadgen.SimpleWorkload](spec, "wlgen", frontend_service)
-			return []string{frontend_proc + "_ctr", wlgen, "gotests"}, nil
+			if c.retries > 0 {
+				retries.AddRetries(spec, frontend_service, int64(c.retries))
+			}
+			if c.poolSize > 0 {
+				clientpool.Create(spec, frontend_service, c.poolSize)
+			}
+		}
+		http.Deploy(spec, frontend_service)
+		gotests.Test(spec, frontend_service)
+
+		// Deploy each group to a process and container, named after the group
+		var containers []string
+		for _, group := range groups {
+			proc := goproc.CreateProcess(spec, group.name+"_proc", group.services...)
+			containers = append(containers, linuxcontainer.Deploy(spec, proc))
+		}
+
+		wlgen := workload.Generator[workloadgen.SimpleWorkload](spec, "wlgen", frontend_service)
+		return append(containers, wlgen, "gotests"), nil
+	}
+}
+
+// Reports whether the grouping deploys services a and b to the same process.  A service
+// is always deployed to the same process as itself, whether or not it is in a group.
+func together(grouping []serviceGroup, a, b string) bool {
+	if a == b {
+		return true
+	}
+	for _, group := range grouping {
+		if slices.Contains(group.services, a) && slices.Contains(group.services, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the groups of the grouping, followed by a group for each service that is not in
+// any group, named as [goproc.Deploy] names a service's process.  Returns an error if two
+// groups' processes would have the same name.
+func groupServices(grouping []serviceGroup, frontend string, services ...string) ([]serviceGroup, error) {
+	grouped := make(map[string]bool)
+	groups := slices.Clone(grouping)
+	for _, group := range grouping {
+		for _, service := range group.services {
+			grouped[service] = true
+		}
+	}
+	for _, service := range append([]string{frontend}, services...) {
+		if !grouped[service] {
+			name, _ := strings.CutSuffix(service, "_service")
+			groups = append(groups, serviceGroup{name: name, services: []string{service}})
+		}
+	}
+
+	named := make(map[string]bool)
+	for _, group := range groups {
+		if named[group.name] {
+			return nil, blueprint.Errorf("more than one group would be deployed to %v_proc", group.name)
 		}
+		named[group.name] = true
 	}
+	return groups, nil
 }
@@ -2,13 +2,16 @@ package specs
 
 import (
 	"github.com/blueprint-uservices/blueprint/blueprint/pkg/wiring"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/broker"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/cart"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/catalogue"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/frontend"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/order"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/payment"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/queuemaster"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/shipping"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/token"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/user"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workload/workloadgen"
 	"github.com/blueprint-uservices/blueprint/plugins/clientpool"
@@ -24,9 +27,12 @@ import (
 )
 
 // A wiring spec that deploys each service to a separate process, with services communicating over GRPC.
-// The user, cart, shipping, and order services use simple in-memory NoSQL databases to store their data.
+// The user, cart, payment, shipping, and order services use simple in-memory NoSQL databases to store their data.
 // The catalogue service uses a simple in-memory sqlite database to store its data.
-// The shipping service and queue master service run within the same process (TODO: separate processes)
+// The shipping queue is held by a stand-in message broker service, deployed to its own process, so that
+// the queue master can be deployed to a separate process from the shipping service.  Likewise, the
+// shipment status events consumed by the order service are held by a second broker.  The queue master's
+// dead-letter queue is an in-memory queue in the queue master's own process.
 var GRPC = cmdbuilder.SpecOption{
 	Name:        "grpc",
 	Description: "Deploys each service in a separate process with gRPC.",
@@ -54,39 +60,58 @@ func makeGrpcSpec(spec wiring.WiringSpec) ([]string, error) {
 	}
 
 	user_db := simple.NoSQLDB(spec, "user_db")
-	user_service := workflow.Service[user.UserService](spec, "user_service", user_db)
+	user_service := workflow.Service[user.UserService](spec, "user_service", user_db, user.DefaultPasswordPolicy)
 	applyDefaults(user_service)
 
-	payment_service := workflow.Service[payment.PaymentService](spec, "payment_service", "500")
+	payment_rates := workflow.Service[money.ExchangeRates](spec, "payment_rates", money.DefaultExchangeRates)
+	payment_gateway := workflow.Service[payment.Gateway](spec, "payment_gateway", payment.DefaultGatewayScript)
+	payment_db := simple.NoSQLDB(spec, "payment_db")
+	fraud_scorer := workflow.Service[payment.FraudScorer](spec, "fraud_scorer", payment_db, payment.DefaultFraudRules)
+	payment_service := workflow.Service[payment.PaymentService](spec, "payment_service", payment_gateway, fraud_scorer, payment_db, payment_rates, "500.00 USD")
 	applyDefaults(payment_service)
 
 	cart_db := simple.NoSQLDB(spec, "cart_db")
 	cart_service := workflow.Service[cart.CartService](spec, "cart_service", cart_db)
 	applyDefaults(cart_service)
 
-	shipqueue := simple.Queue(spec, "shipping_queue")
+	shipbroker := workflow.Service[broker.Broker](spec, "shipping_broker", simple.Queue(spec, "shipping_broker_queue"))
+	clientpool.Create(spec, shipbroker, 10)
+	grpc.Deploy(spec, shipbroker)
+	goproc.Deploy(spec, shipbroker)
+	shipqueue := brokerQueue(spec, "shipping_queue", shipbroker)
+	// Blueprint names generated client pools after the service interface, so the shipping
+	// service, which calls both brokers, can only pool the clients of one
+	eventbroker := workflow.Service[broker.Broker](spec, "shipping_events_broker", simple.Queue(spec, "shipping_events_broker_queue"))
+	grpc.Deploy(spec, eventbroker)
+	goproc.Deploy(spec, eventbroker)
+	shipevents := brokerQueue(spec, "shipping_events", eventbroker)
 	shipdb := simple.NoSQLDB(spec, "shipping_db")
-	shipping_service := workflow.Service[shipping.ShippingService](spec, "shipping_service", shipqueue, shipdb)
+	shipping_service := workflow.Service[shipping.ShippingService](spec, "shipping_service", shipqueue, shipevents, shipdb)
 	applyDefaults(shipping_service)
 
-	// Deploy queue master to the same process as the shipping proc
-	queue_master := workflow.Service[queuemaster.QueueMaster](spec, "queue_master", shipqueue, shipping_service)
-	goproc.AddToProcess(spec, "shipping_proc", queue_master)
-
-	order_db := simple.NoSQLDB(spec, "order_db")
-	order_service := workflow.Service[order.OrderService](spec, "order_service", user_service, cart_service, payment_service, shipping_service, order_db)
-	applyDefaults(order_service)
+	// The queue master is the only user of its dead-letter queue, so the queue can be kept
+	// in the queue master's process
+	shipdeadletters := simple.Queue(spec, "shipping_dead_letters")
+	queue_master := workflow.Service[queuemaster.QueueMaster](spec, "queue_master", shipqueue, shipdeadletters, shipping_service, queuemaster.DefaultMaxAttempts, queuemaster.DefaultBackoff)
+	applyDefaults(queue_master)
 
 	catalogue_db := simple.RelationalDB(spec, "catalogue_db")
-	catalogue_service := workflow.Service[catalogue.CatalogueService](spec, "catalogue_service", catalogue_db)
+	catalogue_rates := workflow.Service[money.ExchangeRates](spec, "catalogue_rates", money.DefaultExchangeRates)
+	catalogue_service := workflow.Service[catalogue.CatalogueService](spec, "catalogue_service", catalogue_db, catalogue_rates, catalogue.DefaultReservationTimeout)
 	applyDefaults(catalogue_service)
 
-	frontend_service := workflow.Service[frontend.Frontend](spec, "frontend", user_service, catalogue_service, cart_service, order_service)
+	order_db := simple.NoSQLDB(spec, "order_db")
+	order_rates := workflow.Service[money.ExchangeRates](spec, "order_rates", money.DefaultExchangeRates)
+	order_service := workflow.Service[order.OrderService](spec, "order_service", user_service, cart_service, catalogue_service, payment_service, shipping_service, shipevents, order_db, order_rates, order.DefaultPricing)
+	applyDefaults(order_service)
+
+	token_service := workflow.Service[token.TokenService](spec, "token_service", "", token.DefaultTokenLifetime)
+	frontend_service := workflow.Service[frontend.Frontend](spec, "frontend", user_service, catalogue_service, cart_service, order_service, token_service)
 	applyDefaults(frontend_service)
 
 	wlgen := workload.Generator[workloadgen.SimpleWorkload](spec, "wlgen", frontend_service)
 
 	// Instantiate starting with the frontend which will trigger all other services to be instantiated
 	// Also include the tests and wlgen
-	return []string{"frontend_proc", wlgen, "gotests"}, nil
+	return []string{"frontend_proc", "queue_master_proc", wlgen, "gotests"}, nil
 }
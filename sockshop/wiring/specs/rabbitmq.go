@@ -5,10 +5,12 @@ import (
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/cart"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/catalogue"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/frontend"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/order"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/payment"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/queuemaster"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/shipping"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/token"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/user"
 	"github.com/blueprint-uservices/blueprint/plugins/clientpool"
 	"github.com/blueprint-uservices/blueprint/plugins/cmdbuilder"
@@ -28,7 +30,7 @@ import (
 // A wiring spec that deploys each service into its own Docker container and using gRPC to communicate between services.
 // All RPC calls are retried up to 3 times.  RPC clients use a client pool with 10 clients.
 // All services are instrumented with OpenTelemetry and traces are exported to Zipkin
-// The user, cart, shipping, and orders services using separate MongoDB instances to store their data.
+// The user, cart, payment, shipping, and orders services using separate MongoDB instances to store their data.
 // The catalogue service uses MySQL to store catalogue data.
 var DockerRabbit = cmdbuilder.SpecOption{
 	Name:        "rabbit",
@@ -57,10 +59,14 @@ func makeDockerRabbitSpec(spec wiring.WiringSpec) ([]string, error) {
 	}
 
 	user_db := mongodb.Container(spec, "user_db")
-	user_service := workflow.Service[user.UserService](spec, "user_service", user_db)
+	user_service := workflow.Service[user.UserService](spec, "user_service", user_db, user.DefaultPasswordPolicy)
 	applyDockerDefaults(user_service)
 
-	payment_service := workflow.Service[payment.PaymentService](spec, "payment_service", "500")
+	payment_rates := workflow.Service[money.ExchangeRates](spec, "payment_rates", money.DefaultExchangeRates)
+	payment_gateway := workflow.Service[payment.Gateway](spec, "payment_gateway", payment.DefaultGatewayScript)
+	payment_db := mongodb.Container(spec, "payment_db")
+	fraud_scorer := workflow.Service[payment.FraudScorer](spec, "fraud_scorer", payment_db, payment.DefaultFraudRules)
+	payment_service := workflow.Service[payment.PaymentService](spec, "payment_service", payment_gateway, fraud_scorer, payment_db, payment_rates, "500.00 USD")
 	applyDockerDefaults(payment_service)
 
 	cart_db := mongodb.Container(spec, "cart_db")
@@ -68,22 +74,27 @@ func makeDockerRabbitSpec(spec wiring.WiringSpec) ([]string, error) {
 	applyDockerDefaults(cart_service)
 
 	shipqueue := rabbitmq.Container(spec, "shipping_queue", "shippingq")
+	shipevents := rabbitmq.Container(spec, "shipping_events", "shippingevents")
 	shipdb := mongodb.Container(spec, "shipping_db")
-	shipping_service := workflow.Service[shipping.ShippingService](spec, "shipping_service", shipqueue, shipdb)
+	shipping_service := workflow.Service[shipping.ShippingService](spec, "shipping_service", shipqueue, shipevents, shipdb)
 	applyDockerDefaults(shipping_service)
 
-	queue_master := workflow.Service[queuemaster.QueueMaster](spec, "queue_master", shipqueue, shipping_service)
+	shipdeadletters := rabbitmq.Container(spec, "shipping_dead_letters", "shippingdeadletters")
+	queue_master := workflow.Service[queuemaster.QueueMaster](spec, "queue_master", shipqueue, shipdeadletters, shipping_service, queuemaster.DefaultMaxAttempts, queuemaster.DefaultBackoff)
 	applyDockerDefaults(queue_master)
 
-	order_db := mongodb.Container(spec, "order_db")
-	order_service := workflow.Service[order.OrderService](spec, "order_service", user_service, cart_service, payment_service, shipping_service, order_db)
-	applyDockerDefaults(order_service)
-
 	catalogue_db := mysql.Container(spec, "catalogue_db")
-	catalogue_service := workflow.Service[catalogue.CatalogueService](spec, "catalogue_service", catalogue_db)
+	catalogue_rates := workflow.Service[money.ExchangeRates](spec, "catalogue_rates", money.DefaultExchangeRates)
+	catalogue_service := workflow.Service[catalogue.CatalogueService](spec, "catalogue_service", catalogue_db, catalogue_rates, catalogue.DefaultReservationTimeout)
 	applyDockerDefaults(catalogue_service)
 
-	frontend_service := workflow.Service[frontend.Frontend](spec, "frontend", user_service, catalogue_service, cart_service, order_service)
+	order_db := mongodb.Container(spec, "order_db")
+	order_rates := workflow.Service[money.ExchangeRates](spec, "order_rates", money.DefaultExchangeRates)
+	order_service := workflow.Service[order.OrderService](spec, "order_service", user_service, cart_service, catalogue_service, payment_service, shipping_service, shipevents, order_db, order_rates, order.DefaultPricing)
+	applyDockerDefaults(order_service)
+
+	token_service := workflow.Service[token.TokenService](spec, "token_service", "", token.DefaultTokenLifetime)
+	frontend_service := workflow.Service[frontend.Frontend](spec, "frontend", user_service, catalogue_service, cart_service, order_service, token_service)
 	applyDockerDefaults(frontend_service)
 
 	// Instantiate starting with the frontend which will trigger all other services to be instantiated
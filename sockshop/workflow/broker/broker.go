@@ -0,0 +1,62 @@
+// Package broker implements a stand-in message broker for SockShop: a queue that runs as a
+// service of its own, so that producers and consumers of the queue can be deployed to
+// separate processes.
+//
+// The [Broker] service holds the queue's messages and is called over RPC like any other
+// service.  Producers and consumers use a [Queue], a local client of the broker that
+// implements [backend.Queue], so that services written against [backend.Queue] can use
+// the broker, a simple in-memory queue, or RabbitMQ interchangeably.
+package broker
+
+import (
+	"context"
+	"time"
+
+	"github.com/blueprint-uservices/blueprint/runtime/core/backend"
+)
+
+// How long a call to the broker waits for the queue to have space or a message before
+// giving up.  Calls are bounded so that they return well within the one second timeout
+// of Blueprint's RPC clients; a [Queue] retries until its own context is cancelled.
+var Wait = 250 * time.Millisecond
+
+// Broker is a networked queue of messages.
+type Broker interface {
+	// Pushes a message to the tail of the queue, waiting for space in the queue if it is
+	// full.
+	//
+	// Reports whether the message was pushed before the wait expired.
+	Push(ctx context.Context, message string) (bool, error)
+
+	// Pops a message from the head of the queue, waiting for a message if the queue is
+	// empty.
+	//
+	// Returns the message and true, or false if no message arrived before the wait expired.
+	Pop(ctx context.Context) (string, bool, error)
+}
+
+// Instantiates a broker that holds its messages in queue, typically a simple in-memory
+// queue.
+func NewBroker(ctx context.Context, queue backend.Queue) (Broker, error) {
+	return &brokerImpl{q: queue}, nil
+}
+
+type brokerImpl struct {
+	q backend.Queue
+}
+
+// Push implements Broker.
+func (b *brokerImpl) Push(ctx context.Context, message string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, Wait)
+	defer cancel()
+	return b.q.Push(ctx, message)
+}
+
+// Pop implements Broker.
+func (b *brokerImpl) Pop(ctx context.Context) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, Wait)
+	defer cancel()
+	var message string
+	popped, err := b.q.Pop(ctx, &message)
+	return message, popped, err
+}
@@ -0,0 +1,79 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/blueprint-uservices/blueprint/runtime/plugins/simplequeue"
+	"github.com/stretchr/testify/require"
+)
+
+type item struct {
+	ID    string
+	Count int
+}
+
+func newTestQueue(t *testing.T) (Broker, Queue) {
+	ctx := context.Background()
+	q, err := simplequeue.NewSimpleQueue(ctx)
+	require.NoError(t, err)
+	b, err := NewBroker(ctx, q)
+	require.NoError(t, err)
+	client, err := NewQueue(ctx, b)
+	require.NoError(t, err)
+	return b, client
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	_, q := newTestQueue(t)
+
+	pushed, err := q.Push(ctx, item{ID: "first", Count: 1})
+	require.NoError(t, err)
+	require.True(t, pushed)
+	pushed, err = q.Push(ctx, item{ID: "second", Count: 2})
+	require.NoError(t, err)
+	require.True(t, pushed)
+
+	var dst item
+	popped, err := q.Pop(ctx, &dst)
+	require.NoError(t, err)
+	require.True(t, popped)
+	require.Equal(t, item{ID: "first", Count: 1}, dst)
+
+	popped, err = q.Pop(ctx, &dst)
+	require.NoError(t, err)
+	require.True(t, popped)
+	require.Equal(t, item{ID: "second", Count: 2}, dst)
+}
+
+func TestWait(t *testing.T) {
+	defer func(wait time.Duration) { Wait = wait }(Wait)
+	Wait = 10 * time.Millisecond
+	ctx := context.Background()
+	b, q := newTestQueue(t)
+
+	// The broker gives up on an empty queue after Wait
+	_, popped, err := b.Pop(ctx)
+	require.NoError(t, err)
+	require.False(t, popped)
+
+	// The client keeps waiting until an item arrives
+	go func() {
+		time.Sleep(5 * Wait)
+		q.Push(ctx, item{ID: "late"})
+	}()
+	var dst item
+	popped, err = q.Pop(ctx, &dst)
+	require.NoError(t, err)
+	require.True(t, popped)
+	require.Equal(t, "late", dst.ID)
+
+	// ...or until its context is cancelled
+	ctx, cancel := context.WithTimeout(ctx, 5*Wait)
+	defer cancel()
+	popped, err = q.Pop(ctx, &dst)
+	require.NoError(t, err)
+	require.False(t, popped)
+}
@@ -0,0 +1,70 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Queue is a client of a [Broker] that implements [backend.Queue].
+//
+// Items are encoded as JSON messages, so any item pushed must be JSON-serializable, and
+// the dst of a pop must be a pointer to a type that the item can be decoded into.
+//
+// A Queue is not deployed as a service of its own; each service that uses the queue
+// instantiates its own Queue in its own process.
+type Queue interface {
+	// Pushes an item to the tail of the queue.
+	//
+	// This call will block until the item is successfully pushed, or until the context
+	// is cancelled.
+	//
+	// Reports whether the item was pushed to the queue, or if an error was encountered.
+	Push(ctx context.Context, item interface{}) (bool, error)
+
+	// Pops an item from the front of the queue into dst.
+	//
+	// This call will block until an item is successfully popped, or until the context
+	// is cancelled.
+	//
+	// Reports whether an item was popped from the queue, or if an error was encountered.
+	Pop(ctx context.Context, dst interface{}) (bool, error)
+}
+
+// Instantiates a client of the remote broker that implements [backend.Queue].
+func NewQueue(ctx context.Context, remote Broker) (Queue, error) {
+	return &queueImpl{broker: remote}, nil
+}
+
+type queueImpl struct {
+	broker Broker
+}
+
+// Push implements Queue.
+func (q *queueImpl) Push(ctx context.Context, item interface{}) (bool, error) {
+	message, err := json.Marshal(item)
+	if err != nil {
+		return false, errors.Wrap(err, "unable to encode queue item")
+	}
+	for ctx.Err() == nil {
+		pushed, err := q.broker.Push(ctx, string(message))
+		if err != nil || pushed {
+			return pushed, err
+		}
+	}
+	return false, nil
+}
+
+// Pop implements Queue.
+func (q *queueImpl) Pop(ctx context.Context, dst interface{}) (bool, error) {
+	for ctx.Err() == nil {
+		message, popped, err := q.broker.Pop(ctx)
+		if err != nil {
+			return false, err
+		} else if popped {
+			return true, errors.Wrap(json.Unmarshal([]byte(message), dst), "unable to decode queue item")
+		}
+	}
+	return false, nil
+}
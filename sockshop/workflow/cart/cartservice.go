@@ -4,6 +4,7 @@ package cart
 import (
 	"context"
 
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
 	"github.com/blueprint-uservices/blueprint/runtime/core/backend"
 	"go.mongodb.org/mongo-driver/bson"
 )
@@ -47,9 +48,9 @@ type (
 	// A cart item is just an item ID and a quantity.  The catalogue service is responsible
 	// for managing the actual items.
 	Item struct {
-		ID        string  // Item ID will correspond to the ID used by the catalogue service
-		Quantity  int     // The quantity of this item in the car
-		UnitPrice float32 // The price of the item
+		ID        string      // Item ID will correspond to the ID used by the catalogue service
+		Quantity  int         // The quantity of this item in the car
+		UnitPrice money.Money // The price of the item, in the currency the customer is shopping in
 	}
 )
 
@@ -4,7 +4,9 @@ package catalogue
 import (
 	"context"
 	"strings"
+	"time"
 
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
 	"github.com/blueprint-uservices/blueprint/runtime/core/backend"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -14,14 +16,16 @@ type (
 	// The SockShop CatalogueService stores an inventory of Socks being sold by the shop.
 	CatalogueService interface {
 		// List socks that match any of the tags specified.  Sort the results in the specified order,
-		// then return a subset of the results.
-		List(ctx context.Context, tags []string, order string, pageNum, pageSize int) ([]Sock, error)
+		// then return a subset of the results.  If currency is not empty, the socks' prices are
+		// converted into currency.
+		List(ctx context.Context, tags []string, order string, pageNum, pageSize int, currency string) ([]Sock, error)
 
 		// Counts the number of socks that match any of the tags specified.
 		Count(ctx context.Context, tags []string) (int, error)
 
-		// Gets details about a [Sock]
-		Get(ctx context.Context, id string) (Sock, error)
+		// Gets details about a [Sock].  If currency is not empty, the sock's price is converted
+		// into currency.
+		Get(ctx context.Context, id string, currency string) (Sock, error)
 
 		// Lists all tags
 		Tags(ctx context.Context) ([]string, error)
@@ -31,6 +35,7 @@ type (
 
 		// New for Blueprint: adds a sock to the database.
 		// If sock.ID is "" then an ID is generated; otherwise the provided ID is used.
+		// The sock's price can be in any supported currency.
 		// If the sock has tags that aren't yet in the DB, then the tags are added to the DB.
 		// If the sock ID already exists in the database, then the sock is updated
 		// Returns the ID of the sock
@@ -38,20 +43,45 @@ type (
 
 		// New for Blueprint: deletes a sock from the database.
 		DeleteSock(ctx context.Context, id string) error
+
+		// New for Blueprint: reserves stock of socks under reservationID, so that it cannot be
+		// reserved by anybody else until the reservation is committed, released, or expires.
+		// Reserving is all-or-nothing; if any sock has too little stock, then an error
+		// wrapping [ErrOutOfStock] is returned and nothing is reserved.
+		// Reserving again under the same reservationID does not reserve the socks twice.
+		Reserve(ctx context.Context, reservationID string, items []ReservedItem) error
+
+		// New for Blueprint: takes the stock held by a reservation out of the catalogue.
+		// Returns an error wrapping [ErrNoReservation] if the reservation does not exist or
+		// has expired.  Committing a reservation again has no effect.
+		Commit(ctx context.Context, reservationID string) error
+
+		// New for Blueprint: releases the stock held by a reservation.  If the reservation was
+		// committed, its stock is returned to the catalogue.  Releasing a reservation that
+		// does not exist has no effect.
+		Release(ctx context.Context, reservationID string) error
 	}
 
 	// Sock describes the things on offer in the catalogue.
 	Sock struct {
-		ID          string   `json:"id" db:"sock_id"`
-		Name        string   `json:"name" db:"name"`
-		Description string   `json:"description" db:"description"`
-		ImageURL    []string `json:"imageUrl" db:"-"`
-		ImageURL_1  string   `json:"-" db:"image_url_1"`
-		ImageURL_2  string   `json:"-" db:"image_url_2"`
-		Price       float32  `json:"price" db:"price"`
-		Quantity    int      `json:"quantity" db:"quantity"`
-		Tags        []string `json:"tag" db:"-"`
-		TagString   string   `json:"-" db:"tag_name"`
+		ID            string      `json:"id" db:"sock_id"`
+		Name          string      `json:"name" db:"name"`
+		Description   string      `json:"description" db:"description"`
+		ImageURL      []string    `json:"imageUrl" db:"-"`
+		ImageURL_1    string      `json:"-" db:"image_url_1"`
+		ImageURL_2    string      `json:"-" db:"image_url_2"`
+		Price         money.Money `json:"price" db:"-"`
+		PriceAmount   int64       `json:"-" db:"price"`
+		PriceCurrency string      `json:"-" db:"currency"`
+		Quantity      int         `json:"quantity" db:"quantity"`
+		Tags          []string    `json:"tag" db:"-"`
+		TagString     string      `json:"-" db:"tag_name"`
+	}
+
+	// A quantity of a sock to reserve
+	ReservedItem struct {
+		SockID   string
+		Quantity int
 	}
 
 	tag struct {
@@ -66,10 +96,20 @@ var ErrNotFound = errors.New("not found")
 // ErrDBConnection is returned when connection with the database fails.
 var ErrDBConnection = errors.New("database connection error")
 
+// ErrOutOfStock is returned when there is not enough stock of a sock to reserve.
+var ErrOutOfStock = errors.New("out of stock")
+
+// ErrNoReservation is returned when committing a reservation that does not exist or has expired.
+var ErrNoReservation = errors.New("no such reservation")
+
+// The reservation timeout used by the catalogue service if none is specified
+const DefaultReservationTimeout = "10m"
+
 var baseQuery = `SELECT sock.sock_id, 
 						sock.name, 
 						sock.description, 
 						sock.price, 
+						sock.currency, 
 						sock.quantity, 
 						sock.image_url_1, 
 						sock.image_url_2, 
@@ -83,17 +123,29 @@ var baseQuery = `SELECT sock.sock_id,
 // Implementation of [CatalogueService].  Method implementations are pulled directly from the original
 // SockShop implementation, which was written in golang.
 type catalogueImpl struct {
-	db backend.RelationalDB
+	db                 backend.RelationalDB
+	rates              money.ExchangeRates
+	reservationTimeout time.Duration
 }
 
-// Creates a [CatalogueService] instance that stores the item catalogue in the provided relational database
-func NewCatalogueService(ctx context.Context, db backend.RelationalDB) (CatalogueService, error) {
-	c := &catalogueImpl{db: db}
+// Creates a [CatalogueService] instance that stores the item catalogue in the provided relational database.
+// Prices are converted into the currencies that socks are listed in using rates.
+// Reservations of stock expire after reservationTimeout, a duration such as "10m" as accepted by
+// [time.ParseDuration]; [DefaultReservationTimeout] is used if reservationTimeout is empty.
+func NewCatalogueService(ctx context.Context, db backend.RelationalDB, rates money.ExchangeRates, reservationTimeout string) (CatalogueService, error) {
+	if reservationTimeout == "" {
+		reservationTimeout = DefaultReservationTimeout
+	}
+	timeout, err := time.ParseDuration(reservationTimeout)
+	if err != nil || timeout <= 0 {
+		return nil, errors.Errorf("invalid reservationTimeout %v; expected a positive duration", reservationTimeout)
+	}
+	c := &catalogueImpl{db: db, rates: rates, reservationTimeout: timeout}
 	return c, c.createTables(ctx)
 }
 
 // List implements CatalogueService.
-func (s *catalogueImpl) List(ctx context.Context, tags []string, order string, pageNum int, pageSize int) ([]Sock, error) {
+func (s *catalogueImpl) List(ctx context.Context, tags []string, order string, pageNum int, pageSize int, currency string) ([]Sock, error) {
 	var socks []Sock
 	query := baseQuery
 
@@ -122,12 +174,12 @@ func (s *catalogueImpl) List(ctx context.Context, tags []string, order string, p
 	if err != nil {
 		return []Sock{}, errors.Wrap(err, "CatalogueService.List")
 	}
-	for i, s := range socks {
-		socks[i].ImageURL = []string{s.ImageURL_1, s.ImageURL_2}
-		socks[i].Tags = strings.Split(s.TagString, ",")
-	}
-
 	socks = cut(socks, pageNum, pageSize)
+	for i := range socks {
+		if err := s.fill(ctx, &socks[i], currency); err != nil {
+			return []Sock{}, errors.Wrap(err, "CatalogueService.List")
+		}
+	}
 
 	return socks, nil
 }
@@ -168,7 +220,7 @@ func (s *catalogueImpl) Count(ctx context.Context, tags []string) (int, error) {
 }
 
 // Get implements CatalogueService.
-func (s *catalogueImpl) Get(ctx context.Context, id string) (Sock, error) {
+func (s *catalogueImpl) Get(ctx context.Context, id string, currency string) (Sock, error) {
 	query := baseQuery + " WHERE sock.sock_id =? GROUP BY sock.sock_id;"
 
 	var sock Sock
@@ -177,12 +229,30 @@ func (s *catalogueImpl) Get(ctx context.Context, id string) (Sock, error) {
 		return Sock{}, errors.Wrapf(err, "CatalogueService.Get %v", id)
 	}
 
-	sock.ImageURL = []string{sock.ImageURL_1, sock.ImageURL_2}
-	sock.Tags = strings.Split(sock.TagString, ",")
+	if err := s.fill(ctx, &sock, currency); err != nil {
+		return Sock{}, errors.Wrapf(err, "CatalogueService.Get %v", id)
+	}
 
 	return sock, nil
 }
 
+// Fills in the fields of a sock read from the database that are not stored as columns of
+// their own, converting its price into currency if currency is not empty
+func (s *catalogueImpl) fill(ctx context.Context, sock *Sock, currency string) error {
+	sock.ImageURL = []string{sock.ImageURL_1, sock.ImageURL_2}
+	sock.Tags = strings.Split(sock.TagString, ",")
+	sock.Price = money.Money{Amount: sock.PriceAmount, Currency: sock.PriceCurrency}
+	if currency == "" {
+		return nil
+	}
+	price, err := s.rates.Convert(ctx, sock.Price, currency)
+	if err != nil {
+		return err
+	}
+	sock.Price = price
+	return nil
+}
+
 // Tags implements CatalogueService.
 func (s *catalogueImpl) Tags(ctx context.Context) ([]string, error) {
 	var tags []string
@@ -198,6 +268,10 @@ func (s *catalogueImpl) AddTags(ctx context.Context, tags []string) error {
 
 // AddSock implements CatalogueService.
 func (s *catalogueImpl) AddSock(ctx context.Context, sock Sock) (string, error) {
+	if _, err := money.MinorUnits(sock.Price.Currency); err != nil {
+		return "", errors.Wrapf(err, "invalid price %v", sock.Price)
+	}
+
 	// Delete any existing sock with this ID
 	if sock.ID != "" {
 		if err := s.DeleteSock(ctx, sock.ID); err != nil {
@@ -208,8 +282,8 @@ func (s *catalogueImpl) AddSock(ctx context.Context, sock Sock) (string, error)
 	}
 
 	// Add the sock
-	_, err := s.db.Exec(ctx, "INSERT INTO sock (sock_id, name, description, price, quantity, image_url_1, image_url_2) VALUES (?, ?, ?, ?, ?, ?, ?);",
-		sock.ID, sock.Name, sock.Description, sock.Price, sock.Quantity, sock.ImageURL_1, sock.ImageURL_2)
+	_, err := s.db.Exec(ctx, "INSERT INTO sock (sock_id, name, description, price, currency, quantity, image_url_1, image_url_2) VALUES (?, ?, ?, ?, ?, ?, ?, ?);",
+		sock.ID, sock.Name, sock.Description, sock.Price.Amount, sock.Price.Currency, sock.Quantity, sock.ImageURL_1, sock.ImageURL_2)
 	if err != nil {
 		return "", err
 	}
@@ -313,6 +387,9 @@ func (c *catalogueImpl) createTables(ctx context.Context) (err error) {
 	if _, err = c.db.Exec(ctx, createSockTagTable); err != nil {
 		return errors.Wrap(err, "unable to create socktag table")
 	}
+	if _, err = c.db.Exec(ctx, createReservationTable); err != nil {
+		return errors.Wrap(err, "unable to create reservation table")
+	}
 	return nil
 }
 
@@ -320,7 +397,8 @@ var createSockTable = `CREATE TABLE IF NOT EXISTS sock (
 	sock_id varchar(40) NOT NULL, 
 	name varchar(20), 
 	description varchar(200), 
-	price float, 
+	price bigint, 
+	currency varchar(3), 
 	quantity int, 
 	image_url_1 varchar(40), 
 	image_url_2 varchar(40), 
@@ -346,3 +424,12 @@ var createSockTagTable = `CREATE TABLE IF NOT EXISTS sock_tag (
 	FOREIGN KEY(tag_id)
 		REFERENCES tag(tag_id)
 );`
+
+var createReservationTable = `CREATE TABLE IF NOT EXISTS reservation (
+	reservation_id varchar(40) NOT NULL, 
+	sock_id varchar(40) NOT NULL, 
+	quantity int, 
+	expires bigint, 
+	committed boolean, 
+	PRIMARY KEY(reservation_id, sock_id)
+);`
@@ -0,0 +1,172 @@
+package catalogue
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A quantity of a sock held by a reservation.  Reservations of several socks have one
+// row per sock.
+type reservation struct {
+	ID        string `db:"reservation_id"`
+	SockID    string `db:"sock_id"`
+	Quantity  int    `db:"quantity"`
+	Expires   int64  `db:"expires"` // Unix time in nanoseconds, after which uncommitted stock is available again
+	Committed bool   `db:"committed"`
+}
+
+// Reserves a quantity of a sock, if the sock's stock less its unexpired, uncommitted
+// reservations covers it.  The stock is checked and reserved in a single statement, so
+// that concurrent reservations cannot oversell a sock.
+var reserveQuery = `INSERT INTO reservation (reservation_id, sock_id, quantity, expires, committed)
+					SELECT ?, sock.sock_id, ?, ?, FALSE
+					FROM sock
+					WHERE sock.sock_id=? AND sock.quantity >= ? + (
+						SELECT COALESCE(SUM(reservation.quantity), 0)
+						FROM reservation
+						WHERE reservation.sock_id=? AND reservation.committed=FALSE AND reservation.expires>?
+					);`
+
+// Reserve implements CatalogueService.
+func (s *catalogueImpl) Reserve(ctx context.Context, reservationID string, items []ReservedItem) error {
+	if reservationID == "" {
+		return errors.Errorf("missing reservationID")
+	}
+
+	now := time.Now()
+	if _, err := s.db.Exec(ctx, "DELETE FROM reservation WHERE committed=FALSE AND expires<=?;", now.UnixNano()); err != nil {
+		return errors.Wrap(err, "unable to delete expired reservations")
+	}
+
+	// Combine repeated socks, skipping any already reserved by an earlier attempt
+	existing, err := s.reservation(ctx, reservationID)
+	if err != nil {
+		return err
+	}
+	reserved := make(map[string]bool)
+	for _, r := range existing {
+		reserved[r.SockID] = true
+	}
+	var sockIDs []string
+	quantities := make(map[string]int)
+	for _, item := range items {
+		if item.Quantity <= 0 {
+			return errors.Errorf("invalid quantity %v of sock %v", item.Quantity, item.SockID)
+		} else if reserved[item.SockID] {
+			continue
+		} else if _, exists := quantities[item.SockID]; !exists {
+			sockIDs = append(sockIDs, item.SockID)
+		}
+		quantities[item.SockID] += item.Quantity
+	}
+
+	expires := now.Add(s.reservationTimeout).UnixNano()
+	for _, sockID := range sockIDs {
+		res, err := s.db.Exec(ctx, reserveQuery, reservationID, quantities[sockID], expires, sockID, quantities[sockID], sockID, now.UnixNano())
+		if err == nil {
+			var rows int64
+			if rows, err = res.RowsAffected(); err == nil && rows == 0 {
+				err = s.outOfStock(ctx, sockID, quantities[sockID], now.UnixNano())
+			}
+		}
+		if err != nil {
+			// Reserving is all-or-nothing
+			if releaseErr := s.Release(ctx, reservationID); releaseErr != nil {
+				return errors.Wrapf(releaseErr, "unable to release reservation %v after failing to reserve sock %v (%v)", reservationID, sockID, err)
+			}
+			return errors.Wrapf(err, "unable to reserve sock %v", sockID)
+		}
+	}
+	return nil
+}
+
+// Explains why quantity of a sock could not be reserved
+func (s *catalogueImpl) outOfStock(ctx context.Context, sockID string, quantity int, now int64) error {
+	var stock []int
+	if err := s.db.Select(ctx, &stock, "SELECT quantity FROM sock WHERE sock_id=?;", sockID); err != nil {
+		return err
+	} else if len(stock) == 0 {
+		return errors.Wrapf(ErrNotFound, "sock %v", sockID)
+	}
+	var reserved []int
+	if err := s.db.Select(ctx, &reserved, "SELECT quantity FROM reservation WHERE sock_id=? AND committed=FALSE AND expires>?;", sockID, now); err != nil {
+		return err
+	}
+	available := stock[0]
+	for _, q := range reserved {
+		available -= q
+	}
+	return errors.Wrapf(ErrOutOfStock, "%v of sock %v wanted but only %v available", quantity, sockID, max(available, 0))
+}
+
+// Commit implements CatalogueService.
+func (s *catalogueImpl) Commit(ctx context.Context, reservationID string) error {
+	rows, err := s.reservation(ctx, reservationID)
+	if err != nil {
+		return err
+	} else if len(rows) == 0 {
+		return errors.Wrapf(ErrNoReservation, "reservation %v has expired or does not exist", reservationID)
+	}
+	now := time.Now().UnixNano()
+	for _, r := range rows {
+		if !r.Committed && r.Expires <= now {
+			return errors.Wrapf(ErrNoReservation, "reservation %v has expired", reservationID)
+		}
+	}
+
+	// Each row is marked committed before its stock is taken, so that a retried commit
+	// takes it only once
+	for _, r := range rows {
+		if r.Committed {
+			continue
+		}
+		res, err := s.db.Exec(ctx, "UPDATE reservation SET committed=TRUE WHERE reservation_id=? AND sock_id=? AND committed=FALSE AND expires>?;", r.ID, r.SockID, now)
+		if err != nil {
+			return errors.Wrapf(err, "unable to commit reservation %v", reservationID)
+		}
+		if updated, err := res.RowsAffected(); err != nil {
+			return err
+		} else if updated == 0 {
+			continue
+		}
+		if _, err := s.db.Exec(ctx, "UPDATE sock SET quantity=quantity-? WHERE sock_id=?;", r.Quantity, r.SockID); err != nil {
+			return errors.Wrapf(err, "unable to take reserved stock of sock %v", r.SockID)
+		}
+	}
+	return nil
+}
+
+// Release implements CatalogueService.
+func (s *catalogueImpl) Release(ctx context.Context, reservationID string) error {
+	rows, err := s.reservation(ctx, reservationID)
+	if err != nil {
+		return err
+	}
+
+	// Each row is deleted before any committed stock is returned, so that a retried
+	// release returns it only once
+	for _, r := range rows {
+		res, err := s.db.Exec(ctx, "DELETE FROM reservation WHERE reservation_id=? AND sock_id=? AND committed=?;", r.ID, r.SockID, r.Committed)
+		if err != nil {
+			return errors.Wrapf(err, "unable to release reservation %v", reservationID)
+		}
+		if deleted, err := res.RowsAffected(); err != nil {
+			return err
+		} else if deleted == 0 || !r.Committed {
+			continue
+		}
+		if _, err := s.db.Exec(ctx, "UPDATE sock SET quantity=quantity+? WHERE sock_id=?;", r.Quantity, r.SockID); err != nil {
+			return errors.Wrapf(err, "unable to return reserved stock of sock %v", r.SockID)
+		}
+	}
+	return nil
+}
+
+// Gets the rows of a reservation
+func (s *catalogueImpl) reservation(ctx context.Context, reservationID string) ([]reservation, error) {
+	var rows []reservation
+	err := s.db.Select(ctx, &rows, "SELECT * FROM reservation WHERE reservation_id=?;", reservationID)
+	return rows, errors.Wrapf(err, "unable to get reservation %v", reservationID)
+}
@@ -1,4 +1,12 @@
 // Package frontend implements the SockShop frontend service, typically deployed via HTTP
+//
+// Customers' sessions are identified by session tokens, which are signed by a
+// [token.TokenService] so that they cannot be forged.  A session is started when a
+// customer first adds an item to their cart, and a new session is started when they log
+// in or register.  Methods that act on behalf of a user require the token of a logged in
+// session, returning an error wrapping [ErrNotLoggedIn] otherwise, and only give access
+// to the user's own orders, addresses, and cards, returning an error wrapping
+// [ErrNotAuthorised] otherwise.
 package frontend
 
 import (
@@ -6,7 +14,9 @@ import (
 
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/cart"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/catalogue"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/order"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/token"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/user"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -16,139 +26,234 @@ type (
 	// The SockShop Frontend receives requests from users and proxies them to the application's other services
 	Frontend interface {
 		// List items in cart for current logged in user, or for the current session if not logged in.
-		// SessionID can be the empty string for a non-logged in user / new session
-		GetCart(ctx context.Context, sessionID string) ([]cart.Item, error)
+		// sessionToken can be the empty string for a non-logged in user / new session
+		GetCart(ctx context.Context, sessionToken string) ([]cart.Item, error)
 
 		// Deletes the entire cart for a user/session
-		DeleteCart(ctx context.Context, sessionID string) error
+		DeleteCart(ctx context.Context, sessionToken string) error
 
 		// Removes an item from the user/session's cart
-		RemoveItem(ctx context.Context, sessionID string, itemID string) error
+		RemoveItem(ctx context.Context, sessionToken string, itemID string) error
 
-		// Adds an item to the user/session's cart.
-		// If there is no user or session, then a session is created and the sessionID is returned.
-		AddItem(ctx context.Context, sessionID string, itemID string) (newSessionID string, err error)
+		// Adds an item to the user/session's cart, priced in currency.  If currency is
+		// empty, the item is priced in the currency of its catalogue price.
+		// If there is no user or session, then a session is created and its token is returned.
+		AddItem(ctx context.Context, sessionToken string, itemID string, currency string) (newSessionToken string, err error)
 
-		// Update item quantity in the user/session's cart
-		// If there is no user or session, then a session is created and the sessionID is returned.
-		UpdateItem(ctx context.Context, sessionID string, itemID string, quantity int) (newSessionID string, err error)
+		// Update item quantity in the user/session's cart, pricing the item in currency
+		// as for AddItem.
+		// If there is no user or session, then a session is created and its token is returned.
+		UpdateItem(ctx context.Context, sessionToken string, itemID string, quantity int, currency string) (newSessionToken string, err error)
 
 		// List socks that match any of the tags specified.  Sort the results by the specified database column.
 		// order can be "" in which case the default order is used.
 		// pageNum is 1-indexed
 		// then return a subset of the results.
-		ListItems(ctx context.Context, tags []string, order string, pageNum, pageSize int) ([]catalogue.Sock, error)
+		// Prices are given in currency, or in their catalogue currency if currency is empty.
+		ListItems(ctx context.Context, tags []string, order string, pageNum, pageSize int, currency string) ([]catalogue.Sock, error)
 
-		// Gets details about a [Sock]
-		GetSock(ctx context.Context, itemID string) (catalogue.Sock, error)
+		// Gets details about a [Sock], with its price in currency, or in its catalogue
+		// currency if currency is empty
+		GetSock(ctx context.Context, itemID string, currency string) (catalogue.Sock, error)
 
 		// Lists all tags
 		ListTags(ctx context.Context) ([]string, error)
 
-		// Place an order for the specified items
-		NewOrder(ctx context.Context, userID, addressID, cardID, cartID string) (order.Order, error)
+		// Place an order for the items in the logged in user's cart, applying coupon if it
+		// is not empty.  The address and card must be the user's own.
+		// If idempotencyKey is not empty, placing an order again with the same key
+		// returns the original order; see [order.OrderService.NewOrder].
+		NewOrder(ctx context.Context, sessionToken, addressID, cardID, coupon, idempotencyKey string) (order.Order, error)
 
-		// Get all orders for a customer, sorted by date
-		GetOrders(ctx context.Context, userID string) ([]order.Order, error)
+		// Get a page of the logged in user's orders, sorted by the time they were placed; see
+		// [order.OrderService.GetOrders].
+		GetOrders(ctx context.Context, sessionToken string, query order.OrderQuery) (order.OrderPage, error)
 
-		// Get an order by ID
-		GetOrder(ctx context.Context, orderID string) (order.Order, error)
+		// Get one of the logged in user's orders by ID
+		GetOrder(ctx context.Context, sessionToken string, orderID string) (order.Order, error)
+
+		// Cancel one of the logged in user's orders that has not been shipped yet; see
+		// [order.OrderService.CancelOrder].
+		CancelOrder(ctx context.Context, sessionToken string, orderID string) (order.Order, error)
+
+		// Refund one of the logged in user's orders; see [order.OrderService.RefundOrder].
+		RefundOrder(ctx context.Context, sessionToken string, orderID string) (order.Order, error)
 
 		// Log in to an existing user account.  Returns an error if the password
 		// doesn't match the registered password
-		// Returns the new session ID, which will be the user ID of the logged in user.
-		Login(ctx context.Context, sessionID, username, password string) (newSessionID string, u user.User, err error)
+		// Returns the token of a new session for the logged in user.  If sessionToken is
+		// the token of a session that isn't logged in, its cart is merged into the user's.
+		Login(ctx context.Context, sessionToken, username, password string) (newSessionToken string, u user.User, err error)
 
 		// Register a new user account
-		// Returns the new session ID, which will be the user ID of the registered user.
-		Register(ctx context.Context, sessionID, username, password, email, first, last string) (newSessionID string, err error)
+		// Returns the token of a new session for the registered user.  If sessionToken is
+		// the token of a session that isn't logged in, its cart is merged into the user's.
+		Register(ctx context.Context, sessionToken, username, password, email, first, last string) (newSessionToken string, err error)
 
-		// Look up a user by customer ID
-		GetUser(ctx context.Context, userID string) (user.User, error)
+		// Look up the logged in user
+		GetUser(ctx context.Context, sessionToken string) (user.User, error)
 
-		// Look up an address by address ID
-		GetAddress(ctx context.Context, addressID string) (user.Address, error)
+		// Look up one of the logged in user's addresses by address ID
+		GetAddress(ctx context.Context, sessionToken string, addressID string) (user.Address, error)
 
-		// Adds a new address for a customer
-		PostAddress(ctx context.Context, userID string, address user.Address) (string, error)
+		// Adds a new address for the logged in user
+		PostAddress(ctx context.Context, sessionToken string, address user.Address) (string, error)
 
-		// Look up a card by card id.
-		GetCard(ctx context.Context, cardID string) (user.Card, error)
+		// Look up one of the logged in user's cards by card id.
+		GetCard(ctx context.Context, sessionToken string, cardID string) (user.Card, error)
 
-		// Adds a new card for a customer
-		PostCard(ctx context.Context, userID string, card user.Card) (string, error)
+		// Adds a new card for the logged in user
+		PostCard(ctx context.Context, sessionToken string, card user.Card) (string, error)
 
 		// Loads the catalogue in the catalogue service
 		LoadCatalogue(ctx context.Context) (string, error)
 	}
 )
 
+// ErrNotLoggedIn is returned when a method that requires a logged in user is called
+// without the valid token of a logged in session.
+var ErrNotLoggedIn = errors.New("not logged in")
+
+// ErrNotAuthorised is returned when a user asks for an order, address, or card that isn't theirs.
+var ErrNotAuthorised = errors.New("not authorised")
+
 type frontend struct {
 	user      user.UserService
 	catalogue catalogue.CatalogueService
 	cart      cart.CartService
 	order     order.OrderService
+	tokens    token.TokenService
 }
 
-// Instantiates the Frontend service, which makes calls to the user, catalogue, cart, and order services
-func NewFrontend(ctx context.Context, user user.UserService, catalogue catalogue.CatalogueService, cart cart.CartService, order order.OrderService) (Frontend, error) {
+// Instantiates the Frontend service, which makes calls to the user, catalogue, cart, and order services.
+// Session tokens are issued and validated by tokens.
+func NewFrontend(ctx context.Context, user user.UserService, catalogue catalogue.CatalogueService, cart cart.CartService, order order.OrderService, tokens token.TokenService) (Frontend, error) {
 	f := &frontend{
 		user:      user,
 		catalogue: catalogue,
 		cart:      cart,
 		order:     order,
+		tokens:    tokens,
 	}
 	return f, nil
 }
 
+// Returns the session of sessionToken, starting a new session if sessionToken is empty.
+// Also returns the session's token.
+func (f *frontend) session(ctx context.Context, sessionToken string) (token.Session, string, error) {
+	if sessionToken != "" {
+		session, err := f.tokens.Validate(ctx, sessionToken)
+		return session, sessionToken, err
+	}
+	session := token.Session{ID: uuid.NewString()}
+	sessionToken, err := f.tokens.Issue(ctx, session)
+	return session, sessionToken, err
+}
+
+// Returns the session of sessionToken, or an error wrapping [ErrNotLoggedIn] if the
+// session isn't logged in
+func (f *frontend) loggedIn(ctx context.Context, sessionToken string) (token.Session, error) {
+	if sessionToken == "" {
+		return token.Session{}, ErrNotLoggedIn
+	}
+	session, err := f.tokens.Validate(ctx, sessionToken)
+	if err != nil {
+		return token.Session{}, errors.Wrapf(ErrNotLoggedIn, "%v", err)
+	} else if session.UserID == "" {
+		return token.Session{}, ErrNotLoggedIn
+	}
+	return session, nil
+}
+
+// Starts a session for a user who has just logged in or registered, and returns its token.
+// If sessionToken is the token of a session that isn't logged in, the session's cart is
+// merged into the user's.  The carts of other users' sessions are never merged.
+func (f *frontend) logIn(ctx context.Context, sessionToken string, userID string) (string, error) {
+	if sessionToken != "" {
+		previous, err := f.tokens.Validate(ctx, sessionToken)
+		if err == nil && previous.UserID == "" {
+			if err := f.cart.MergeCarts(ctx, userID, previous.ID); err != nil {
+				return sessionToken, err
+			}
+		}
+	}
+	return f.tokens.Issue(ctx, token.Session{ID: uuid.NewString(), UserID: userID})
+}
+
+// The ID of a session's cart.  A logged in user's cart is kept under their user ID, so
+// that it outlives the session.
+func cartID(session token.Session) string {
+	if session.UserID != "" {
+		return session.UserID
+	}
+	return session.ID
+}
+
 // AddItem implements Frontend.
-func (f *frontend) AddItem(ctx context.Context, sessionID string, itemID string) (string, error) {
-	if sessionID == "" {
-		sessionID = uuid.NewString()
+func (f *frontend) AddItem(ctx context.Context, sessionToken string, itemID string, currency string) (string, error) {
+	session, sessionToken, err := f.session(ctx, sessionToken)
+	if err != nil {
+		return sessionToken, err
 	}
 
-	sock, err := f.catalogue.Get(ctx, itemID)
+	sock, err := f.catalogue.Get(ctx, itemID, currency)
 	if err != nil {
-		return sessionID, err
+		return sessionToken, err
 	}
 
-	_, err = f.cart.AddItem(ctx, sessionID, cart.Item{ID: sock.ID, Quantity: 1, UnitPrice: sock.Price})
-	return sessionID, err
+	_, err = f.cart.AddItem(ctx, cartID(session), cart.Item{ID: sock.ID, Quantity: 1, UnitPrice: sock.Price})
+	return sessionToken, err
 }
 
 // RemoteItem implements Frontend.
-func (f *frontend) RemoveItem(ctx context.Context, sessionID string, itemID string) error {
-	if sessionID == "" {
+func (f *frontend) RemoveItem(ctx context.Context, sessionToken string, itemID string) error {
+	if sessionToken == "" {
 		return nil
 	}
+	session, err := f.tokens.Validate(ctx, sessionToken)
+	if err != nil {
+		return err
+	}
 
-	return f.cart.RemoveItem(ctx, sessionID, itemID)
+	return f.cart.RemoveItem(ctx, cartID(session), itemID)
 }
 
 // GetCart implements Frontend.
-func (f *frontend) GetCart(ctx context.Context, sessionID string) ([]cart.Item, error) {
-	if sessionID == "" {
+func (f *frontend) GetCart(ctx context.Context, sessionToken string) ([]cart.Item, error) {
+	if sessionToken == "" {
 		return nil, nil
 	}
+	session, err := f.tokens.Validate(ctx, sessionToken)
+	if err != nil {
+		return nil, err
+	}
 
-	return f.cart.GetCart(ctx, sessionID)
+	return f.cart.GetCart(ctx, cartID(session))
 }
 
 // DeleteCart implements Frontend.
-func (f *frontend) DeleteCart(ctx context.Context, sessionID string) error {
-	if sessionID == "" {
+func (f *frontend) DeleteCart(ctx context.Context, sessionToken string) error {
+	if sessionToken == "" {
 		return nil
 	}
+	session, err := f.tokens.Validate(ctx, sessionToken)
+	if err != nil {
+		return err
+	}
 
-	return f.cart.DeleteCart(ctx, sessionID)
+	return f.cart.DeleteCart(ctx, cartID(session))
 }
 
 // GetUser implements Frontend.
-func (f *frontend) GetUser(ctx context.Context, userID string) (user.User, error) {
-	if userID == "" {
-		return user.User{}, errors.Errorf("no userID specified")
+func (f *frontend) GetUser(ctx context.Context, sessionToken string) (user.User, error) {
+	session, err := f.loggedIn(ctx, sessionToken)
+	if err != nil {
+		return user.User{}, err
 	}
+	return f.getUser(ctx, session.UserID)
+}
 
+func (f *frontend) getUser(ctx context.Context, userID string) (user.User, error) {
 	users, err := f.user.GetUsers(ctx, userID)
 	if err != nil {
 		return user.User{}, err
@@ -159,11 +264,46 @@ func (f *frontend) GetUser(ctx context.Context, userID string) (user.User, error
 	}
 }
 
+// Returns an error wrapping [ErrNotAuthorised] unless the address and card are the user's.
+// Either ID can be empty, in which case it is not checked.
+func (f *frontend) checkOwnership(ctx context.Context, userID string, addressID string, cardID string) error {
+	u, err := f.getUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if addressID != "" {
+		found := false
+		for _, address := range u.Addresses {
+			found = found || address.ID == addressID
+		}
+		if !found {
+			return errors.Wrapf(ErrNotAuthorised, "address %v", addressID)
+		}
+	}
+	if cardID != "" {
+		found := false
+		for _, card := range u.Cards {
+			found = found || card.ID == cardID
+		}
+		if !found {
+			return errors.Wrapf(ErrNotAuthorised, "card %v", cardID)
+		}
+	}
+	return nil
+}
+
 // GetAddresses implements Frontend.
-func (f *frontend) GetAddress(ctx context.Context, addressID string) (user.Address, error) {
+func (f *frontend) GetAddress(ctx context.Context, sessionToken string, addressID string) (user.Address, error) {
 	if addressID == "" {
 		return user.Address{}, errors.Errorf("no addressID specified")
 	}
+	session, err := f.loggedIn(ctx, sessionToken)
+	if err != nil {
+		return user.Address{}, err
+	}
+	if err := f.checkOwnership(ctx, session.UserID, addressID, ""); err != nil {
+		return user.Address{}, err
+	}
 	addrs, err := f.user.GetAddresses(ctx, addressID)
 	if err != nil {
 		return user.Address{}, err
@@ -175,10 +315,17 @@ func (f *frontend) GetAddress(ctx context.Context, addressID string) (user.Addre
 }
 
 // GetCards implements Frontend.
-func (f *frontend) GetCard(ctx context.Context, cardID string) (user.Card, error) {
+func (f *frontend) GetCard(ctx context.Context, sessionToken string, cardID string) (user.Card, error) {
 	if cardID == "" {
 		return user.Card{}, errors.Errorf("no cardID specified")
 	}
+	session, err := f.loggedIn(ctx, sessionToken)
+	if err != nil {
+		return user.Card{}, err
+	}
+	if err := f.checkOwnership(ctx, session.UserID, "", cardID); err != nil {
+		return user.Card{}, err
+	}
 	cards, err := f.user.GetCards(ctx, cardID)
 	if err != nil {
 		return user.Card{}, err
@@ -190,26 +337,66 @@ func (f *frontend) GetCard(ctx context.Context, cardID string) (user.Card, error
 }
 
 // GetOrder implements Frontend.
-func (f *frontend) GetOrder(ctx context.Context, orderID string) (order.Order, error) {
-	return f.order.GetOrder(ctx, orderID)
+func (f *frontend) GetOrder(ctx context.Context, sessionToken string, orderID string) (order.Order, error) {
+	session, err := f.loggedIn(ctx, sessionToken)
+	if err != nil {
+		return order.Order{}, err
+	}
+	return f.getOrder(ctx, session.UserID, orderID)
+}
+
+// Gets an order, returning an error wrapping [ErrNotAuthorised] if it isn't the user's
+func (f *frontend) getOrder(ctx context.Context, userID string, orderID string) (order.Order, error) {
+	o, err := f.order.GetOrder(ctx, orderID)
+	if err != nil {
+		return order.Order{}, err
+	} else if o.CustomerID != userID {
+		return order.Order{}, errors.Wrapf(ErrNotAuthorised, "order %v", orderID)
+	}
+	return o, nil
 }
 
 // GetOrders implements Frontend.
-func (f *frontend) GetOrders(ctx context.Context, userID string) ([]order.Order, error) {
-	if userID == "" {
-		return nil, errors.Errorf("no userID specified")
+func (f *frontend) GetOrders(ctx context.Context, sessionToken string, query order.OrderQuery) (order.OrderPage, error) {
+	session, err := f.loggedIn(ctx, sessionToken)
+	if err != nil {
+		return order.OrderPage{}, err
+	}
+	return f.order.GetOrders(ctx, session.UserID, query)
+}
+
+// CancelOrder implements Frontend.
+func (f *frontend) CancelOrder(ctx context.Context, sessionToken string, orderID string) (order.Order, error) {
+	session, err := f.loggedIn(ctx, sessionToken)
+	if err != nil {
+		return order.Order{}, err
+	}
+	if _, err := f.getOrder(ctx, session.UserID, orderID); err != nil {
+		return order.Order{}, err
 	}
-	return f.order.GetOrders(ctx, userID)
+	return f.order.CancelOrder(ctx, orderID)
+}
+
+// RefundOrder implements Frontend.
+func (f *frontend) RefundOrder(ctx context.Context, sessionToken string, orderID string) (order.Order, error) {
+	session, err := f.loggedIn(ctx, sessionToken)
+	if err != nil {
+		return order.Order{}, err
+	}
+	if _, err := f.getOrder(ctx, session.UserID, orderID); err != nil {
+		return order.Order{}, err
+	}
+	return f.order.RefundOrder(ctx, orderID)
 }
 
 // GetSock implements Frontend.
-func (f *frontend) GetSock(ctx context.Context, itemID string) (catalogue.Sock, error) {
-	return f.catalogue.Get(ctx, itemID)
+func (f *frontend) GetSock(ctx context.Context, itemID string, currency string) (catalogue.Sock, error) {
+	return f.catalogue.Get(ctx, itemID, currency)
 }
 
 // ListItems implements Frontend.
-func (f *frontend) ListItems(ctx context.Context, tags []string, order string, pageNum int, pageSize int) ([]catalogue.Sock, error) {
-	return f.catalogue.List(ctx, tags, order, pageNum, pageSize)
+func (f *frontend) ListItems(ctx context.Context, tags []string, order string, pageNum int, pageSize int, currency string) ([]catalogue.Sock, error) {
+	return f.catalogue.List(ctx, tags, order, pageNum, pageSize, currency)
 }
 
 // ListTags implements Frontend.
@@ -217,81 +404,93 @@ func (f *frontend) ListTags(ctx context.Context) ([]string, error) {
 	return f.catalogue.Tags(ctx)
 }
 
-// Login implements Frontend.  Merges the session into the user, and returns the user ID
-func (f *frontend) Login(ctx context.Context, sessionID string, username string, password string) (string, user.User, error) {
+// Login implements Frontend.  Merges the session into the user, and returns the token of the user's new session
+func (f *frontend) Login(ctx context.Context, sessionToken string, username string, password string) (string, user.User, error) {
 	u, err := f.user.Login(ctx, username, password)
 	if err != nil {
-		return sessionID, user.User{}, err
+		return sessionToken, user.User{}, err
 	}
 
-	if sessionID != "" {
-		if err := f.cart.MergeCarts(ctx, u.UserID, sessionID); err != nil {
-			return u.UserID, u, err
-		}
-	}
-
-	return u.UserID, u, nil
+	newSessionToken, err := f.logIn(ctx, sessionToken, u.UserID)
+	return newSessionToken, u, err
 }
 
 // NewOrder implements Frontend.
-func (f *frontend) NewOrder(ctx context.Context, userID string, addressID string, cardID string, cartID string) (order.Order, error) {
-	return f.order.NewOrder(ctx, userID, addressID, cardID, cartID)
+func (f *frontend) NewOrder(ctx context.Context, sessionToken string, addressID string, cardID string, coupon string, idempotencyKey string) (order.Order, error) {
+	session, err := f.loggedIn(ctx, sessionToken)
+	if err != nil {
+		return order.Order{}, err
+	}
+	if err := f.checkOwnership(ctx, session.UserID, addressID, cardID); err != nil {
+		return order.Order{}, err
+	}
+	return f.order.NewOrder(ctx, session.UserID, addressID, cardID, cartID(session), coupon, idempotencyKey)
 }
 
 // PostAddress implements Frontend.
-func (f *frontend) PostAddress(ctx context.Context, userID string, address user.Address) (string, error) {
-	return f.user.PostAddress(ctx, userID, address)
+func (f *frontend) PostAddress(ctx context.Context, sessionToken string, address user.Address) (string, error) {
+	session, err := f.loggedIn(ctx, sessionToken)
+	if err != nil {
+		return "", err
+	}
+	return f.user.PostAddress(ctx, session.UserID, address)
 }
 
 // PostCard implements Frontend.
-func (f *frontend) PostCard(ctx context.Context, userID string, card user.Card) (string, error) {
-	return f.user.PostCard(ctx, userID, card)
+func (f *frontend) PostCard(ctx context.Context, sessionToken string, card user.Card) (string, error) {
+	session, err := f.loggedIn(ctx, sessionToken)
+	if err != nil {
+		return "", err
+	}
+	return f.user.PostCard(ctx, session.UserID, card)
 }
 
 // Register implements Frontend.
-func (f *frontend) Register(ctx context.Context, sessionID string, username string, password string, email string, first string, last string) (string, error) {
+func (f *frontend) Register(ctx context.Context, sessionToken string, username string, password string, email string, first string, last string) (string, error) {
 	userID, err := f.user.Register(ctx, username, password, email, first, last)
 	if err != nil {
-		return sessionID, err
+		return sessionToken, err
 	}
 
-	if sessionID != "" {
-		return userID, f.cart.MergeCarts(ctx, userID, sessionID)
-	} else {
-		return userID, nil
-	}
+	return f.logIn(ctx, sessionToken, userID)
 }
 
 // UpdateItem implements Frontend.
-func (f *frontend) UpdateItem(ctx context.Context, sessionID string, itemID string, quantity int) (string, error) {
-	item, err := f.catalogue.Get(ctx, itemID)
+func (f *frontend) UpdateItem(ctx context.Context, sessionToken string, itemID string, quantity int, currency string) (string, error) {
+	session, sessionToken, err := f.session(ctx, sessionToken)
+	if err != nil {
+		return sessionToken, err
+	}
+
+	item, err := f.catalogue.Get(ctx, itemID, currency)
 	if err != nil {
-		return sessionID, err
+		return sessionToken, err
 	}
 
-	return sessionID, f.cart.UpdateItem(ctx, sessionID, cart.Item{ID: item.ID, Quantity: quantity, UnitPrice: item.Price})
+	return sessionToken, f.cart.UpdateItem(ctx, cartID(session), cart.Item{ID: item.ID, Quantity: quantity, UnitPrice: item.Price})
 }
 
 func (f *frontend) LoadCatalogue(ctx context.Context) (string, error) {
 	err_msg := "Failed to load catalogue"
 	var alltags = []string{"brown", "geek", "formal", "blue", "skin", "red", "action", "sport", "black", "magic", "green"}
 
-	sock := func(name, description string, price float32, qty int, url1, url2 string, tags ...string) catalogue.Sock {
+	// Prices are in cents of the default currency
+	sock := func(name, description string, price int64, qty int, url1, url2 string, tags ...string) catalogue.Sock {
 		return catalogue.Sock{Name: name, Description: description,
-			Price: price, Quantity: qty, ImageURL_1: url1, ImageURL_2: url2, Tags: tags}
+			Price: money.Money{Amount: price, Currency: money.DefaultCurrency}, Quantity: qty, ImageURL_1: url1, ImageURL_2: url2, Tags: tags}
 	}
 
 	var socks = []catalogue.Sock{
-		sock("Weave special", "Limited issue Weave socks.", 17.15, 33, "/catalogue/images/weave1.jpg", "/catalogue/images/weave2.jpg", "geek", "black"),
-		sock("Nerd leg", "For all those leg lovers out there. A perfect example of a swivel chair trained calf. Meticulously trained on a diet of sitting and Pina Coladas. Phwarr...", 7.99, 115, "/catalogue/images/bit_of_leg_1.jpeg", "/catalogue/images/bit_of_leg_2.jpeg", "blue", "skin"),
-		sock("Crossed", "A mature sock, crossed, with an air of nonchalance.", 17.32, 738, "/catalogue/images/cross_1.jpeg", "/catalogue/images/cross_2.jpeg", "formal", "blue", "red", "action"),
-		sock("SuperSport XL", "Ready for action. Engineers: be ready to smash that next bug! Be ready, with these super-action-sport-masterpieces. This particular engineer was chased away from the office with a stick.", 15.00, 820, "/catalogue/images/puma_1.jpeg", "/catalogue/images/puma_2.jpeg", "formal", "sport", "black"),
-		sock("Holy", "Socks fit for a Messiah. You too can experience walking in water with these special edition beauties. Each hole is lovingly proggled to leave smooth edges. The only sock approved by a higher power.", 99.99, 1, "/catalogue/images/holy_1.jpeg", "/catalogue/images/holy_2.jpeg", "action", "magic"),
-		sock("YouTube.sock", "We were not paid to sell this sock. It's just a bit geeky.", 10.99, 801, "/catalogue/images/youtube_1.jpeg", "/catalogue/images/youtube_2.jpeg", "geek", "formal"),
-		sock("Figueroa", "enim officia aliqua excepteur esse deserunt quis aliquip nostrud anim", 14, 808, "/catalogue/images/WAT.jpg", "/catalogue/images/WAT2.jpg", "formal", "blue", "green"),
-		sock("Classic", "Keep it simple.", 12, 127, "/catalogue/images/classic.jpg", "/catalogue/images/classic2.jpg", "brown", "green"),
-		sock("Colourful", "proident occaecat irure et excepteur labore minim nisi amet irure", 18, 438, "/catalogue/images/colourful_socks.jpg", "/catalogue/images/colourful_socks.jpg", "brown", "blue"),
-		sock("Cat socks", "consequat amet cupidatat minim laborum tempor elit ex consequat in", 15, 175, "/catalogue/images/catsocks.jpg", "/catalogue/images/catsocks2.jpg", "brown", "formal", "green"),
+		sock("Weave special", "Limited issue Weave socks.", 1715, 33, "/catalogue/images/weave1.jpg", "/catalogue/images/weave2.jpg", "geek", "black"),
+		sock("Nerd leg", "For all those leg lovers out there. A perfect example of a swivel chair trained calf. Meticulously trained on a diet of sitting and Pina Coladas. Phwarr...", 799, 115, "/catalogue/images/bit_of_leg_1.jpeg", "/catalogue/images/bit_of_leg_2.jpeg", "blue", "skin"),
+		sock("Crossed", "A mature sock, crossed, with an air of nonchalance.", 1732, 738, "/catalogue/images/cross_1.jpeg", "/catalogue/images/cross_2.jpeg", "formal", "blue", "red", "action"),
+		sock("SuperSport XL", "Ready for action. Engineers: be ready to smash that next bug! Be ready, with these super-action-sport-masterpieces. This particular engineer was chased away from the office with a stick.", 1500, 820, "/catalogue/images/puma_1.jpeg", "/catalogue/images/puma_2.jpeg", "formal", "sport", "black"),
+		sock("Holy", "Socks fit for a Messiah. You too can experience walking in water with these special edition beauties. Each hole is lovingly proggled to leave smooth edges. The only sock approved by a higher power.", 9999, 1, "/catalogue/images/holy_1.jpeg", "/catalogue/images/holy_2.jpeg", "action", "magic"),
+		sock("YouTube.sock", "We were not paid to sell this sock. It's just a bit geeky.", 1099, 801, "/catalogue/images/youtube_1.jpeg", "/catalogue/images/youtube_2.jpeg", "geek", "formal"),
+		sock("Figueroa", "enim officia aliqua excepteur esse deserunt quis aliquip nostrud anim", 1400, 808, "/catalogue/images/WAT.jpg", "/catalogue/images/WAT2.jpg", "formal", "blue", "green"),
+		sock("Classic", "Keep it simple.", 1200, 127, "/catalogue/images/classic.jpg", "/catalogue/images/classic2.jpg", "brown", "green"),
+		sock("Colourful", "proident occaecat irure et excepteur labore minim nisi amet irure", 1800, 438, "/catalogue/images/colourful_socks.jpg", "/catalogue/images/colourful_socks.jpg", "brown", "blue"),
+		sock("Cat socks", "consequat amet cupidatat minim laborum tempor elit ex consequat in", 1500, 175, "/catalogue/images/catsocks.jpg", "/catalogue/images/catsocks2.jpg", "brown", "formal", "green"),
 	}
 
 	err := f.catalogue.AddTags(ctx, alltags)
@@ -0,0 +1,131 @@
+// Package money provides the [Money] type in which SockShop's services hold prices and
+// payments, and [ExchangeRates] for converting money between currencies.
+//
+// Money is held as an integer number of the currency's minor units, such as cents, so
+// that adding up prices is exact.
+package money
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// An amount of money in a currency
+type Money struct {
+	Amount   int64  // The amount in the currency's minor units, such as cents
+	Currency string // The ISO 4217 code of the currency, such as USD
+}
+
+// The currency in which SockShop's catalogue and pricing rules are priced
+const DefaultCurrency = "USD"
+
+// ErrUnknownCurrency is returned for a currency that is not supported.
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// ErrCurrencyMismatch is returned when combining money in different currencies.
+var ErrCurrencyMismatch = errors.New("currencies do not match")
+
+// The number of decimal places of the minor units of each supported currency
+var minorUnits = map[string]int{
+	"AUD": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"INR": 2,
+	"JPY": 0,
+	"KWD": 3,
+	"SEK": 2,
+	"USD": 2,
+}
+
+// Returns the number of decimal places of currency's minor units, such as 2 for USD, whose
+// minor unit is the cent.  Returns an error wrapping [ErrUnknownCurrency] if the currency
+// is not supported.
+func MinorUnits(currency string) (int, error) {
+	places, exists := minorUnits[currency]
+	if !exists {
+		return 0, errors.Wrapf(ErrUnknownCurrency, "%q", currency)
+	}
+	return places, nil
+}
+
+// Parses money written as a decimal amount followed by a currency code, such as
+// 4.99 USD.
+func Parse(value string) (Money, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return Money{}, errors.Errorf("invalid money %q; expected an amount and a currency such as 4.99 USD", value)
+	}
+	return ParseAmount(fields[0], fields[1])
+}
+
+// Parses a decimal amount of currency, such as 4.99, that has no more decimal places than
+// the currency's minor units.
+func ParseAmount(amount string, currency string) (Money, error) {
+	places, err := MinorUnits(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	minor, err := ParseDecimal(amount, places)
+	if err != nil {
+		return Money{}, errors.Wrapf(err, "invalid amount of %v", currency)
+	}
+	return Money{Amount: minor, Currency: currency}, nil
+}
+
+// Parses a decimal with at most places decimal places, such as 4.99, into an integer
+// scaled by 10^places, such as 499, without rounding through floating point.
+func ParseDecimal(value string, places int) (int64, error) {
+	negative := strings.HasPrefix(value, "-")
+	whole, fraction, _ := strings.Cut(strings.TrimPrefix(value, "-"), ".")
+	if len(fraction) > places {
+		return 0, errors.Errorf("%v has more than %v decimal places", value, places)
+	}
+	fraction += strings.Repeat("0", places-len(fraction))
+	scaled, err := strconv.ParseUint(whole+fraction, 10, 63)
+	if err != nil || whole == "" {
+		return 0, errors.Errorf("%v is not a decimal", value)
+	}
+	if negative {
+		return -int64(scaled), nil
+	}
+	return int64(scaled), nil
+}
+
+// Formats money as a decimal amount followed by its currency code, such as 4.99 USD
+func (m Money) String() string {
+	places, err := MinorUnits(m.Currency)
+	if err != nil {
+		return strconv.FormatInt(m.Amount, 10) + " " + m.Currency
+	}
+	digits := strconv.FormatInt(max(m.Amount, -m.Amount), 10)
+	if len(digits) <= places {
+		digits = strings.Repeat("0", places-len(digits)+1) + digits
+	}
+	amount := digits
+	if places > 0 {
+		amount = digits[:len(digits)-places] + "." + digits[len(digits)-places:]
+	}
+	if m.Amount < 0 {
+		amount = "-" + amount
+	}
+	return amount + " " + m.Currency
+}
+
+// Adds money in the same currency.  Returns an error wrapping [ErrCurrencyMismatch] if
+// the currencies differ.
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, errors.Wrapf(ErrCurrencyMismatch, "cannot add %v to %v", other, m)
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// Multiplies money by a quantity
+func (m Money) Times(quantity int) Money {
+	return Money{Amount: m.Amount * int64(quantity), Currency: m.Currency}
+}
@@ -0,0 +1,100 @@
+package money
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	valid := map[string]Money{
+		"4.99 USD":  {499, "USD"},
+		"4.9 USD":   {490, "USD"},
+		"4 USD":     {400, "USD"},
+		"0.05 EUR":  {5, "EUR"},
+		"-1.50 GBP": {-150, "GBP"},
+		"1500 JPY":  {1500, "JPY"},
+		"1.250 KWD": {1250, "KWD"},
+	}
+	for value, expected := range valid {
+		m, err := Parse(value)
+		require.NoError(t, err, value)
+		require.Equal(t, expected, m, value)
+	}
+
+	for _, value := range []string{"", "4.99", "USD 4.99", "4.999 USD", "1.5 JPY", "4.99 XXX", "four USD", ".50 USD", "4.99 USD extra"} {
+		_, err := Parse(value)
+		require.Error(t, err, value)
+	}
+	_, err := Parse("4.99 XXX")
+	require.ErrorIs(t, err, ErrUnknownCurrency)
+
+	// Decimals that aren't amounts of money, such as percentages
+	scaled, err := ParseDecimal("7.25", 2)
+	require.NoError(t, err)
+	require.Equal(t, int64(725), scaled)
+	scaled, err = ParseDecimal("-12", 3)
+	require.NoError(t, err)
+	require.Equal(t, int64(-12000), scaled)
+	_, err = ParseDecimal("7.255", 2)
+	require.Error(t, err)
+}
+
+func TestString(t *testing.T) {
+	require.Equal(t, "4.99 USD", Money{499, "USD"}.String())
+	require.Equal(t, "0.05 EUR", Money{5, "EUR"}.String())
+	require.Equal(t, "0.00 USD", Money{0, "USD"}.String())
+	require.Equal(t, "-1.50 GBP", Money{-150, "GBP"}.String())
+	require.Equal(t, "1500 JPY", Money{1500, "JPY"}.String())
+	require.Equal(t, "0.001 KWD", Money{1, "KWD"}.String())
+}
+
+func TestArithmetic(t *testing.T) {
+	sum, err := Money{499, "USD"}.Add(Money{1, "USD"})
+	require.NoError(t, err)
+	require.Equal(t, Money{500, "USD"}, sum)
+	_, err = Money{499, "USD"}.Add(Money{1, "EUR"})
+	require.ErrorIs(t, err, ErrCurrencyMismatch)
+
+	// Adding prices is exact, unlike adding them as floats
+	total := Money{0, "USD"}
+	for i := 0; i < 10; i++ {
+		total, err = total.Add(Money{10, "USD"})
+		require.NoError(t, err)
+	}
+	require.Equal(t, Money{100, "USD"}, total)
+	require.Equal(t, Money{2997, "USD"}, Money{999, "USD"}.Times(3))
+}
+
+func TestFixedRates(t *testing.T) {
+	ctx := context.Background()
+	rates, err := NewFixedRates(ctx, "USD 1; EUR 0.92; JPY 149.50; KWD 0.307")
+	require.NoError(t, err)
+
+	convert := func(amount Money, currency string) Money {
+		converted, err := rates.Convert(ctx, amount, currency)
+		require.NoError(t, err)
+		return converted
+	}
+	require.Equal(t, Money{92, "EUR"}, convert(Money{100, "USD"}, "EUR"))
+	require.Equal(t, Money{150, "JPY"}, convert(Money{100, "USD"}, "JPY"))
+	require.Equal(t, Money{163, "JPY"}, convert(Money{100, "EUR"}, "JPY"))
+	require.Equal(t, Money{1, "USD"}, convert(Money{1, "JPY"}, "USD"))
+	require.Equal(t, Money{-163, "JPY"}, convert(Money{-100, "EUR"}, "JPY"))
+	require.Equal(t, Money{307, "KWD"}, convert(Money{100, "USD"}, "KWD"))
+	require.Equal(t, Money{1715, "USD"}, convert(Money{1715, "USD"}, "USD"))
+
+	// Money in a currency without a rate cannot be converted, even if it is supported
+	_, err = rates.Convert(ctx, Money{100, "USD"}, "GBP")
+	require.ErrorIs(t, err, ErrUnknownCurrency)
+	_, err = rates.Convert(ctx, Money{100, "GBP"}, "USD")
+	require.ErrorIs(t, err, ErrUnknownCurrency)
+
+	for _, invalid := range []string{"USD", "USD one", "USD 0", "USD -1", "XXX 1", "USD 1 EUR"} {
+		_, err := NewFixedRates(ctx, invalid)
+		require.Error(t, err, invalid)
+	}
+	_, err = NewFixedRates(ctx, DefaultExchangeRates)
+	require.NoError(t, err)
+}
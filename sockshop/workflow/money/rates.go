@@ -0,0 +1,103 @@
+package money
+
+import (
+	"context"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ExchangeRates converts money between currencies.  Services that show or compare
+// prices in more than one currency are given an ExchangeRates, so that the source of
+// rates can be swapped.
+type ExchangeRates interface {
+	// Converts amount into currency, rounding half away from zero to the nearest minor
+	// unit of currency.  Converting money into its own currency returns it unchanged.
+	// Returns an error wrapping [ErrUnknownCurrency] if there is no rate for either
+	// currency.
+	Convert(ctx context.Context, amount Money, currency string) (Money, error)
+}
+
+// The exchange rates used by the SockShop wiring specs
+const DefaultExchangeRates = "USD 1; EUR 0.92; GBP 0.79; JPY 149.50; CHF 0.88; CAD 1.36"
+
+// Returns exchange rates that never change.
+//
+// rates is a list of rates separated by semicolons.  Each rate is a currency code
+// followed by the value of one unit of a common base currency in that currency, for
+// example
+//
+//	USD 1; EUR 0.92; JPY 149.50
+//
+// converts 1.00 USD into 0.92 EUR or 150 JPY, and 1.00 EUR into 163 JPY.
+//
+// Fixed rates share no state, so each service that converts money can be given its own
+// instance in its own process, and prices are never converted over RPC.
+func NewFixedRates(ctx context.Context, rates string) (ExchangeRates, error) {
+	r := &fixedRates{rates: make(map[string]*big.Rat)}
+	for _, rate := range strings.Split(rates, ";") {
+		fields := strings.Fields(rate)
+		if len(fields) == 0 {
+			continue
+		} else if len(fields) != 2 {
+			return nil, errors.Errorf("invalid exchange rate %q; expected a currency followed by a rate", strings.TrimSpace(rate))
+		}
+		if _, err := MinorUnits(fields[0]); err != nil {
+			return nil, errors.Wrapf(err, "invalid exchange rate %q", strings.TrimSpace(rate))
+		}
+		value, ok := new(big.Rat).SetString(fields[1])
+		if !ok || value.Sign() <= 0 {
+			return nil, errors.Errorf("invalid exchange rate %q; expected a positive rate", strings.TrimSpace(rate))
+		}
+		r.rates[fields[0]] = value
+	}
+	return r, nil
+}
+
+type fixedRates struct {
+	rates map[string]*big.Rat // The value of one unit of the base currency in each currency
+}
+
+// Convert implements ExchangeRates.
+func (r *fixedRates) Convert(ctx context.Context, amount Money, currency string) (Money, error) {
+	if amount.Currency == currency {
+		return amount, nil
+	}
+	from, err := r.rate(amount.Currency)
+	if err != nil {
+		return Money{}, err
+	}
+	to, err := r.rate(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	// Scale the amount from the minor units of one currency to those of the other
+	fromPlaces, _ := MinorUnits(amount.Currency)
+	toPlaces, _ := MinorUnits(currency)
+	converted := new(big.Rat).SetInt64(amount.Amount)
+	converted.Mul(converted, to)
+	converted.Quo(converted, from)
+	converted.Mul(converted, new(big.Rat).SetFrac(pow10(toPlaces), pow10(fromPlaces)))
+	return Money{Amount: round(converted), Currency: currency}, nil
+}
+
+func (r *fixedRates) rate(currency string) (*big.Rat, error) {
+	rate, exists := r.rates[currency]
+	if !exists {
+		return nil, errors.Wrapf(ErrUnknownCurrency, "no exchange rate for %q", currency)
+	}
+	return rate, nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// Rounds x half away from zero to the nearest integer
+func round(x *big.Rat) int64 {
+	doubled := new(big.Int).Mul(x.Num(), big.NewInt(2))
+	doubled.Add(doubled, new(big.Int).Mul(x.Denom(), big.NewInt(int64(x.Sign()))))
+	return new(big.Int).Quo(doubled, new(big.Int).Mul(x.Denom(), big.NewInt(2))).Int64()
+}
@@ -1,15 +1,27 @@
 // Package order implements the SockShop orders microservice.
 //
 // The service calls other services to collect information and then
-// submits the order to the shipping service
+// submits the order to the shipping service.  Placing an order is a saga:
+// if any step fails, the steps already completed are compensated, so that
+// reserved stock is released, payment is voided or refunded, the shipment
+// cancelled, and the cart restored.
+//
+// Each order has a status, which follows the order's shipment through
+// status events published by the shipping service; the order's history
+// of status transitions is stored with the order.  An order can be
+// cancelled until it is shipped, and refunded after it is paid for.
 package order
 
 import (
 	"context"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/cart"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/catalogue"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/payment"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/shipping"
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/user"
@@ -17,6 +29,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/exp/slog"
 )
 
 type (
@@ -25,53 +38,154 @@ type (
 	// The service calls other services to collect information and then
 	// submits the order to the shipping service
 	OrderService interface {
-		// Place an order for the specified items
-		NewOrder(ctx context.Context, customerID, addressID, cardID, cartID string) (Order, error)
+		// Place an order for the specified items.  If coupon is not empty, the coupon's
+		// discount is applied to the order; an error is returned if the coupon is not
+		// known.
+		//
+		// If idempotencyKey is not empty, placing an order again with the same customer
+		// and key returns the original order instead of placing another, so a request
+		// that timed out can be retried safely.  If the original attempt is still in
+		// progress, the retry waits for it to finish.  If it failed, an error is
+		// returned; a failed order must be retried with a new key.
+		NewOrder(ctx context.Context, customerID, addressID, cardID, cartID, coupon, idempotencyKey string) (Order, error)
 
-		// Get all orders for a customer, sorted by date
-		GetOrders(ctx context.Context, customerID string) ([]Order, error)
+		// Get a page of a customer's orders, sorted by the time they were placed.  query
+		// filters the orders by status and by the time they were placed; its zero
+		// value gets the customer's most recent orders.  To get the next page, query
+		// again with the returned page's cursor.  The orders' shipments reflect the
+		// shipments' current statuses.
+		//
+		// As the database cannot sort or limit the orders it finds, each page reads the
+		// time and ID of every order that matches query, though it reads only the page's
+		// orders in full.  Bounding the query by time with From and To bounds this read.
+		GetOrders(ctx context.Context, customerID string, query OrderQuery) (OrderPage, error)
 
-		// Get an order by ID
+		// Get an order by ID.  The order's shipment reflects the shipment's current status.
 		GetOrder(ctx context.Context, orderID string) (Order, error)
+
+		// Cancel an order that has not been shipped yet.  The order's shipment is
+		// stopped and its payment is refunded.  Returns the cancelled order, or an error
+		// if the order has already been shipped; a shipped order can be refunded
+		// instead.
+		//
+		// Cancelling an order that was already cancelled has no effect, so a
+		// cancellation that failed part-way can be retried.
+		CancelOrder(ctx context.Context, orderID string) (Order, error)
+
+		// Refund an order.  If the order has not been shipped yet, its shipment is
+		// stopped.  The order's payment is reversed.  Returns the refunded order, or an
+		// error if the order was cancelled.
+		//
+		// Refunding an order that was already refunded has no effect, so a refund that
+		// failed part-way can be retried.
+		RefundOrder(ctx context.Context, orderID string) (Order, error)
 	}
 
 	// A successfully placed order
 	Order struct {
-		ID         string
-		CustomerID string
-		Customer   user.User
-		Address    user.Address
-		Card       user.Card
-		Items      []cart.Item
-		Shipment   shipping.Shipment
-		Date       string
-		Total      float32
+		ID              string
+		CustomerID      string
+		Customer        user.User
+		Address         user.Address
+		Card            user.Card
+		Items           []cart.Item
+		Shipment        shipping.Shipment
+		Time            int64          // Unix time in nanoseconds that the order was placed
+		Price           Price          // The breakdown of the order's price
+		Total           money.Money    // The order's total price
+		AuthorisationID string         // The authorisation of the order's payment
+		IdempotencyKey  string         // The key the order was placed with, if any
+		Status          string         // The order's current status
+		History         []StatusChange // The order's status transitions, oldest first
 	}
 )
 
 // Creates a new [OrderService] instance.
 // Customer, Address, and Card information will be looked up in the provided userService
-// Successfully placed orders will be stored in [orderDB]
-func NewOrderService(ctx context.Context, userService user.UserService, cartService cart.CartService, payments payment.PaymentService, shipping shipping.ShippingService, orderDB backend.NoSQLDatabase) (OrderService, error) {
+// The prices of the items in the cart are checked against catalogueService, which
+// also holds the items' stock; stock is reserved while an order is being placed.
+// Successfully placed orders will be stored in [orderDB], along with a saga log of
+// orders being placed.  Orders left incomplete by a previous instance are recovered
+// from the saga log, and orders' statuses are updated from the [shipping.StatusEvent]s
+// published by the shipping service to shipmentEvents, while the service runs.
+// Orders are priced according to the pricing rules in pricing, as described by
+// [NewPricer]; [DefaultPricing] is used if pricing is empty.  Orders are priced in the
+// currency of their items, converting the rules' amounts using rates.
+//
+// A retried idempotent order only waits for an attempt in progress on the same instance;
+// if the service is replicated, a retry that reaches another replica while the order is
+// being placed returns an error instead.
+func NewOrderService(ctx context.Context, userService user.UserService, cartService cart.CartService, catalogueService catalogue.CatalogueService, payments payment.PaymentService, shipping shipping.ShippingService, shipmentEvents backend.Queue, orderDB backend.NoSQLDatabase, rates money.ExchangeRates, pricing string) (OrderService, error) {
+	if pricing == "" {
+		pricing = DefaultPricing
+	}
+	pricer, err := NewPricer(pricing, rates)
+	if err != nil {
+		return nil, err
+	}
 	collection, err := orderDB.GetCollection(ctx, "order_service", "orders")
 	if err != nil {
 		return nil, err
 	}
-	return &orderImpl{
-		users:    userService,
-		carts:    cartService,
-		payments: payments,
-		shipping: shipping,
-		db:       collection,
-	}, nil
+	sagas, err := orderDB.GetCollection(ctx, "order_service", "sagas")
+	if err != nil {
+		return nil, err
+	}
+	s := &orderImpl{
+		users:     userService,
+		carts:     cartService,
+		catalogue: catalogueService,
+		payments:  payments,
+		shipping:  shipping,
+		events:    shipmentEvents,
+		db:        collection,
+		sagas:     sagas,
+		pricer:    pricer,
+		placing:   make(map[string]chan struct{}),
+		started:   time.Now().UnixNano(),
+	}
+	return s, nil
 }
 
 type orderImpl struct {
-	users    user.UserService
-	carts    cart.CartService
-	payments payment.PaymentService
-	shipping shipping.ShippingService
-	db       backend.NoSQLCollection
+	users     user.UserService
+	carts     cart.CartService
+	catalogue catalogue.CatalogueService
+	payments  payment.PaymentService
+	shipping  shipping.ShippingService
+	events    backend.Queue
+	db        backend.NoSQLCollection
+	sagas     backend.NoSQLCollection
+	pricer    Pricer
+
+	lock    sync.Mutex
+	placing map[string]chan struct{} // Idempotent orders being placed by this instance; closed once placed or failed
+
+	started int64       // Unix time in nanoseconds at which the instance was created
+	running atomic.Bool // Whether Run is running
+}
+
+// Recovers the orders left incomplete by a previous instance, and applies shipment status
+// events to orders until ctx is cancelled.  Does not exit when an error is encountered;
+// only when ctx is cancelled, after any recovery in progress has finished.
+//
+// Blueprint calls Run in a separate goroutine when the order service is instantiated, and
+// again for each client of the service in the same process, which is the same instance;
+// Run returns immediately if it is already running.
+func (s *orderImpl) Run(ctx context.Context) error {
+	if !s.running.CompareAndSwap(false, true) {
+		return nil
+	}
+	defer s.running.Store(false)
+
+	recovered := make(chan struct{})
+	go func() {
+		defer close(recovered)
+		s.runRecovery(ctx, s.started)
+	}()
+	s.runShipmentEvents(ctx)
+	<-recovered
+	return nil
 }
 
 // GetOrder implements OrderService.
@@ -89,25 +203,68 @@ func (s *orderImpl) GetOrder(ctx context.Context, orderID string) (Order, error)
 	if !hasResult {
 		return Order{}, errors.Errorf("order %v does not exist", orderID)
 	}
+
+	// The stored shipment is a snapshot from when the order was placed
+	order.Shipment, err = s.shipping.GetShipment(ctx, order.Shipment.ID)
+	if err != nil {
+		return Order{}, err
+	}
 	return order, nil
 }
 
-// GetOrders implements OrderService.
-func (s *orderImpl) GetOrders(ctx context.Context, customerID string) ([]Order, error) {
-	filter := bson.D{{"customerid", customerID}}
-	cursor, err := s.db.FindMany(ctx, filter)
+// CancelOrder implements OrderService.
+func (s *orderImpl) CancelOrder(ctx context.Context, orderID string) (Order, error) {
+	return s.reverseOrder(ctx, orderID, StatusCancelled)
+}
+
+// RefundOrder implements OrderService.
+func (s *orderImpl) RefundOrder(ctx context.Context, orderID string) (Order, error) {
+	return s.reverseOrder(ctx, orderID, StatusRefunded)
+}
+
+// Cancels or refunds an order: stops the order's shipment if it has not been shipped,
+// reverses its payment, and records status on the order.  Payment is captured when an
+// order is placed, so it is reversed by refunding it.
+func (s *orderImpl) reverseOrder(ctx context.Context, orderID, status string) (Order, error) {
+	order, err := s.GetOrder(ctx, orderID)
 	if err != nil {
-		return nil, err
+		return Order{}, err
+	} else if order.Status == status {
+		return order, nil
+	} else if !canTransition(order.Status, status) {
+		return Order{}, errors.Wrapf(errInvalidTransition, "order %v cannot be %v as it is %v", orderID, status, order.Status)
 	}
-	var orders []Order
-	if err := cursor.All(ctx, &orders); err != nil {
-		return nil, err
+
+	// Stop the shipment and return its items to stock
+	shipped := order.Shipment.Status == shipping.StatusShipped || order.Shipment.Status == shipping.StatusDelivered
+	if shipped && status == StatusCancelled {
+		return Order{}, errors.Errorf("order %v cannot be cancelled as it has been %v; it can be refunded instead", orderID, order.Shipment.Status)
+	} else if !shipped {
+		if err := s.shipping.CancelShipment(ctx, order.Shipment.ID); err != nil {
+			return Order{}, errors.Wrapf(err, "unable to stop the shipment of order %v", orderID)
+		}
+		if err := s.catalogue.Release(ctx, orderID); err != nil {
+			return Order{}, errors.Wrapf(err, "unable to return the items of order %v to stock", orderID)
+		}
+	}
+
+	// Reverse the payment
+	if err := s.payments.Refund(ctx, order.AuthorisationID); err != nil {
+		return Order{}, errors.Wrapf(err, "unable to reverse the payment for order %v", orderID)
 	}
-	return orders, nil
+
+	// Record the outcome.  The order may have been shipped in the meantime, but it has
+	// been stopped or paid back either way.
+	order, _, err = s.updateStatus(ctx, orderID, time.Now().UnixNano(), func(Order) string { return status })
+	if err != nil {
+		return Order{}, err
+	}
+	order.Shipment, err = s.shipping.GetShipment(ctx, order.Shipment.ID)
+	return order, err
 }
 
 // NewOrder implements OrderService.
-func (s *orderImpl) NewOrder(ctx context.Context, customerID, addressID, cardID, cartID string) (Order, error) {
+func (s *orderImpl) NewOrder(ctx context.Context, customerID, addressID, cardID, cartID, coupon, idempotencyKey string) (Order, error) {
 	// All arguments must be provided
 	if customerID == "" {
 		return Order{}, errors.Errorf("missing customerID")
@@ -119,6 +276,21 @@ func (s *orderImpl) NewOrder(ctx context.Context, customerID, addressID, cardID,
 		return Order{}, errors.Errorf("missing cartID")
 	}
 
+	// An idempotent order's ID is derived from its key, so that a replayed request
+	// finds the original order
+	orderID := uuid.NewString()
+	if idempotencyKey != "" {
+		orderID = idempotentOrderID(customerID, idempotencyKey)
+		release, err := s.claim(ctx, orderID)
+		if err != nil {
+			return Order{}, err
+		}
+		defer release()
+		if order, replayed, err := s.replay(ctx, orderID, idempotencyKey); replayed {
+			return order, err
+		}
+	}
+
 	// Fetch data concurrently
 	var wg sync.WaitGroup
 	wg.Add(4)
@@ -161,55 +333,108 @@ func (s *orderImpl) NewOrder(ctx context.Context, customerID, addressID, cardID,
 	} else if len(cards) == 0 {
 		return Order{}, errors.Errorf("invalid card %v", cardID)
 	}
-
-	// Calculate total and authorize payment.
-	amount := calculateTotal(items)
-	auth, err := s.payments.Authorise(ctx, amount)
-	if err != nil {
+	if err := s.checkPrices(ctx, items); err != nil {
 		return Order{}, err
-	} else if !auth.Authorised {
-		return Order{}, errors.Errorf("payment not authorized due to %v", auth.Message)
-	}
-
-	// Submit the shipment
-	shipment := shipping.Shipment{
-		ID:     uuid.NewString(),
-		Name:   customerID,
-		Status: "awaiting shipment",
 	}
-	shipment, err = s.shipping.PostShipping(ctx, shipment)
+	price, err := s.pricer.Price(ctx, items, addresses[0], coupon)
 	if err != nil {
 		return Order{}, err
 	}
 
-	// Save the order
+	// Place the order as a saga, compensating its completed steps if any step fails
+	now := time.Now().UnixNano()
 	order := Order{
-		ID:         shipment.ID,
+		ID:             orderID,
+		CustomerID:     customerID,
+		Address:        addresses[0],
+		Card:           cards[0],
+		Items:          items,
+		Time:           now,
+		Price:          price,
+		Total:          price.Total,
+		IdempotencyKey: idempotencyKey,
+		Status:         StatusCreated,
+		History:        []StatusChange{{Status: StatusCreated, Time: now}},
+	}
+	saga := &orderSaga{
+		ID:         order.ID,
 		CustomerID: customerID,
-		Address:    addresses[0],
-		Card:       cards[0],
+		CartID:     cartID,
 		Items:      items,
-		Shipment:   shipment,
-		Date:       time.Now().String(),
-		Total:      amount,
+		Amount:     order.Total,
+		Status:     sagaStarted,
 	}
-	err = s.db.InsertOne(ctx, order)
+	order, err = s.runSaga(ctx, saga, order)
 	if err != nil {
+		if cerr := s.compensate(ctx, saga, err); cerr != nil {
+			slog.Error(fmt.Sprintf("Unable to compensate failed order %v: %v", saga.ID, cerr))
+		}
 		return Order{}, err
 	}
+	return order, nil
+}
 
-	// Delete the cart
-	return order, s.carts.DeleteCart(ctx, customerID)
+// The namespace of the IDs of idempotent orders
+var idempotentOrders = uuid.MustParse("9b1a3c3e-52f4-4a3e-9d0c-7f1d8e2b6a41")
+
+// Derives the ID of an order from the customer and the idempotency key it was placed with
+func idempotentOrderID(customerID, idempotencyKey string) string {
+	return uuid.NewSHA1(idempotentOrders, []byte(customerID+"/"+idempotencyKey)).String()
 }
 
-func calculateTotal(items []cart.Item) float32 {
-	amount := float32(0)
-	shipping := float32(4.99)
-	for _, item := range items {
-		amount += float32(item.Quantity) * item.UnitPrice
+// Claims an order ID for this instance to place, first waiting for any attempt by this
+// instance already placing the order to finish.  Returns a function that releases the
+// claim, or an error if ctx is cancelled while waiting.
+func (s *orderImpl) claim(ctx context.Context, orderID string) (release func(), err error) {
+	for {
+		s.lock.Lock()
+		placing, inProgress := s.placing[orderID]
+		if !inProgress {
+			placed := make(chan struct{})
+			s.placing[orderID] = placed
+			s.lock.Unlock()
+			return func() {
+				s.lock.Lock()
+				defer s.lock.Unlock()
+				delete(s.placing, orderID)
+				close(placed)
+			}, nil
+		}
+		s.lock.Unlock()
+
+		select {
+		case <-placing:
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(), "order %v is still being placed", orderID)
+		}
+	}
+}
+
+// Looks up the result of an earlier attempt to place an idempotent order.  Reports
+// whether there was an earlier attempt; if so, returns the order it placed, or the
+// reason it did not.
+func (s *orderImpl) replay(ctx context.Context, orderID, idempotencyKey string) (Order, bool, error) {
+	cursor, err := s.db.FindOne(ctx, bson.D{{"id", orderID}})
+	if err != nil {
+		return Order{}, true, err
+	}
+	var order Order
+	if placed, err := cursor.One(ctx, &order); err != nil || placed {
+		return order, true, err
+	}
+
+	// The order may have failed, or still be in progress on another instance
+	cursor, err = s.sagas.FindOne(ctx, bson.D{{"id", orderID}})
+	if err != nil {
+		return Order{}, true, err
+	}
+	var saga orderSaga
+	if attempted, err := cursor.One(ctx, &saga); err != nil || !attempted {
+		return Order{}, err != nil, err
+	} else if saga.Status == sagaCompensated {
+		return Order{}, true, errors.Errorf("order %v with idempotency key %v failed: %v", orderID, idempotencyKey, saga.Error)
 	}
-	amount += shipping
-	return amount
+	return Order{}, true, errors.Errorf("order %v with idempotency key %v is still being placed", orderID, idempotencyKey)
 }
 
 func any(errs ...error) error {
@@ -0,0 +1,237 @@
+package order
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/cart"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/user"
+	"github.com/pkg/errors"
+)
+
+// The pricing rules used by the order service if none are specified: a flat shipping
+// charge, with no tax and no coupons.
+const DefaultPricing = "shipping=4.99"
+
+// The breakdown of an order's price, in the currency of the order's items.  Amounts are
+// in the currency's minor units, so that they are exact.
+type Price struct {
+	Subtotal money.Money // The total of the order's items
+	Coupon   string      // The coupon applied to the order, if any
+	Discount money.Money // The amount taken off the subtotal by the coupon
+	Shipping money.Money // The shipping charge for the order's destination
+	Tax      money.Money // The tax on the discounted subtotal and shipping
+	Total    money.Money // The amount that the customer pays
+}
+
+// Prices orders.  The order service prices each order with a Pricer, so that pricing can be
+// experimented with by swapping the Pricer or its rules.
+type Pricer interface {
+	// Prices the items for delivery to address, applying coupon if it is not empty.  The
+	// order is priced in the currency of its items, which must all be in the same
+	// currency.  Returns an error if coupon is not a known coupon.
+	Price(ctx context.Context, items []cart.Item, address user.Address, coupon string) (Price, error)
+}
+
+// Returned when an order is placed from a cart whose prices differ from the catalogue's
+var ErrPricesChanged = errors.New("prices have changed")
+
+// Checks the prices of a cart's items against the catalogue.  Items are priced when
+// they are added to a cart, so the catalogue's prices may have changed since.  If
+// they have, an error wrapping [ErrPricesChanged] is returned, so that the customer
+// can review the new prices, for example by updating the cart's items through the
+// frontend, before placing the order again.
+//
+// The cart is not updated here, so that an order that is retried after being refused
+// is refused again.
+func (s *orderImpl) checkPrices(ctx context.Context, items []cart.Item) error {
+	var changed []string
+	for _, item := range items {
+		sock, err := s.catalogue.Get(ctx, item.ID, item.UnitPrice.Currency)
+		if err != nil {
+			return errors.Wrapf(err, "unable to check the price of item %v", item.ID)
+		} else if sock.Price != item.UnitPrice {
+			changed = append(changed, fmt.Sprintf("%v from %v to %v", item.ID, item.UnitPrice, sock.Price))
+		}
+	}
+	if len(changed) > 0 {
+		return errors.Wrapf(ErrPricesChanged, "cart is out of date (%v)", strings.Join(changed, ", "))
+	}
+	return nil
+}
+
+// Prices orders according to a set of rules
+type rulesPricer struct {
+	currency   string                 // The currency of the rules' amounts
+	rates      money.ExchangeRates    // Converts the rules' amounts into the currencies of orders
+	shipping   money.Money            // Shipping to countries without a rate of their own
+	shippingBy map[string]money.Money // Shipping by country
+	tax        int64                  // Tax in basis points for countries without a rate of their own
+	taxBy      map[string]int64       // Tax in basis points by country
+	percentOff map[string]int64       // Percentage coupons; discount in basis points by code
+	amountOff  map[string]money.Money // Fixed coupons; discount by code
+}
+
+// Returns a [Pricer] that prices orders according to rules.  rules is a semicolon-separated
+// list of rules of the form key=value:
+//
+//   - currency=EUR gives the rules' amounts in EUR; amounts are in [money.DefaultCurrency] otherwise
+//   - shipping=4.99 charges 4.99 to ship an order, unless overridden by country
+//   - shipping.<country>=9.99 charges 9.99 to ship an order to country
+//   - tax=10% taxes orders at 10%, unless overridden by country
+//   - tax.<country>=20% taxes orders shipped to country at 20%
+//   - coupon.<code>=15% takes 15% off the subtotal of orders using coupon code
+//   - coupon.<code>=5.00 takes 5.00 off the subtotal of orders using coupon code
+//
+// Amounts have at most the decimal places of their currency and percentages at most two.
+// Amounts are converted into the currency of each order using rates.  Countries and
+// coupon codes are case-insensitive.  For example,
+//
+//	shipping=4.99; shipping.UK=7.99; tax.UK=20%; coupon.WELCOME=10%
+func NewPricer(rules string, rates money.ExchangeRates) (Pricer, error) {
+	p := &rulesPricer{
+		currency:   money.DefaultCurrency,
+		rates:      rates,
+		shipping:   money.Money{Currency: money.DefaultCurrency},
+		shippingBy: make(map[string]money.Money),
+		taxBy:      make(map[string]int64),
+		percentOff: make(map[string]int64),
+		amountOff:  make(map[string]money.Money),
+	}
+
+	// The currency is found first, as the other rules' amounts depend on it
+	var parsed [][3]string
+	for _, rule := range strings.Split(rules, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		key, value, found := strings.Cut(rule, "=")
+		if !found {
+			return nil, errors.Errorf("invalid pricing rule %q; expected key=value", rule)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if key == "currency" {
+			if _, err := money.MinorUnits(value); err != nil {
+				return nil, errors.Wrapf(err, "invalid pricing rule %q", rule)
+			}
+			p.currency = value
+			p.shipping.Currency = value
+			continue
+		}
+		parsed = append(parsed, [3]string{rule, key, value})
+	}
+
+	for _, rule := range parsed {
+		kind, name, _ := strings.Cut(rule[1], ".")
+		name = strings.ToUpper(name)
+		value := rule[2]
+		var err error
+		switch {
+		case kind == "shipping" && name == "":
+			p.shipping, err = p.parseAmount(value)
+		case kind == "shipping":
+			p.shippingBy[name], err = p.parseAmount(value)
+		case kind == "tax" && name == "":
+			p.tax, err = parsePercent(value)
+		case kind == "tax":
+			p.taxBy[name], err = parsePercent(value)
+		case kind == "coupon" && name != "" && strings.HasSuffix(value, "%"):
+			p.percentOff[name], err = parsePercent(value)
+		case kind == "coupon" && name != "":
+			p.amountOff[name], err = p.parseAmount(value)
+		default:
+			err = errors.Errorf("unknown rule")
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid pricing rule %q", rule[0])
+		}
+	}
+	return p, nil
+}
+
+// Price implements Pricer.
+func (p *rulesPricer) Price(ctx context.Context, items []cart.Item, address user.Address, coupon string) (Price, error) {
+	currency := p.currency
+	if len(items) > 0 {
+		currency = items[0].UnitPrice.Currency
+	}
+	var subtotal, discount, shipping int64
+	for _, item := range items {
+		if item.UnitPrice.Currency != currency {
+			return Price{}, errors.Wrapf(money.ErrCurrencyMismatch, "items are priced in both %v and %v", currency, item.UnitPrice.Currency)
+		}
+		subtotal += item.UnitPrice.Times(item.Quantity).Amount
+	}
+
+	if coupon != "" {
+		code := strings.ToUpper(coupon)
+		if percent, exists := p.percentOff[code]; exists {
+			discount = percentOf(subtotal, percent)
+		} else if amount, exists := p.amountOff[code]; exists {
+			converted, err := p.rates.Convert(ctx, amount, currency)
+			if err != nil {
+				return Price{}, errors.Wrapf(err, "unable to price coupon %v", coupon)
+			}
+			discount = min(converted.Amount, subtotal)
+		} else {
+			return Price{}, errors.Errorf("unknown coupon %v", coupon)
+		}
+	}
+
+	country := strings.ToUpper(address.Country)
+	charge := p.shipping
+	if byCountry, exists := p.shippingBy[country]; exists {
+		charge = byCountry
+	}
+	converted, err := p.rates.Convert(ctx, charge, currency)
+	if err != nil {
+		return Price{}, errors.Wrap(err, "unable to price shipping")
+	}
+	shipping = converted.Amount
+	tax := p.tax
+	if rate, exists := p.taxBy[country]; exists {
+		tax = rate
+	}
+	taxed := percentOf(subtotal-discount+shipping, tax)
+
+	amount := func(minor int64) money.Money { return money.Money{Amount: minor, Currency: currency} }
+	return Price{
+		Subtotal: amount(subtotal),
+		Coupon:   coupon,
+		Discount: amount(discount),
+		Shipping: amount(shipping),
+		Tax:      amount(taxed),
+		Total:    amount(subtotal - discount + shipping + taxed),
+	}, nil
+}
+
+// Returns basisPoints hundredths of a percent of an amount in minor units, rounded half up
+// to the nearest minor unit
+func percentOf(amount, basisPoints int64) int64 {
+	return (amount*basisPoints + 5000) / 10000
+}
+
+// Parses a non-negative decimal amount in the rules' currency, such as 4.99
+func (p *rulesPricer) parseAmount(value string) (money.Money, error) {
+	amount, err := money.ParseAmount(value, p.currency)
+	if err == nil && amount.Amount < 0 {
+		return money.Money{}, errors.Errorf("%v is negative", value)
+	}
+	return amount, err
+}
+
+// Parses a non-negative percentage with at most two decimal places, such as 7.25%, into basis points
+func parsePercent(value string) (int64, error) {
+	percent, found := strings.CutSuffix(value, "%")
+	if !found {
+		return 0, errors.Errorf("%v is not a percentage", value)
+	}
+	basisPoints, err := money.ParseDecimal(percent, 2)
+	if err == nil && basisPoints < 0 {
+		return 0, errors.Errorf("%v is negative", value)
+	}
+	return basisPoints, err
+}
@@ -0,0 +1,198 @@
+package order
+
+import (
+	"context"
+	"testing"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/cart"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/user"
+	"github.com/stretchr/testify/require"
+)
+
+// Unit tests of order pricing that don't use gotests plugin
+
+// Returns the breakdown of a price in USD, given in cents
+func usdPrice(subtotal int64, coupon string, discount, shipping, tax, total int64) Price {
+	return Price{Subtotal: usd(subtotal), Coupon: coupon, Discount: usd(discount), Shipping: usd(shipping), Tax: usd(tax), Total: usd(total)}
+}
+
+func newTestPricer(t *testing.T, rules string) Pricer {
+	rates, err := money.NewFixedRates(context.Background(), money.DefaultExchangeRates)
+	require.NoError(t, err)
+	pricer, err := NewPricer(rules, rates)
+	require.NoError(t, err)
+	return pricer
+}
+
+func TestDefaultPricing(t *testing.T) {
+	ctx := context.Background()
+	pricer := newTestPricer(t, DefaultPricing)
+
+	// Ten items at 0.10 come to exactly 1.00
+	price, err := pricer.Price(ctx, []cart.Item{{ID: "a", Quantity: 10, UnitPrice: usd(10)}, {ID: "b", Quantity: 1, UnitPrice: usd(1299)}}, user.Address{Country: "France"}, "")
+	require.NoError(t, err)
+	require.Equal(t, usdPrice(1399, "", 0, 499, 0, 1898), price)
+	require.Equal(t, "18.98 USD", price.Total.String())
+}
+
+func TestPricingRules(t *testing.T) {
+	ctx := context.Background()
+	pricer := newTestPricer(t, "shipping=4.99; shipping.uk=7.50; tax=10%; tax.UK=20%; tax.US=7.25%; coupon.WELCOME=15%; coupon.fiveoff=5")
+	items := []cart.Item{{ID: "sock", Quantity: 3, UnitPrice: usd(333)}}
+
+	// Shipping and tax by country
+	price, err := pricer.Price(ctx, items, user.Address{Country: "UK"}, "")
+	require.NoError(t, err)
+	require.Equal(t, usdPrice(999, "", 0, 750, 350, 2099), price)
+
+	// 7.25% of 14.98 is 1.08605, which rounds to 1.09
+	price, err = pricer.Price(ctx, items, user.Address{Country: "us"}, "")
+	require.NoError(t, err)
+	require.Equal(t, usdPrice(999, "", 0, 499, 109, 1607), price)
+
+	// Default shipping and tax
+	price, err = pricer.Price(ctx, items, user.Address{Country: "Canada"}, "")
+	require.NoError(t, err)
+	require.Equal(t, usdPrice(999, "", 0, 499, 150, 1648), price)
+
+	// Coupons are taken off the subtotal before tax
+	price, err = pricer.Price(ctx, items, user.Address{Country: "UK"}, "welcome")
+	require.NoError(t, err)
+	require.Equal(t, usdPrice(999, "welcome", 150, 750, 320, 1919), price)
+
+	price, err = pricer.Price(ctx, items, user.Address{Country: "UK"}, "FIVEOFF")
+	require.NoError(t, err)
+	require.Equal(t, usdPrice(999, "FIVEOFF", 500, 750, 250, 1499), price)
+
+	// A fixed discount doesn't exceed the subtotal
+	price, err = pricer.Price(ctx, []cart.Item{{ID: "sock", Quantity: 1, UnitPrice: usd(200)}}, user.Address{Country: "UK"}, "FIVEOFF")
+	require.NoError(t, err)
+	require.Equal(t, usdPrice(200, "FIVEOFF", 200, 750, 150, 900), price)
+
+	_, err = pricer.Price(ctx, items, user.Address{Country: "UK"}, "FREESOCKS")
+	require.Error(t, err)
+}
+
+func TestInvalidPricingRules(t *testing.T) {
+	for _, rules := range []string{
+		"shipping",
+		"shipping=4.999",
+		"shipping=-1",
+		"shipping=free",
+		"tax=20",
+		"tax=.5%",
+		"tax=-5%",
+		"coupon=10%",
+		"discount.UK=10%",
+		"currency=XXX",
+		"currency=JPY; shipping=4.99",
+	} {
+		_, err := NewPricer(rules, nil)
+		require.Error(t, err, rules)
+	}
+}
+
+func TestPricingCurrencies(t *testing.T) {
+	ctx := context.Background()
+	pricer := newTestPricer(t, "shipping=4.99; coupon.FIVEOFF=5; tax=10%")
+	eur := func(cents int64) money.Money { return money.Money{Amount: cents, Currency: "EUR"} }
+
+	// Orders are priced in their items' currency, with the rules' amounts converted into it
+	price, err := pricer.Price(ctx, []cart.Item{{ID: "sock", Quantity: 2, UnitPrice: eur(1000)}}, user.Address{}, "FIVEOFF")
+	require.NoError(t, err)
+	require.Equal(t, Price{Subtotal: eur(2000), Coupon: "FIVEOFF", Discount: eur(460), Shipping: eur(459), Tax: eur(200), Total: eur(2199)}, price)
+
+	// Yen have no minor units
+	price, err = pricer.Price(ctx, []cart.Item{{ID: "sock", Quantity: 1, UnitPrice: money.Money{Amount: 1500, Currency: "JPY"}}}, user.Address{}, "")
+	require.NoError(t, err)
+	require.Equal(t, money.Money{Amount: 746, Currency: "JPY"}, price.Shipping)
+	require.Equal(t, money.Money{Amount: 2471, Currency: "JPY"}, price.Total)
+
+	// The rules' amounts can be in another currency
+	pricer = newTestPricer(t, "currency=JPY; shipping=500")
+	price, err = pricer.Price(ctx, []cart.Item{{ID: "sock", Quantity: 1, UnitPrice: usd(1000)}}, user.Address{}, "")
+	require.NoError(t, err)
+	require.Equal(t, usd(334), price.Shipping)
+
+	// Items in different currencies cannot be priced together
+	_, err = pricer.Price(ctx, []cart.Item{{ID: "a", Quantity: 1, UnitPrice: usd(1000)}, {ID: "b", Quantity: 1, UnitPrice: eur(1000)}}, user.Address{}, "")
+	require.ErrorIs(t, err, money.ErrCurrencyMismatch)
+
+	// Nor can items in a currency without an exchange rate
+	_, err = pricer.Price(ctx, []cart.Item{{ID: "a", Quantity: 1, UnitPrice: money.Money{Amount: 1000, Currency: "SEK"}}}, user.Address{}, "")
+	require.ErrorIs(t, err, money.ErrUnknownCurrency)
+}
+
+func TestOrderPriced(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+	test.orderImpl.pricer = newTestPricer(t, "shipping=2.50; tax=10%; coupon.HALF=50%")
+
+	_, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "QUARTER", "")
+	require.Error(t, err)
+
+	// Two socks at 10.00, half off, plus shipping and tax
+	order, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "HALF", "")
+	require.NoError(t, err)
+	require.Equal(t, usdPrice(2000, "HALF", 1000, 250, 125, 1375), order.Price)
+	require.Equal(t, usd(1375), order.Total)
+	require.Equal(t, order.Total, test.saga(t, order.ID).Amount)
+}
+
+func TestOrderRepriced(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+
+	// The sock's price went up after it was added to the cart, so the order is refused,
+	// even if it is retried
+	test.catalogue.prices[sock.ID] = usd(1250)
+	for i := 0; i < 2; i++ {
+		_, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "")
+		require.ErrorIs(t, err, ErrPricesChanged)
+		require.ErrorContains(t, err, "sock from 10.00 USD to 12.50 USD")
+	}
+
+	// Once the cart has the new price, the order can be placed
+	require.NoError(t, test.carts.UpdateItem(ctx, "jon", cart.Item{ID: sock.ID, Quantity: sock.Quantity, UnitPrice: usd(1250)}))
+	order, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "")
+	require.NoError(t, err)
+	require.Equal(t, usd(2500), order.Price.Subtotal)
+}
+
+func TestOrderInCurrency(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+
+	// The cart's socks are priced in euros, so the order is priced and paid for in euros
+	eur := money.Money{Amount: 920, Currency: "EUR"}
+	require.NoError(t, test.carts.UpdateItem(ctx, "jon", cart.Item{ID: sock.ID, Quantity: sock.Quantity, UnitPrice: eur}))
+	order, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "")
+	require.NoError(t, err)
+	require.Equal(t, money.Money{Amount: 2299, Currency: "EUR"}, order.Total)
+	payment, err := test.payments.GetPayment(ctx, order.AuthorisationID)
+	require.NoError(t, err)
+	require.Equal(t, order.Total, payment.Amount)
+
+	// A cart with socks in more than one currency cannot be ordered
+	test.catalogue.prices["other"], test.catalogue.stock["other"] = usd(500), 5
+	for _, item := range []cart.Item{{ID: sock.ID, Quantity: 1, UnitPrice: eur}, {ID: "other", Quantity: 1, UnitPrice: usd(500)}} {
+		_, err = test.carts.AddItem(ctx, "jon", item)
+		require.NoError(t, err)
+	}
+	_, err = test.NewOrder(ctx, "jon", "address", "card", "jon", "", "")
+	require.ErrorIs(t, err, money.ErrCurrencyMismatch)
+}
+
+func TestOrderItemNotInCatalogue(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+
+	delete(test.catalogue.prices, sock.ID)
+	_, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "")
+	require.Error(t, err)
+
+	items, err := test.carts.GetCart(ctx, "jon")
+	require.NoError(t, err)
+	require.Equal(t, []cart.Item{sock}, items)
+}
@@ -0,0 +1,170 @@
+package order
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// A customer's orders are listed a page at a time, sorted by the time they were placed.
+// Each page ends with a cursor that resumes the listing after the page's last order, so
+// that pages stay consistent while new orders are placed.
+
+// The orders in which a customer's orders can be sorted
+const (
+	SortNewestFirst = "newest" // Most recently placed first; the default
+	SortOldestFirst = "oldest" // Least recently placed first
+)
+
+// The number of orders in a page if a query does not specify a limit, and the most orders
+// that a page can hold
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// Selects a page of a customer's orders.  The zero value selects the first page of the
+// customer's most recent orders.
+type OrderQuery struct {
+	Statuses []string // Only orders in one of these statuses; any status if empty
+	From     int64    // Only orders placed at or after this Unix time in nanoseconds, if not 0
+	To       int64    // Only orders placed before this Unix time in nanoseconds, if not 0
+	Sort     string   // SortNewestFirst or SortOldestFirst; SortNewestFirst if empty
+	Limit    int      // The most orders to return; DefaultPageSize if 0
+	Cursor   string   // The cursor of the previous page, to continue from; empty for the first page
+}
+
+// A page of a customer's orders
+type OrderPage struct {
+	Orders []Order
+	Cursor string // Continues from the end of the page; empty if this is the last page
+}
+
+// The position of an order within a sorted listing
+type pageCursor struct {
+	Time int64
+	ID   string
+}
+
+func (c pageCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d/%s", c.Time, c.ID)))
+}
+
+func parseCursor(cursor string) (pageCursor, error) {
+	var c pageCursor
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		_, err = fmt.Sscanf(string(decoded), "%d/%s", &c.Time, &c.ID)
+	}
+	if err != nil {
+		return c, errors.Errorf("invalid cursor %v", cursor)
+	}
+	return c, nil
+}
+
+// Reports whether the order at c comes before the order at b in a listing sorted newest or
+// oldest first.  Orders placed at the same time are ordered by ID.
+func (c pageCursor) before(b pageCursor, newestFirst bool) bool {
+	if c.Time != b.Time {
+		return (c.Time > b.Time) == newestFirst
+	}
+	return c.ID < b.ID
+}
+
+// GetOrders implements OrderService.
+func (s *orderImpl) GetOrders(ctx context.Context, customerID string, query OrderQuery) (OrderPage, error) {
+	newestFirst := query.Sort == "" || query.Sort == SortNewestFirst
+	if !newestFirst && query.Sort != SortOldestFirst {
+		return OrderPage{}, errors.Errorf("invalid sort %v; expected %v or %v", query.Sort, SortNewestFirst, SortOldestFirst)
+	}
+	limit := query.Limit
+	if limit == 0 {
+		limit = DefaultPageSize
+	} else if limit < 0 || limit > MaxPageSize {
+		return OrderPage{}, errors.Errorf("invalid limit %v; expected at most %v", limit, MaxPageSize)
+	}
+
+	// The database selects the orders within the query's bounds and after the cursor
+	filter := bson.D{{"customerid", customerID}}
+	if len(query.Statuses) > 0 {
+		filter = append(filter, bson.E{"status", bson.D{{"$in", query.Statuses}}})
+	}
+	from := query.From
+	var bounds bson.D
+	var after *pageCursor
+	if query.Cursor != "" {
+		cursor, err := parseCursor(query.Cursor)
+		if err != nil {
+			return OrderPage{}, err
+		} else if newestFirst {
+			bounds = append(bounds, bson.E{"$lte", cursor.Time})
+		} else if cursor.Time > from {
+			from = cursor.Time
+		}
+		after = &cursor
+	}
+	if from != 0 {
+		bounds = append(bounds, bson.E{"$gte", from})
+	}
+	if query.To != 0 {
+		bounds = append(bounds, bson.E{"$lt", query.To})
+	}
+	if len(bounds) > 0 {
+		filter = append(filter, bson.E{"time", bounds})
+	}
+	// The database can neither sort nor limit the orders it finds, so only the orders'
+	// positions are read to choose the page, and then only the page's orders are read
+	// in full
+	cursor, err := s.db.FindMany(ctx, filter, bson.D{{"time", 1}, {"id", 1}})
+	if err != nil {
+		return OrderPage{}, err
+	}
+	var positions []pageCursor
+	if err := cursor.All(ctx, &positions); err != nil {
+		return OrderPage{}, err
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		return positions[i].before(positions[j], newestFirst)
+	})
+	if after != nil {
+		start := sort.Search(len(positions), func(i int) bool { return after.before(positions[i], newestFirst) })
+		positions = positions[start:]
+	}
+
+	var page OrderPage
+	if len(positions) > limit {
+		positions = positions[:limit]
+		page.Cursor = positions[limit-1].String()
+	}
+	if len(positions) == 0 {
+		return page, nil
+	}
+	ids := make([]string, len(positions))
+	for i, position := range positions {
+		ids[i] = position.ID
+	}
+	cursor, err = s.db.FindMany(ctx, bson.D{{"id", bson.D{{"$in", ids}}}})
+	if err != nil {
+		return OrderPage{}, err
+	}
+	if err := cursor.All(ctx, &page.Orders); err != nil {
+		return OrderPage{}, err
+	}
+	position := func(order Order) pageCursor { return pageCursor{Time: order.Time, ID: order.ID} }
+	sort.Slice(page.Orders, func(i, j int) bool {
+		return position(page.Orders[i]).before(position(page.Orders[j]), newestFirst)
+	})
+
+	// The stored shipments are snapshots from when the orders were placed
+	for i := range page.Orders {
+		page.Orders[i].Shipment, err = s.shipping.GetShipment(ctx, page.Orders[i].Shipment.ID)
+		if err != nil {
+			return OrderPage{}, err
+		}
+	}
+	return page, nil
+}
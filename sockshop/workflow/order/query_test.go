@@ -0,0 +1,155 @@
+package order
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/shipping"
+	"github.com/stretchr/testify/require"
+)
+
+// Unit tests of listing orders that don't use gotests plugin
+
+// Inserts an order along with its shipment, which has the order's ID
+func (test *sagaTest) insertOrder(t *testing.T, order Order) {
+	ctx := context.Background()
+	shipment, err := test.shipping.PostShipping(ctx, shipping.Shipment{ID: order.ID, Name: order.CustomerID, Status: "awaiting shipment"})
+	require.NoError(t, err)
+	order.Shipment = shipment
+	require.NoError(t, test.orders.InsertOne(ctx, order))
+}
+
+// Inserts orders for jon placed at times 1 to n; every third order is cancelled
+func (test *sagaTest) insertOrders(t *testing.T, n int) {
+	for i := 1; i <= n; i++ {
+		order := Order{ID: fmt.Sprintf("order%02d", i), CustomerID: "jon", Time: int64(i), Status: StatusPaid}
+		if i%3 == 0 {
+			order.Status = StatusCancelled
+		}
+		test.insertOrder(t, order)
+	}
+	test.insertOrder(t, Order{ID: "other", CustomerID: "ana", Time: 1})
+}
+
+func times(page OrderPage) []int64 {
+	var times []int64
+	for _, order := range page.Orders {
+		times = append(times, order.Time)
+	}
+	return times
+}
+
+func TestGetOrdersSorted(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+	test.insertOrders(t, 5)
+
+	page, err := test.GetOrders(ctx, "jon", OrderQuery{})
+	require.NoError(t, err)
+	require.Equal(t, []int64{5, 4, 3, 2, 1}, times(page))
+	require.Empty(t, page.Cursor)
+
+	page, err = test.GetOrders(ctx, "jon", OrderQuery{Sort: SortOldestFirst})
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 2, 3, 4, 5}, times(page))
+
+	_, err = test.GetOrders(ctx, "jon", OrderQuery{Sort: "cheapest"})
+	require.Error(t, err)
+}
+
+func TestGetOrdersPaginated(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+	test.insertOrders(t, 7)
+
+	for _, sort := range []string{SortNewestFirst, SortOldestFirst} {
+		query := OrderQuery{Sort: sort, Limit: 3}
+		var pages [][]int64
+		for {
+			page, err := test.GetOrders(ctx, "jon", query)
+			require.NoError(t, err)
+			pages = append(pages, times(page))
+			if page.Cursor == "" {
+				break
+			}
+			query.Cursor = page.Cursor
+		}
+		if sort == SortNewestFirst {
+			require.Equal(t, [][]int64{{7, 6, 5}, {4, 3, 2}, {1}}, pages)
+		} else {
+			require.Equal(t, [][]int64{{1, 2, 3}, {4, 5, 6}, {7}}, pages)
+		}
+	}
+
+	// Orders placed after a page was listed don't shift the following pages
+	page, err := test.GetOrders(ctx, "jon", OrderQuery{Limit: 3})
+	require.NoError(t, err)
+	test.insertOrder(t, Order{ID: "order08", CustomerID: "jon", Time: 8, Status: StatusPaid})
+	page, err = test.GetOrders(ctx, "jon", OrderQuery{Limit: 3, Cursor: page.Cursor})
+	require.NoError(t, err)
+	require.Equal(t, []int64{4, 3, 2}, times(page))
+
+	_, err = test.GetOrders(ctx, "jon", OrderQuery{Cursor: "not a cursor"})
+	require.Error(t, err)
+	_, err = test.GetOrders(ctx, "jon", OrderQuery{Limit: MaxPageSize + 1})
+	require.Error(t, err)
+}
+
+func TestGetOrdersPaginatedSameTime(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+	for _, id := range []string{"c", "a", "b"} {
+		test.insertOrder(t, Order{ID: id, CustomerID: "jon", Time: 1})
+	}
+
+	var ids []string
+	query := OrderQuery{Limit: 1}
+	for {
+		page, err := test.GetOrders(ctx, "jon", query)
+		require.NoError(t, err)
+		require.Len(t, page.Orders, 1)
+		ids = append(ids, page.Orders[0].ID)
+		if page.Cursor == "" {
+			break
+		}
+		query.Cursor = page.Cursor
+	}
+	require.Equal(t, []string{"a", "b", "c"}, ids)
+}
+
+func TestGetOrdersFiltered(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+	test.insertOrders(t, 7)
+
+	page, err := test.GetOrders(ctx, "jon", OrderQuery{Statuses: []string{StatusCancelled}})
+	require.NoError(t, err)
+	require.Equal(t, []int64{6, 3}, times(page))
+
+	page, err = test.GetOrders(ctx, "jon", OrderQuery{From: 2, To: 5})
+	require.NoError(t, err)
+	require.Equal(t, []int64{4, 3, 2}, times(page))
+
+	page, err = test.GetOrders(ctx, "jon", OrderQuery{Statuses: []string{StatusPaid}, From: 2, Sort: SortOldestFirst, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []int64{2, 4}, times(page))
+	page, err = test.GetOrders(ctx, "jon", OrderQuery{Statuses: []string{StatusPaid}, From: 2, Sort: SortOldestFirst, Limit: 2, Cursor: page.Cursor})
+	require.NoError(t, err)
+	require.Equal(t, []int64{5, 7}, times(page))
+	require.Empty(t, page.Cursor)
+}
+
+func TestGetOrdersRefreshesShipments(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+	test.insertOrders(t, 2)
+
+	// The listed orders' shipments are current, even before their statuses follow them
+	require.NoError(t, test.shipping.UpdateStatus(ctx, "order02", shipping.StatusShipped))
+	page, err := test.GetOrders(ctx, "jon", OrderQuery{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"order02", "order01"}, []string{page.Orders[0].ID, page.Orders[1].ID})
+	require.Equal(t, shipping.StatusShipped, page.Orders[0].Shipment.Status)
+	require.Equal(t, "awaiting shipment", page.Orders[1].Shipment.Status)
+}
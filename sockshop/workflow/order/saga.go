@@ -0,0 +1,267 @@
+package order
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/cart"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/catalogue"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/shipping"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/exp/slog"
+)
+
+// Placing an order is a saga: a sequence of steps against other services, each of which
+// has a compensating action that undoes it.  Each step is recorded in a saga log in the
+// order DB before the next begins.  If a step fails, the completed steps are compensated
+// in reverse order; if the order service crashes part-way, the saga is recovered from the
+// log when the service next starts.
+//
+// Inserting the order is the saga's final step; once the order exists, the saga is
+// complete and is never compensated.
+
+// The steps of the order saga, in order.
+const (
+	stepReserve    = "reserve"    // Compensated by releasing the reserved stock
+	stepAuthorise  = "authorise"  // Compensated by voiding the authorisation
+	stepShip       = "ship"       // Compensated by cancelling the shipment
+	stepDeleteCart = "deletecart" // Compensated by restoring the cart's items
+	stepCapture    = "capture"    // Compensated by refunding the payment
+)
+
+// The status of a saga
+const (
+	sagaStarted     = "started"
+	sagaCompleted   = "completed"
+	sagaCompensated = "compensated"
+)
+
+// The saga log entry for placing one order
+type orderSaga struct {
+	ID              string // The ID of the order being placed
+	CustomerID      string
+	CartID          string
+	Items           []cart.Item // The cart's items, to restore the cart
+	Amount          money.Money
+	AuthorisationID string
+	ShipmentID      string
+	Steps           []string // The completed steps, in order; removed as they are compensated
+	Status          string
+	Error           string // Why the saga was compensated
+	Updated         int64  // Unix time in nanoseconds of the last update
+}
+
+// Records the saga's current state in the saga log
+func (s *orderImpl) logSaga(ctx context.Context, saga *orderSaga) error {
+	saga.Updated = time.Now().UnixNano()
+	_, err := s.sagas.Upsert(ctx, bson.D{{"id", saga.ID}}, saga)
+	return errors.Wrapf(err, "unable to update saga log for order %v", saga.ID)
+}
+
+// Records that a step of the saga completed
+func (s *orderImpl) completeStep(ctx context.Context, saga *orderSaga, step string) error {
+	saga.Steps = append(saga.Steps, step)
+	return s.logSaga(ctx, saga)
+}
+
+// Compensates the completed steps of the saga in reverse order, recording each in the
+// saga log, then marks the saga compensated.  cause is the reason for compensating.
+//
+// Compensation continues after ctx is cancelled, so that a caller giving up does not
+// leave the saga half-compensated.
+func (s *orderImpl) compensate(ctx context.Context, saga *orderSaga, cause error) error {
+	ctx = context.WithoutCancel(ctx)
+	saga.Error = cause.Error()
+	for len(saga.Steps) > 0 {
+		step := saga.Steps[len(saga.Steps)-1]
+		var err error
+		switch step {
+		case stepReserve:
+			err = s.catalogue.Release(ctx, saga.ID)
+		case stepAuthorise:
+			err = s.payments.Void(ctx, saga.AuthorisationID)
+		case stepShip:
+			err = s.shipping.CancelShipment(ctx, saga.ShipmentID)
+		case stepDeleteCart:
+			err = s.restoreCart(ctx, saga.CartID, saga.Items)
+		case stepCapture:
+			err = s.payments.Refund(ctx, saga.AuthorisationID)
+		default:
+			err = errors.Errorf("unknown step %v", step)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "unable to compensate step %v of order %v", step, saga.ID)
+		}
+		saga.Steps = saga.Steps[:len(saga.Steps)-1]
+		if err := s.logSaga(ctx, saga); err != nil {
+			return err
+		}
+	}
+	saga.Status = sagaCompensated
+	return s.logSaga(ctx, saga)
+}
+
+// Adds items back to a cart whose items were deleted
+func (s *orderImpl) restoreCart(ctx context.Context, cartID string, items []cart.Item) error {
+	for _, item := range items {
+		if _, err := s.carts.AddItem(ctx, cartID, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Recovers the sagas left incomplete when the service started, retrying every second
+// until all are recovered or ctx is cancelled
+func (s *orderImpl) runRecovery(ctx context.Context, started int64) {
+	for {
+		err := s.recoverSagas(ctx, started)
+		if err == nil {
+			return
+		}
+		slog.Error(fmt.Sprintf("Unable to recover incomplete orders; retrying in 1 second: %v", err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+// Recovers sagas left incomplete by a crash, i.e. sagas still in progress that were last
+// updated before the service started.  A saga whose order was inserted is completed; any
+// other is compensated.  Returns the last error encountered; sagas that could not be
+// recovered remain in progress.
+func (s *orderImpl) recoverSagas(ctx context.Context, started int64) error {
+	cursor, err := s.sagas.FindMany(ctx, bson.D{{"status", sagaStarted}})
+	if err != nil {
+		return err
+	}
+	var sagas []orderSaga
+	if err := cursor.All(ctx, &sagas); err != nil {
+		return err
+	}
+
+	var lastErr error
+	for i := range sagas {
+		if sagas[i].Updated >= started {
+			continue
+		}
+		if err := s.recoverSaga(ctx, &sagas[i]); err != nil {
+			lastErr = errors.Wrapf(err, "unable to recover order %v", sagas[i].ID)
+		}
+	}
+	return lastErr
+}
+
+func (s *orderImpl) recoverSaga(ctx context.Context, saga *orderSaga) error {
+	cursor, err := s.db.FindOne(ctx, bson.D{{"id", saga.ID}})
+	if err != nil {
+		return err
+	}
+	var order Order
+	inserted, err := cursor.One(ctx, &order)
+	if err != nil {
+		return err
+	} else if inserted {
+		saga.Status = sagaCompleted
+		return s.logSaga(ctx, saga)
+	}
+	slog.Warn(fmt.Sprintf("Compensating order %v left incomplete with steps %v", saga.ID, saga.Steps))
+	return s.compensate(ctx, saga, errors.Errorf("order service restarted while placing order"))
+}
+
+// Runs the steps of the saga, recording each in the saga log, and finally inserts the
+// order.  Does not compensate on failure; the caller does.
+func (s *orderImpl) runSaga(ctx context.Context, saga *orderSaga, order Order) (Order, error) {
+	if err := s.logSaga(ctx, saga); err != nil {
+		return Order{}, err
+	}
+
+	// Reserve the items' stock under the order's ID.  Like the shipment, the reservation
+	// is logged before it is made, so that stock reserved just before a crash is released.
+	if err := s.completeStep(ctx, saga, stepReserve); err != nil {
+		return Order{}, err
+	}
+	reserved := make([]catalogue.ReservedItem, 0, len(saga.Items))
+	for _, item := range saga.Items {
+		reserved = append(reserved, catalogue.ReservedItem{SockID: item.ID, Quantity: item.Quantity})
+	}
+	if err := s.catalogue.Reserve(ctx, saga.ID, reserved); err != nil {
+		return Order{}, err
+	}
+
+	// Authorise payment from the order's card
+	auth, err := s.payments.Authorise(ctx, order.CustomerID, order.Address, order.Card, saga.Amount)
+	if err != nil {
+		return Order{}, err
+	} else if !auth.Authorised {
+		return Order{}, errors.Errorf("payment not authorized due to %v", auth.Message)
+	}
+	saga.AuthorisationID = auth.ID
+	order.AuthorisationID = auth.ID
+	if err := s.completeStep(ctx, saga, stepAuthorise); err != nil {
+		return Order{}, err
+	}
+	if err := order.transition(StatusPaid, time.Now().UnixNano()); err != nil {
+		return Order{}, err
+	}
+
+	// Submit the shipment.  The shipment is logged before it is posted, so that a
+	// shipment posted just before a crash is still cancelled.
+	saga.ShipmentID = order.ID
+	if err := s.completeStep(ctx, saga, stepShip); err != nil {
+		return Order{}, err
+	}
+	order.Shipment, err = s.shipping.PostShipping(ctx, shipping.Shipment{
+		ID:     saga.ShipmentID,
+		Name:   saga.CustomerID,
+		Status: shipping.StatusAwaiting,
+	})
+	if err != nil {
+		return Order{}, err
+	}
+
+	// Delete the cart.  Unlike the shipment, the cart is logged after it is deleted,
+	// because restoring a cart that was not deleted would duplicate its items.
+	if err := s.carts.DeleteCart(ctx, saga.CartID); err != nil {
+		return Order{}, err
+	}
+	if err := s.completeStep(ctx, saga, stepDeleteCart); err != nil {
+		return Order{}, err
+	}
+
+	// Take the reserved items out of stock.  Releasing a committed reservation returns
+	// its stock, so committing needs no compensation of its own.
+	if err := s.catalogue.Commit(ctx, saga.ID); err != nil {
+		return Order{}, err
+	}
+
+	// Take the payment.  Refunding a payment that was not captured voids it, so like the
+	// shipment, the capture is logged before it is made.
+	if err := s.completeStep(ctx, saga, stepCapture); err != nil {
+		return Order{}, err
+	}
+	if err := s.payments.Capture(ctx, saga.AuthorisationID); err != nil {
+		return Order{}, err
+	}
+
+	// Save the order, completing the saga
+	if err := s.db.InsertOne(ctx, order); err != nil {
+		return Order{}, err
+	}
+	saga.Status = sagaCompleted
+	if err := s.logSaga(ctx, saga); err != nil {
+		slog.Error(fmt.Sprintf("Order %v was placed but its saga log could not be updated: %v", order.ID, err))
+	}
+
+	// Status events for the shipment are dropped until the order exists, so apply any
+	// that were missed
+	if err := s.syncShipmentStatus(ctx, order.ID); err != nil {
+		slog.Error(fmt.Sprintf("Unable to update the status of order %v from its shipment: %v", order.ID, err))
+	}
+	return order, nil
+}
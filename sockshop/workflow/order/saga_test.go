@@ -0,0 +1,568 @@
+package order
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/cart"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/catalogue"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/payment"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/shipping"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/user"
+	"github.com/blueprint-uservices/blueprint/runtime/core/backend"
+	"github.com/blueprint-uservices/blueprint/runtime/plugins/golang"
+	"github.com/blueprint-uservices/blueprint/runtime/plugins/simplenosqldb"
+	"github.com/blueprint-uservices/blueprint/runtime/plugins/simplequeue"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Unit tests of the order saga that don't use gotests plugin
+
+// A user service that knows a single customer
+type testUsers struct {
+	user.UserService
+}
+
+func (testUsers) GetUsers(ctx context.Context, id string) ([]user.User, error) {
+	return []user.User{{UserID: id}}, nil
+}
+
+func (testUsers) GetAddresses(ctx context.Context, id string) ([]user.Address, error) {
+	return []user.Address{{ID: id}}, nil
+}
+
+func (testUsers) GetCards(ctx context.Context, id string) ([]user.Card, error) {
+	card := testCard
+	card.ID = id
+	return []user.Card{card}, nil
+}
+
+var testCard = user.Card{LongNum: "4242424242424242", Expires: "1239"}
+
+// A catalogue of socks with the given prices and stock
+type testCatalogue struct {
+	catalogue.CatalogueService
+	rates     money.ExchangeRates
+	prices    map[string]money.Money
+	stock     map[string]int
+	reserved  map[string][]catalogue.ReservedItem // Uncommitted reservations by ID
+	committed map[string][]catalogue.ReservedItem // Committed reservations by ID
+}
+
+func newTestCatalogue(rates money.ExchangeRates) *testCatalogue {
+	return &testCatalogue{
+		rates:     rates,
+		prices:    map[string]money.Money{sock.ID: sock.UnitPrice},
+		stock:     map[string]int{sock.ID: 5},
+		reserved:  make(map[string][]catalogue.ReservedItem),
+		committed: make(map[string][]catalogue.ReservedItem),
+	}
+}
+
+func (c *testCatalogue) Get(ctx context.Context, id string, currency string) (catalogue.Sock, error) {
+	price, exists := c.prices[id]
+	if !exists {
+		return catalogue.Sock{}, errors.Errorf("unknown sock %v", id)
+	}
+	if currency != "" {
+		var err error
+		if price, err = c.rates.Convert(ctx, price, currency); err != nil {
+			return catalogue.Sock{}, err
+		}
+	}
+	return catalogue.Sock{ID: id, Price: price, Quantity: c.stock[id]}, nil
+}
+
+func (c *testCatalogue) Reserve(ctx context.Context, reservationID string, items []catalogue.ReservedItem) error {
+	if _, exists := c.reserved[reservationID]; exists {
+		return nil
+	} else if _, exists := c.committed[reservationID]; exists {
+		return nil
+	}
+	for _, item := range items {
+		available := c.stock[item.SockID]
+		for _, reserved := range c.reserved {
+			for _, r := range reserved {
+				if r.SockID == item.SockID {
+					available -= r.Quantity
+				}
+			}
+		}
+		if available < item.Quantity {
+			return errors.Wrapf(catalogue.ErrOutOfStock, "sock %v", item.SockID)
+		}
+	}
+	c.reserved[reservationID] = items
+	return nil
+}
+
+func (c *testCatalogue) Commit(ctx context.Context, reservationID string) error {
+	items, exists := c.reserved[reservationID]
+	if !exists {
+		return nil
+	}
+	for _, item := range items {
+		c.stock[item.SockID] -= item.Quantity
+	}
+	delete(c.reserved, reservationID)
+	c.committed[reservationID] = items
+	return nil
+}
+
+func (c *testCatalogue) Release(ctx context.Context, reservationID string) error {
+	for _, item := range c.committed[reservationID] {
+		c.stock[item.SockID] += item.Quantity
+	}
+	delete(c.reserved, reservationID)
+	delete(c.committed, reservationID)
+	return nil
+}
+
+// A payment service that records voided and refunded payments
+type testPayments struct {
+	payment.PaymentService
+	voided   []string
+	refunded []string
+}
+
+func (p *testPayments) Void(ctx context.Context, authorisationID string) error {
+	p.voided = append(p.voided, authorisationID)
+	return p.PaymentService.Void(ctx, authorisationID)
+}
+
+func (p *testPayments) Refund(ctx context.Context, authorisationID string) error {
+	p.refunded = append(p.refunded, authorisationID)
+	return p.PaymentService.Refund(ctx, authorisationID)
+}
+
+// Gets the status of a payment
+func (p *testPayments) status(t *testing.T, authorisationID string) string {
+	payment, err := p.GetPayment(context.Background(), authorisationID)
+	require.NoError(t, err)
+	return payment.Status
+}
+
+// A shipping service whose PostShipping fails on demand
+type testShipping struct {
+	shipping.ShippingService
+	fail bool
+}
+
+func (s *testShipping) PostShipping(ctx context.Context, shipment shipping.Shipment) (shipping.Shipment, error) {
+	if s.fail {
+		return shipment, errors.Errorf("shipping unavailable")
+	}
+	return s.ShippingService.PostShipping(ctx, shipment)
+}
+
+// An orders collection whose InsertOne fails on demand
+type testOrders struct {
+	backend.NoSQLCollection
+	fail bool
+}
+
+func (c *testOrders) InsertOne(ctx context.Context, document interface{}) error {
+	if c.fail {
+		return errors.Errorf("order db unavailable")
+	}
+	return c.NoSQLCollection.InsertOne(ctx, document)
+}
+
+// A shipment events queue that tracks the events pushed and popped, so that a test can
+// tell when the order service has applied every event pushed so far
+type testEvents struct {
+	backend.Queue
+	mu      sync.Mutex
+	pushed  int
+	popped  int
+	popping bool // Whether the order service is waiting to pop an event
+}
+
+func (q *testEvents) Push(ctx context.Context, item interface{}) (bool, error) {
+	pushed, err := q.Queue.Push(ctx, item)
+	if pushed {
+		q.mu.Lock()
+		q.pushed++
+		q.mu.Unlock()
+	}
+	return pushed, err
+}
+
+func (q *testEvents) Pop(ctx context.Context, dst interface{}) (bool, error) {
+	q.mu.Lock()
+	q.popping = true
+	q.mu.Unlock()
+	popped, err := q.Queue.Pop(ctx, dst)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.popping = false
+	if popped {
+		q.popped++
+	}
+	return popped, err
+}
+
+// Reports whether the order service has applied every event and is waiting for more
+func (q *testEvents) idle() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.popping && q.popped == q.pushed
+}
+
+type sagaTest struct {
+	*orderImpl
+	catalogue *testCatalogue
+	payments  *testPayments
+	shipping  *testShipping
+	orders    *testOrders
+	events    *testEvents
+	db        backend.NoSQLDatabase
+	rates     money.ExchangeRates
+}
+
+var sock = cart.Item{ID: "sock", Quantity: 2, UnitPrice: usd(1000)}
+
+// Returns an amount in cents as USD
+func usd(cents int64) money.Money {
+	return money.Money{Amount: cents, Currency: "USD"}
+}
+
+func newSagaTest(t *testing.T) *sagaTest {
+	ctx := context.Background()
+	rates, err := money.NewFixedRates(ctx, money.DefaultExchangeRates)
+	require.NoError(t, err)
+	test := &sagaTest{catalogue: newTestCatalogue(rates), rates: rates}
+
+	cartDB, err := simplenosqldb.NewSimpleNoSQLDB(ctx)
+	require.NoError(t, err)
+	carts, err := cart.NewCartService(ctx, cartDB)
+	require.NoError(t, err)
+	_, err = carts.AddItem(ctx, "jon", sock)
+	require.NoError(t, err)
+
+	gateway, err := payment.NewFakeGateway(ctx, "")
+	require.NoError(t, err)
+	paymentDB, err := simplenosqldb.NewSimpleNoSQLDB(ctx)
+	require.NoError(t, err)
+	fraud, err := payment.NewFraudScorer(ctx, paymentDB, "")
+	require.NoError(t, err)
+	payments, err := payment.NewPaymentService(ctx, gateway, fraud, paymentDB, rates, "500.00 USD")
+	require.NoError(t, err)
+	test.payments = &testPayments{PaymentService: payments}
+
+	queue, err := simplequeue.NewSimpleQueue(ctx)
+	require.NoError(t, err)
+	events, err := simplequeue.NewSimpleQueue(ctx)
+	require.NoError(t, err)
+	test.events = &testEvents{Queue: events}
+	shipDB, err := simplenosqldb.NewSimpleNoSQLDB(ctx)
+	require.NoError(t, err)
+	ship, err := shipping.NewShippingService(ctx, queue, test.events, shipDB)
+	require.NoError(t, err)
+	test.shipping = &testShipping{ShippingService: ship}
+
+	test.db, err = simplenosqldb.NewSimpleNoSQLDB(ctx)
+	require.NoError(t, err)
+	service, err := NewOrderService(ctx, testUsers{}, carts, test.catalogue, test.payments, test.shipping, test.events, test.db, test.rates, "")
+	require.NoError(t, err)
+	test.orderImpl = service.(*orderImpl)
+	test.orders = &testOrders{NoSQLCollection: test.orderImpl.db}
+	test.orderImpl.db = test.orders
+	return test
+}
+
+// Runs service until the returned function is called, which stops it and waits for it to
+// return, so that the test can then use the databases without racing it
+func (test *sagaTest) run(service OrderService) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		service.(golang.Runnable).Run(ctx)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// Runs the order service until it has applied every shipment status event pushed so far
+func (test *sagaTest) applyEvents(t *testing.T) {
+	stop := test.run(test.orderImpl)
+	defer stop()
+	require.Eventually(t, test.events.idle, time.Second, time.Millisecond)
+}
+
+func (test *sagaTest) saga(t *testing.T, id string) orderSaga {
+	cursor, err := test.sagas.FindOne(context.Background(), bson.D{{"id", id}})
+	require.NoError(t, err)
+	var saga orderSaga
+	found, err := cursor.One(context.Background(), &saga)
+	require.NoError(t, err)
+	require.True(t, found)
+	return saga
+}
+
+func (test *sagaTest) onlySaga(t *testing.T) orderSaga {
+	cursor, err := test.sagas.FindMany(context.Background(), bson.D{})
+	require.NoError(t, err)
+	var sagas []orderSaga
+	require.NoError(t, cursor.All(context.Background(), &sagas))
+	require.Len(t, sagas, 1)
+	return sagas[0]
+}
+
+func TestSagaCompleted(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+
+	order, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "")
+	require.NoError(t, err)
+
+	saga := test.saga(t, order.ID)
+	require.Equal(t, sagaCompleted, saga.Status)
+	require.Equal(t, []string{stepReserve, stepAuthorise, stepShip, stepDeleteCart, stepCapture}, saga.Steps)
+	require.Empty(t, test.payments.voided)
+	require.Empty(t, test.payments.refunded)
+	require.Equal(t, payment.StatusCaptured, test.payments.status(t, order.AuthorisationID))
+
+	items, err := test.carts.GetCart(ctx, "jon")
+	require.NoError(t, err)
+	require.Empty(t, items)
+
+	// The order's items were taken out of stock
+	require.Equal(t, 3, test.catalogue.stock[sock.ID])
+	require.Empty(t, test.catalogue.reserved)
+}
+
+func TestSagaOutOfStock(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+	test.catalogue.stock[sock.ID] = 1
+
+	_, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "")
+	require.ErrorIs(t, err, catalogue.ErrOutOfStock)
+
+	// Nothing was paid for, and the cart is left for the customer to change
+	saga := test.onlySaga(t)
+	require.Equal(t, sagaCompensated, saga.Status)
+	require.Empty(t, saga.AuthorisationID)
+	require.Empty(t, test.payments.voided)
+
+	items, err := test.carts.GetCart(ctx, "jon")
+	require.NoError(t, err)
+	require.Equal(t, []cart.Item{sock}, items)
+}
+
+func TestSagaReservesStock(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+
+	// Stock reserved for an order being placed can't be ordered by somebody else
+	require.NoError(t, test.catalogue.Reserve(ctx, "other", []catalogue.ReservedItem{{SockID: sock.ID, Quantity: 4}}))
+	_, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "")
+	require.ErrorIs(t, err, catalogue.ErrOutOfStock)
+
+	require.NoError(t, test.catalogue.Release(ctx, "other"))
+	_, err = test.NewOrder(ctx, "jon", "address", "card", "jon", "", "")
+	require.NoError(t, err)
+	require.Equal(t, 3, test.catalogue.stock[sock.ID])
+}
+
+func TestSagaCompensatesShipping(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+	test.shipping.fail = true
+
+	_, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "")
+	require.Error(t, err)
+
+	saga := test.onlySaga(t)
+	require.Equal(t, sagaCompensated, saga.Status)
+	require.Empty(t, saga.Steps)
+	require.Contains(t, saga.Error, "shipping unavailable")
+	require.Equal(t, []string{saga.AuthorisationID}, test.payments.voided)
+	require.Equal(t, payment.StatusVoided, test.payments.status(t, saga.AuthorisationID))
+
+	// The shipment never reached the shipping service, but will not be shipped if it does
+	shipment, err := test.shipping.GetShipment(ctx, saga.ShipmentID)
+	require.NoError(t, err)
+	require.Equal(t, shipping.StatusCancelled, shipment.Status)
+
+	items, err := test.carts.GetCart(ctx, "jon")
+	require.NoError(t, err)
+	require.Equal(t, []cart.Item{sock}, items)
+}
+
+func TestSagaCompensatesOrder(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+	test.orders.fail = true
+
+	_, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "")
+	require.Error(t, err)
+
+	saga := test.onlySaga(t)
+	require.Equal(t, sagaCompensated, saga.Status)
+	require.Empty(t, saga.Steps)
+
+	// The payment was captured, then refunded; voiding it afterwards had no effect
+	require.Equal(t, []string{saga.AuthorisationID}, test.payments.refunded)
+	require.Equal(t, []string{saga.AuthorisationID}, test.payments.voided)
+	require.Equal(t, payment.StatusRefunded, test.payments.status(t, saga.AuthorisationID))
+
+	shipment, err := test.shipping.GetShipment(ctx, saga.ShipmentID)
+	require.NoError(t, err)
+	require.Equal(t, shipping.StatusCancelled, shipment.Status)
+
+	// The cart was deleted, then restored
+	items, err := test.carts.GetCart(ctx, "jon")
+	require.NoError(t, err)
+	require.Equal(t, []cart.Item{sock}, items)
+
+	// The stock was reserved, then released
+	require.Equal(t, 5, test.catalogue.stock[sock.ID])
+	require.Empty(t, test.catalogue.reserved)
+
+	// Refunding again has no effect
+	require.NoError(t, test.payments.PaymentService.Refund(ctx, saga.AuthorisationID))
+}
+
+func TestSagaRecovery(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+
+	// Run an order's saga up to the final step without compensating it, as though the
+	// order service crashed
+	test.orders.fail = true
+	saga := &orderSaga{ID: "crashed", CustomerID: "jon", CartID: "jon", Items: []cart.Item{sock}, Amount: usd(2499), Status: sagaStarted}
+	_, err := test.runSaga(ctx, saga, Order{ID: saga.ID, CustomerID: "jon", Card: testCard, Status: StatusCreated})
+	require.Error(t, err)
+
+	// A saga whose order was inserted is completed rather than compensated
+	inserted := &orderSaga{ID: "inserted", Status: sagaStarted, Steps: []string{stepReserve, stepAuthorise, stepShip, stepDeleteCart, stepCapture}}
+	require.NoError(t, test.logSaga(ctx, inserted))
+	require.NoError(t, test.orders.NoSQLCollection.InsertOne(ctx, Order{ID: inserted.ID}))
+
+	// Restarting the service recovers both, as they were updated before it started; the
+	// service finishes recovering before it stops
+	time.Sleep(time.Millisecond)
+	service, err := NewOrderService(ctx, testUsers{}, test.carts, test.catalogue, test.payments, test.shipping, test.events, test.db, test.rates, "")
+	require.NoError(t, err)
+	stop := test.run(service)
+	stop()
+
+	require.Equal(t, sagaCompensated, test.saga(t, saga.ID).Status)
+	require.Equal(t, sagaCompleted, test.saga(t, inserted.ID).Status)
+	require.Equal(t, []string{saga.AuthorisationID}, test.payments.refunded)
+	require.Equal(t, payment.StatusRefunded, test.payments.status(t, saga.AuthorisationID))
+	require.Equal(t, 5, test.catalogue.stock[sock.ID])
+	require.Empty(t, test.catalogue.reserved)
+
+	items, err := test.carts.GetCart(ctx, "jon")
+	require.NoError(t, err)
+	require.Equal(t, []cart.Item{sock}, items)
+
+	// New orders are not mistaken for incomplete ones
+	test.orders.fail = false
+	_, err = service.NewOrder(ctx, "jon", "address", "card", "jon", "", "")
+	require.NoError(t, err)
+}
+
+func TestRunOnce(t *testing.T) {
+	test := newSagaTest(t)
+	stop := test.run(test.orderImpl)
+	defer stop()
+	require.Eventually(t, test.events.idle, time.Second, time.Millisecond)
+
+	// Blueprint also runs the service for each client of it in the same process, which
+	// returns at once rather than running the service twice
+	require.NoError(t, test.orderImpl.Run(context.Background()))
+}
+
+func TestIdempotentOrder(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+
+	order, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "key")
+	require.NoError(t, err)
+	require.Equal(t, "key", order.IdempotencyKey)
+
+	// Replaying the order returns the original without placing another, even though the
+	// cart is no longer there to order from
+	replayed, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "key")
+	require.NoError(t, err)
+	require.Equal(t, order.ID, replayed.ID)
+	require.Equal(t, order.Total, replayed.Total)
+	test.onlySaga(t)
+
+	// Another customer's key does not collide
+	_, err = test.carts.AddItem(ctx, "ygritte", sock)
+	require.NoError(t, err)
+	other, err := test.NewOrder(ctx, "ygritte", "address", "card", "ygritte", "", "key")
+	require.NoError(t, err)
+	require.NotEqual(t, order.ID, other.ID)
+}
+
+func TestIdempotentOrderFailed(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+	test.shipping.fail = true
+
+	_, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "key")
+	require.ErrorContains(t, err, "shipping unavailable")
+
+	// A failed order is not retried with the same key, even once it would succeed
+	test.shipping.fail = false
+	_, err = test.NewOrder(ctx, "jon", "address", "card", "jon", "", "key")
+	require.ErrorContains(t, err, "shipping unavailable")
+	require.Len(t, test.payments.voided, 1)
+
+	_, err = test.NewOrder(ctx, "jon", "address", "card", "jon", "", "another key")
+	require.NoError(t, err)
+}
+
+func TestIdempotentOrderInProgress(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+	orderID := idempotentOrderID("jon", "key")
+
+	// A retry made while the order is being placed waits for the attempt in progress
+	release, err := test.claim(ctx, orderID)
+	require.NoError(t, err)
+	type result struct {
+		order Order
+		err   error
+	}
+	retried := make(chan result, 1)
+	go func() {
+		order, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "key")
+		retried <- result{order, err}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.Empty(t, retried)
+
+	// ... and returns the order that it placed
+	require.NoError(t, test.orders.InsertOne(ctx, Order{ID: orderID, CustomerID: "jon", IdempotencyKey: "key", Status: StatusPaid}))
+	release()
+	replayed := <-retried
+	require.NoError(t, replayed.err)
+	require.Equal(t, orderID, replayed.order.ID)
+
+	// A retry that stops waiting returns an error
+	release, err = test.claim(ctx, idempotentOrderID("jon", "other"))
+	require.NoError(t, err)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = test.NewOrder(cancelled, "jon", "address", "card", "jon", "", "other")
+	require.ErrorIs(t, err, context.Canceled)
+	release()
+
+	_, err = test.NewOrder(ctx, "jon", "address", "card", "jon", "", "other")
+	require.NoError(t, err)
+}
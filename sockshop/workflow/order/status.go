@@ -0,0 +1,174 @@
+package order
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/shipping"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/exp/slog"
+)
+
+// An order moves through a lifecycle of statuses.  Each transition is recorded, with its
+// time, in the order's status history in the order DB.  An order is created and paid for
+// while it is placed; once placed, it follows its shipment, through events published by
+// the shipping service, until it is cancelled or refunded.
+
+// The statuses of an order
+const (
+	StatusCreated   = "created"   // The order is being placed
+	StatusPaid      = "paid"      // Payment for the order has been authorised
+	StatusShipped   = "shipped"   // The order's shipment has been shipped
+	StatusDelivered = "delivered" // The order's shipment has been delivered
+	StatusCancelled = "cancelled" // The order was cancelled before it was shipped
+	StatusRefunded  = "refunded"  // The order was paid for, then refunded
+)
+
+// A transition of an order to a status
+type StatusChange struct {
+	Status string
+	Time   int64 // Unix time in nanoseconds of the transition
+}
+
+// The statuses that an order in each status can transition to.  Cancelled and refunded
+// orders are final.
+var transitions = map[string][]string{
+	StatusCreated:   {StatusPaid, StatusCancelled},
+	StatusPaid:      {StatusShipped, StatusCancelled, StatusRefunded},
+	StatusShipped:   {StatusDelivered, StatusRefunded},
+	StatusDelivered: {StatusRefunded},
+}
+
+// Returned when an order cannot transition from its current status to another
+var errInvalidTransition = errors.New("invalid order status transition")
+
+// Reports whether an order in status from can transition to status to
+func canTransition(from, to string) bool {
+	for _, status := range transitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// The order status that follows from each shipment status.  Shipments are only cancelled
+// by the order service, which records the order's outcome itself when it does so.
+var shipmentStatuses = map[string]string{
+	shipping.StatusShipped:   StatusShipped,
+	shipping.StatusDelivered: StatusDelivered,
+}
+
+// Transitions an order that has not been placed yet, recording the transition in its
+// history
+func (order *Order) transition(status string, at int64) error {
+	if !canTransition(order.Status, status) {
+		return errors.Wrapf(errInvalidTransition, "order %v cannot transition from %v to %v", order.ID, order.Status, status)
+	}
+	order.Status = status
+	order.History = append(order.History, StatusChange{Status: status, Time: at})
+	return nil
+}
+
+// Transitions a placed order to the status returned by next, recording the transition in
+// the order's history.  next is called with the order's current state, and returns the
+// order's current status to leave the order as it is.
+//
+// Returns the order after the transition, and whether the order exists.  Returns an
+// error if the order cannot transition to the status returned by next.
+func (s *orderImpl) updateStatus(ctx context.Context, orderID string, at int64, next func(Order) string) (Order, bool, error) {
+	for {
+		cursor, err := s.db.FindOne(ctx, bson.D{{"id", orderID}})
+		if err != nil {
+			return Order{}, false, err
+		}
+		var order Order
+		if exists, err := cursor.One(ctx, &order); err != nil || !exists {
+			return Order{}, false, err
+		}
+		status := next(order)
+		if order.Status == status {
+			return order, true, nil
+		} else if !canTransition(order.Status, status) {
+			return order, true, errors.Wrapf(errInvalidTransition, "order %v cannot transition from %v to %v", orderID, order.Status, status)
+		}
+
+		// The update only applies if the status has not changed since it was read
+		filter := bson.D{{"id", orderID}, {"status", order.Status}}
+		update := bson.D{
+			{"$set", bson.D{{"status", status}}},
+			{"$push", bson.D{{"history", bson.D{{"status", status}, {"time", at}}}}},
+		}
+		if updated, err := s.db.UpdateOne(ctx, filter, update); err != nil {
+			return order, true, err
+		} else if updated > 0 {
+			order.Status = status
+			order.History = append(order.History, StatusChange{Status: status, Time: at})
+			return order, true, nil
+		}
+	}
+}
+
+// Applies a shipment's status to its order.  Shipment statuses that do not affect the
+// order, such as awaiting shipment or cancelled, are ignored, as are the shipments of
+// orders that were cancelled or refunded.
+func (s *orderImpl) applyShipmentStatus(ctx context.Context, shipmentID, shipmentStatus string, at int64) error {
+	status, affectsOrder := shipmentStatuses[shipmentStatus]
+	if !affectsOrder {
+		return nil
+	}
+	// An order's shipment has the same ID as the order
+	_, _, err := s.updateStatus(ctx, shipmentID, at, func(order Order) string {
+		if len(transitions[order.Status]) == 0 {
+			return order.Status
+		}
+		return status
+	})
+	return err
+}
+
+// Applies the current status of an order's shipment to the order
+func (s *orderImpl) syncShipmentStatus(ctx context.Context, orderID string) error {
+	shipment, err := s.shipping.GetShipment(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	return s.applyShipmentStatus(ctx, shipment.ID, shipment.Status, time.Now().UnixNano())
+}
+
+// Pulls shipment status events from the events queue and applies them to orders, until
+// ctx is cancelled.
+//
+// An event for an order that has not been inserted yet is dropped; the order's shipment
+// status is applied when the order is inserted.  An event that the order cannot
+// transition for, such as one delivered out of order, is logged and dropped.
+func (s *orderImpl) runShipmentEvents(ctx context.Context) {
+	for {
+		var event shipping.StatusEvent
+		popped, err := s.events.Pop(ctx, &event)
+		if ctx.Err() != nil {
+			return
+		} else if err != nil {
+			slog.Error(fmt.Sprintf("Unable to pull shipment status event; retrying in 1 second: %v", err))
+			time.Sleep(1 * time.Second)
+			continue
+		} else if !popped {
+			continue
+		}
+
+		// Keep attempting to apply the event unless the order cannot transition
+		for {
+			err := s.applyShipmentStatus(ctx, event.ShipmentID, event.Status, event.Time)
+			if err == nil || ctx.Err() != nil {
+				break
+			} else if errors.Is(err, errInvalidTransition) {
+				slog.Warn(fmt.Sprintf("Ignoring status %v of shipment %v: %v", event.Status, event.ShipmentID, err))
+				break
+			}
+			slog.Error(fmt.Sprintf("Unable to apply status %v of shipment %v; retrying in 1 second: %v", event.Status, event.ShipmentID, err))
+			time.Sleep(1 * time.Second)
+		}
+	}
+}
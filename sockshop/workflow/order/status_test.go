@@ -0,0 +1,199 @@
+package order
+
+import (
+	"context"
+	"testing"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/shipping"
+	"github.com/stretchr/testify/require"
+)
+
+// Unit tests of order statuses that don't use gotests plugin
+
+func statuses(order Order) []string {
+	var statuses []string
+	for _, change := range order.History {
+		statuses = append(statuses, change.Status)
+	}
+	return statuses
+}
+
+func TestCanTransition(t *testing.T) {
+	require.True(t, canTransition(StatusCreated, StatusPaid))
+	require.True(t, canTransition(StatusPaid, StatusShipped))
+	require.True(t, canTransition(StatusShipped, StatusDelivered))
+	require.True(t, canTransition(StatusDelivered, StatusRefunded))
+	require.False(t, canTransition(StatusShipped, StatusCancelled))
+	require.False(t, canTransition(StatusDelivered, StatusShipped))
+	require.False(t, canTransition(StatusDelivered, StatusCancelled))
+	require.False(t, canTransition(StatusRefunded, StatusPaid))
+	require.False(t, canTransition(StatusCreated, StatusShipped))
+}
+
+func TestOrderStatusFollowsShipment(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+
+	order, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "")
+	require.NoError(t, err)
+	require.Equal(t, StatusPaid, order.Status)
+	require.Equal(t, []string{StatusCreated, StatusPaid}, statuses(order))
+
+	status := func() string {
+		order, err := test.GetOrder(ctx, order.ID)
+		require.NoError(t, err)
+		return order.Status
+	}
+
+	require.NoError(t, test.shipping.UpdateStatus(ctx, order.ID, shipping.StatusShipped))
+	test.applyEvents(t)
+	require.Equal(t, StatusShipped, status())
+
+	require.NoError(t, test.shipping.UpdateStatus(ctx, order.ID, shipping.StatusDelivered))
+	test.applyEvents(t)
+	require.Equal(t, StatusDelivered, status())
+
+	// A redelivered event does not move the order backwards
+	require.NoError(t, test.shipping.UpdateStatus(ctx, order.ID, shipping.StatusShipped))
+	require.NoError(t, test.shipping.UpdateStatus(ctx, order.ID, shipping.StatusDelivered))
+	test.applyEvents(t)
+
+	order, err = test.GetOrder(ctx, order.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusDelivered, order.Status)
+	require.Equal(t, []string{StatusCreated, StatusPaid, StatusShipped, StatusDelivered}, statuses(order))
+	for i := 1; i < len(order.History); i++ {
+		require.GreaterOrEqual(t, order.History[i].Time, order.History[i-1].Time)
+	}
+}
+
+// A shipping service that ships each shipment as soon as it is posted
+type testPromptShipping struct {
+	*testShipping
+}
+
+func (s testPromptShipping) PostShipping(ctx context.Context, shipment shipping.Shipment) (shipping.Shipment, error) {
+	shipment, err := s.testShipping.PostShipping(ctx, shipment)
+	if err != nil {
+		return shipment, err
+	}
+	return shipment, s.UpdateStatus(ctx, shipment.ID, shipping.StatusShipped)
+}
+
+func TestOrderStatusShippedWhilePlacing(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+	test.orderImpl.shipping = testPromptShipping{test.shipping}
+
+	// The shipment's event may arrive before the order exists, but the order still
+	// reflects the shipment once it is placed
+	order, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "")
+	require.NoError(t, err)
+
+	order, err = test.GetOrder(ctx, order.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusShipped, order.Status)
+	require.Equal(t, []string{StatusCreated, StatusPaid, StatusShipped}, statuses(order))
+}
+
+func TestCancelOrder(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+
+	order, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "")
+	require.NoError(t, err)
+	require.NotEmpty(t, order.AuthorisationID)
+
+	order, err = test.CancelOrder(ctx, order.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusCancelled, order.Status)
+	require.Equal(t, []string{StatusCreated, StatusPaid, StatusCancelled}, statuses(order))
+	require.Equal(t, shipping.StatusCancelled, order.Shipment.Status)
+	require.Equal(t, []string{order.AuthorisationID}, test.payments.refunded)
+	require.Equal(t, 5, test.catalogue.stock[sock.ID])
+
+	// Cancelling again has no effect
+	order, err = test.CancelOrder(ctx, order.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusCancelled, order.Status)
+	require.Len(t, test.payments.refunded, 1)
+	require.Equal(t, 5, test.catalogue.stock[sock.ID])
+
+	// A cancelled order can't be refunded or follow its shipment
+	_, err = test.RefundOrder(ctx, order.ID)
+	require.ErrorIs(t, err, errInvalidTransition)
+	require.NoError(t, test.shipping.UpdateStatus(ctx, order.ID, shipping.StatusShipped))
+	test.applyEvents(t)
+
+	order, err = test.GetOrder(ctx, order.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusCancelled, order.Status)
+}
+
+func TestCancelShippedOrder(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+	test.orderImpl.shipping = testPromptShipping{test.shipping}
+
+	order, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "")
+	require.NoError(t, err)
+
+	_, err = test.CancelOrder(ctx, order.ID)
+	require.Error(t, err)
+	require.Empty(t, test.payments.refunded)
+
+	order, err = test.GetOrder(ctx, order.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusShipped, order.Status)
+	require.Equal(t, shipping.StatusShipped, order.Shipment.Status)
+}
+
+func TestRefundOrder(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+	test.orderImpl.shipping = testPromptShipping{test.shipping}
+
+	order, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "")
+	require.NoError(t, err)
+
+	// The shipment has been shipped, so it is left as it is
+	order, err = test.RefundOrder(ctx, order.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusRefunded, order.Status)
+	require.Equal(t, []string{StatusCreated, StatusPaid, StatusShipped, StatusRefunded}, statuses(order))
+	require.Equal(t, shipping.StatusShipped, order.Shipment.Status)
+	require.Equal(t, []string{order.AuthorisationID}, test.payments.refunded)
+	require.Equal(t, 3, test.catalogue.stock[sock.ID])
+
+	// Refunding again has no effect, and a refunded order no longer follows its shipment
+	order, err = test.RefundOrder(ctx, order.ID)
+	require.NoError(t, err)
+	require.Len(t, test.payments.refunded, 1)
+	require.NoError(t, test.shipping.UpdateStatus(ctx, order.ID, shipping.StatusDelivered))
+	test.applyEvents(t)
+
+	order, err = test.GetOrder(ctx, order.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusRefunded, order.Status)
+}
+
+func TestRefundUnshippedOrder(t *testing.T) {
+	ctx := context.Background()
+	test := newSagaTest(t)
+
+	order, err := test.NewOrder(ctx, "jon", "address", "card", "jon", "", "")
+	require.NoError(t, err)
+
+	order, err = test.RefundOrder(ctx, order.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusRefunded, order.Status)
+	require.Equal(t, shipping.StatusCancelled, order.Shipment.Status)
+	require.Equal(t, []string{order.AuthorisationID}, test.payments.refunded)
+	require.Equal(t, 5, test.catalogue.stock[sock.ID])
+
+	// The shipment's cancellation does not change the order's outcome
+	test.applyEvents(t)
+	order, err = test.GetOrder(ctx, order.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusRefunded, order.Status)
+}
@@ -0,0 +1,75 @@
+package payment
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/user"
+)
+
+// Checks that a card can be used for a payment at time now, returning the reasons it
+// cannot.  The card's number must pass the Luhn check, and its expiry, formatted as
+// MMYY or MM/YY, must not have passed.
+func validateCard(card user.Card, now time.Time) []DeclineReason {
+	var reasons []DeclineReason
+	if !luhn(card.LongNum) {
+		reasons = append(reasons, DeclineReason{
+			Code:    ReasonInvalidCardNumber,
+			Message: "card number is invalid",
+		})
+	}
+
+	expires, ok := parseExpiry(card.Expires)
+	if !ok {
+		reasons = append(reasons, DeclineReason{
+			Code:    ReasonInvalidExpiry,
+			Message: fmt.Sprintf("card expiry %q is invalid; expected MMYY", card.Expires),
+		})
+	} else if !now.Before(expires) {
+		reasons = append(reasons, DeclineReason{
+			Code:    ReasonCardExpired,
+			Message: fmt.Sprintf("card expired at the end of %v", expires.AddDate(0, -1, 0).Format("01/06")),
+		})
+	}
+	return reasons
+}
+
+// Reports whether a card number, ignoring spaces, passes the Luhn check
+func luhn(longNum string) bool {
+	digits := strings.ReplaceAll(longNum, " ", "")
+	if len(digits) < 12 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := range digits {
+		digit := int(digits[len(digits)-1-i] - '0')
+		if digit < 0 || digit > 9 {
+			return false
+		}
+		if i%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+// Parses a card's expiry, returning the time at which the card expires: the start of
+// the month after its expiry month
+func parseExpiry(expiry string) (time.Time, bool) {
+	expiry = strings.ReplaceAll(expiry, "/", "")
+	if len(expiry) != 4 || strings.Trim(expiry, "0123456789") != "" {
+		return time.Time{}, false
+	}
+	month, err1 := strconv.Atoi(expiry[:2])
+	year, err2 := strconv.Atoi(expiry[2:])
+	if err1 != nil || err2 != nil || month < 1 || month > 12 {
+		return time.Time{}, false
+	}
+	return time.Date(2000+year, time.Month(month)+1, 1, 0, 0, 0, 0, time.UTC), true
+}
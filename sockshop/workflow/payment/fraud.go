@@ -0,0 +1,296 @@
+package payment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/user"
+	"github.com/blueprint-uservices/blueprint/runtime/core/backend"
+	errors_ "github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// FraudScorer scores the risk that a payment is fraudulent, so that risky payments can
+// be declined before they are sent to the payment gateway.
+type FraudScorer interface {
+	// Scores a payment of amount from card, made by customerID to an address.  The
+	// payment is remembered, so that later payments can be scored against it.  Scoring
+	// a payment with the same paymentID again replaces its previous score.
+	Score(ctx context.Context, paymentID string, customerID string, address user.Address, card user.Card, amount money.Money) (FraudScore, error)
+}
+
+// The fraud score of a payment
+type FraudScore struct {
+	Score   int             // The sum of the scores of the rules the payment triggered
+	Decline bool            // Whether the score is high enough for the payment to be declined
+	Reasons []DeclineReason // The rules the payment triggered
+}
+
+// The rules of the fraud scorer used by the SockShop wiring specs.  No single rule is
+// enough to decline a payment.
+const DefaultFraudRules = "card_velocity 10 1m 40; customer_velocity 5 1m 40; amount_anomaly 5 40; country_mismatch 30; decline_at 70"
+
+// The score at which payments are declined, if the rules do not say otherwise
+const defaultDeclineAt = 100
+
+// The number of previous payments a customer must have made before their payments are
+// checked for unusual amounts
+const minAnomalyHistory = 3
+
+// Returns a rule-based fraud scorer that remembers the payments it scores in historyDB.
+//
+// rules is a list of rules separated by semicolons.  Each rule adds its score to a
+// payment's fraud score if the payment triggers it:
+//
+//	card_velocity 10 1m 40        the card was used for more than 10 payments in the last minute
+//	customer_velocity 5 1m 40     the customer made more than 5 payments in the last minute
+//	amount_anomaly 5 40           the amount is more than 5 times the customer's average payment in its currency
+//	country_mismatch 30           the card was issued in a different country from the address
+//
+// A payment is declined if its fraud score reaches the score given by a decline_at rule,
+// such as decline_at 70, or 100 if there is none.
+func NewFraudScorer(ctx context.Context, historyDB backend.NoSQLDatabase, rules string) (FraudScorer, error) {
+	history, err := historyDB.GetCollection(ctx, "fraud_scorer", "payments")
+	if err != nil {
+		return nil, err
+	}
+	s := &fraudScorer{declineAt: defaultDeclineAt, history: history}
+	for _, rule := range strings.Split(rules, ";") {
+		fields := strings.Fields(rule)
+		if len(fields) == 0 {
+			continue
+		}
+		if err := s.parseRule(fields); err != nil {
+			return nil, errors_.Wrapf(err, "invalid fraud rule %q", strings.TrimSpace(rule))
+		}
+	}
+	return s, nil
+}
+
+// A payment scored by the fraud scorer, as remembered in its history
+type scoredPayment struct {
+	PaymentID   string
+	CustomerID  string
+	Fingerprint string // A hash of the card's number
+	CardCountry string
+	Country     string // The country of the payment's address
+	Amount      money.Money
+	Time        int64 // Unix time in nanoseconds at which the payment was scored
+}
+
+// The previous payments made from the same card, and by the same customer, as a payment
+type paymentHistory struct {
+	card     []scoredPayment // Only those made within the longest velocity window
+	customer []scoredPayment
+}
+
+// A rule that the fraud scorer checks payments against
+type fraudRule interface {
+	// Returns the reason that a payment triggers the rule, if it does
+	check(payment scoredPayment, history paymentHistory) (DeclineReason, bool)
+}
+
+type fraudScorer struct {
+	rules     []fraudRule
+	declineAt int
+	window    time.Duration // The longest window of any velocity rule
+	history   backend.NoSQLCollection
+}
+
+// Score implements FraudScorer.
+func (s *fraudScorer) Score(ctx context.Context, paymentID string, customerID string, address user.Address, card user.Card, amount money.Money) (FraudScore, error) {
+	payment := scoredPayment{
+		PaymentID:   paymentID,
+		CustomerID:  customerID,
+		Fingerprint: fingerprint(card.LongNum),
+		CardCountry: card.Country,
+		Country:     address.Country,
+		Amount:      amount,
+		Time:        time.Now().UnixNano(),
+	}
+	history, err := s.load(ctx, payment)
+	if err != nil {
+		return FraudScore{}, errors_.Wrapf(err, "unable to load payment history for payment %v", paymentID)
+	}
+
+	score := FraudScore{Reasons: []DeclineReason{}}
+	for _, rule := range s.rules {
+		if reason, triggered := rule.check(payment, history); triggered {
+			score.Score += reason.Score
+			score.Reasons = append(score.Reasons, reason)
+		}
+	}
+	score.Decline = score.Score >= s.declineAt
+
+	if _, err := s.history.Upsert(ctx, bson.D{{"paymentid", paymentID}}, payment); err != nil {
+		return FraudScore{}, errors_.Wrapf(err, "unable to remember payment %v", paymentID)
+	}
+	return score, nil
+}
+
+// Loads the previous payments from the same card and by the same customer as payment
+func (s *fraudScorer) load(ctx context.Context, payment scoredPayment) (paymentHistory, error) {
+	var history paymentHistory
+	since := payment.Time - s.window.Nanoseconds()
+	filter := bson.D{{"fingerprint", payment.Fingerprint}, {"time", bson.D{{"$gt", since}}}}
+	if err := s.find(ctx, filter, payment.PaymentID, &history.card); err != nil {
+		return history, err
+	}
+	if payment.CustomerID == "" {
+		return history, nil
+	}
+	return history, s.find(ctx, bson.D{{"customerid", payment.CustomerID}}, payment.PaymentID, &history.customer)
+}
+
+// Finds the scored payments matching filter, other than the payment being scored
+func (s *fraudScorer) find(ctx context.Context, filter bson.D, paymentID string, payments *[]scoredPayment) error {
+	cursor, err := s.history.FindMany(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var found []scoredPayment
+	if err := cursor.All(ctx, &found); err != nil {
+		return err
+	}
+	for _, payment := range found {
+		if payment.PaymentID != paymentID {
+			*payments = append(*payments, payment)
+		}
+	}
+	return nil
+}
+
+// Parses a rule's fields, adding it to the scorer
+func (s *fraudScorer) parseRule(fields []string) error {
+	args, score, err := parseScore(fields)
+	if err != nil {
+		return err
+	}
+	switch {
+	case fields[0] == "decline_at" && len(args) == 0:
+		s.declineAt = score
+	case (fields[0] == ReasonCardVelocity || fields[0] == ReasonCustomerVelocity) && len(args) == 2:
+		count, err := strconv.Atoi(args[0])
+		if err != nil || count < 0 {
+			return errors_.Errorf("expected a payment count but got %v", args[0])
+		}
+		window, err := time.ParseDuration(args[1])
+		if err != nil || window <= 0 {
+			return errors_.Errorf("expected a window such as 1m but got %v", args[1])
+		}
+		s.rules = append(s.rules, velocityRule{code: fields[0], count: count, window: window, score: score})
+		s.window = max(s.window, window)
+	case fields[0] == ReasonAmountAnomaly && len(args) == 1:
+		factor, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || factor <= 0 {
+			return errors_.Errorf("expected a factor of the customer's average payment but got %v", args[0])
+		}
+		s.rules = append(s.rules, amountAnomalyRule{factor: factor, score: score})
+	case fields[0] == ReasonCountryMismatch && len(args) == 0:
+		s.rules = append(s.rules, countryMismatchRule{score: score})
+	default:
+		return errors_.Errorf("unknown rule %v with %v arguments", fields[0], len(args))
+	}
+	return nil
+}
+
+// Splits a rule's fields into its arguments and its score, which is the last field
+func parseScore(fields []string) ([]string, int, error) {
+	if len(fields) < 2 {
+		return nil, 0, errors_.Errorf("expected a rule name followed by its arguments and a score")
+	}
+	score, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || score < 0 {
+		return nil, 0, errors_.Errorf("expected a score but got %v", fields[len(fields)-1])
+	}
+	return fields[1 : len(fields)-1], score, nil
+}
+
+// Triggered by payments from a card, or by a customer, that made more than count
+// payments within window
+type velocityRule struct {
+	code   string // ReasonCardVelocity or ReasonCustomerVelocity
+	count  int
+	window time.Duration
+	score  int
+}
+
+func (r velocityRule) check(payment scoredPayment, history paymentHistory) (DeclineReason, bool) {
+	previous, payer := history.card, "card"
+	if r.code == ReasonCustomerVelocity {
+		previous, payer = history.customer, "customer"
+	}
+	recent := 0
+	for _, p := range previous {
+		if p.Time > payment.Time-r.window.Nanoseconds() {
+			recent++
+		}
+	}
+	if recent < r.count {
+		return DeclineReason{}, false
+	}
+	return DeclineReason{
+		Code:    r.code,
+		Message: fmt.Sprintf("%v made more than %v payments within %v", payer, r.count, r.window),
+		Score:   r.score,
+	}, true
+}
+
+// Triggered by payments of more than factor times the customer's average payment.  Only
+// the customer's payments in the same currency are averaged.
+type amountAnomalyRule struct {
+	factor float64
+	score  int
+}
+
+func (r amountAnomalyRule) check(payment scoredPayment, history paymentHistory) (DeclineReason, bool) {
+	var total, count int64
+	for _, p := range history.customer {
+		if p.Amount.Currency == payment.Amount.Currency {
+			total += p.Amount.Amount
+			count++
+		}
+	}
+	if count < minAnomalyHistory {
+		return DeclineReason{}, false
+	}
+	average := money.Money{Amount: (total + count/2) / count, Currency: payment.Amount.Currency}
+	if float64(payment.Amount.Amount) <= r.factor*float64(total)/float64(count) {
+		return DeclineReason{}, false
+	}
+	return DeclineReason{
+		Code:    ReasonAmountAnomaly,
+		Message: fmt.Sprintf("amount %v is more than %v times the customer's average of %v", payment.Amount, r.factor, average),
+		Score:   r.score,
+	}, true
+}
+
+// Triggered by payments from a card issued in a different country from the address.
+// Payments where either country is unknown do not trigger the rule.
+type countryMismatchRule struct {
+	score int
+}
+
+func (r countryMismatchRule) check(payment scoredPayment, history paymentHistory) (DeclineReason, bool) {
+	card, address := strings.TrimSpace(payment.CardCountry), strings.TrimSpace(payment.Country)
+	if card == "" || address == "" || strings.EqualFold(card, address) {
+		return DeclineReason{}, false
+	}
+	return DeclineReason{
+		Code:    ReasonCountryMismatch,
+		Message: fmt.Sprintf("card issued in %v but address is in %v", card, address),
+		Score:   r.score,
+	}, true
+}
+
+// Identifies a card without keeping its number
+func fingerprint(longNum string) string {
+	sum := sha256.Sum256([]byte(strings.ReplaceAll(longNum, " ", "")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,199 @@
+package payment
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/user"
+	"github.com/blueprint-uservices/blueprint/runtime/plugins/simplenosqldb"
+	"github.com/stretchr/testify/require"
+)
+
+// Unit tests of card validation and fraud scoring that don't use gotests plugin
+
+func newFraudTest(t *testing.T, rules string) FraudScorer {
+	ctx := context.Background()
+	db, err := simplenosqldb.NewSimpleNoSQLDB(ctx)
+	require.NoError(t, err)
+	scorer, err := NewFraudScorer(ctx, db, rules)
+	require.NoError(t, err)
+	return scorer
+}
+
+// The codes of the reasons in a fraud score
+func scoreCodes(score FraudScore) []string {
+	return codes(Authorisation{Reasons: score.Reasons})
+}
+
+func TestValidateCard(t *testing.T) {
+	now := time.Date(2026, time.October, 17, 0, 0, 0, 0, time.UTC)
+	valid := func(longNum, expires string) []string {
+		return codes(Authorisation{Reasons: validateCard(user.Card{LongNum: longNum, Expires: expires}, now)})
+	}
+
+	require.Empty(t, valid("4242424242424242", "1026"))
+	require.Empty(t, valid("4242 4242 4242 4242", "10/26"))
+	require.Empty(t, valid("378282246310005", "0530"))
+	require.Equal(t, []string{ReasonInvalidCardNumber}, valid("4242424242424241", "1239"))
+	require.Equal(t, []string{ReasonInvalidCardNumber}, valid("1234123412341234", "1239"))
+	require.Equal(t, []string{ReasonInvalidCardNumber}, valid("", "1239"))
+	require.Equal(t, []string{ReasonInvalidCardNumber}, valid("4242-4242-4242-4242", "1239"))
+	require.Equal(t, []string{ReasonCardExpired}, valid("4242424242424242", "0926"))
+	require.Equal(t, []string{ReasonInvalidExpiry}, valid("4242424242424242", ""))
+	require.Equal(t, []string{ReasonInvalidExpiry}, valid("4242424242424242", "1326"))
+	require.Equal(t, []string{ReasonInvalidExpiry}, valid("4242424242424242", "01-1"))
+	require.Equal(t, []string{ReasonInvalidCardNumber, ReasonCardExpired}, valid("1234", "0126"))
+}
+
+func TestNewFraudScorer(t *testing.T) {
+	ctx := context.Background()
+	db, err := simplenosqldb.NewSimpleNoSQLDB(ctx)
+	require.NoError(t, err)
+	invalid := []string{
+		"card_velocity 10 1m", "card_velocity ten 1m 40", "card_velocity 10 never 40", "customer_velocity 10 -1m 40",
+		"amount_anomaly 0 40", "country_mismatch", "country_mismatch -30", "decline_at", "unknown_rule 40",
+	}
+	for _, rules := range invalid {
+		_, err := NewFraudScorer(ctx, db, rules)
+		require.Error(t, err, rules)
+	}
+	_, err = NewFraudScorer(ctx, db, DefaultFraudRules)
+	require.NoError(t, err)
+}
+
+func TestFraudVelocity(t *testing.T) {
+	ctx := context.Background()
+	s := newFraudTest(t, "card_velocity 2 1m 40; customer_velocity 3 1m 30; decline_at 70")
+	other := user.Card{LongNum: "4000000000000002"}
+
+	for i, id := range []string{"first", "second"} {
+		score, err := s.Score(ctx, id, "jon", address, goodCard, dollars(10))
+		require.NoError(t, err)
+		require.Zero(t, score.Score, i)
+	}
+
+	// Scoring a payment again does not count it twice
+	score, err := s.Score(ctx, "second", "jon", address, goodCard, dollars(10))
+	require.NoError(t, err)
+	require.Zero(t, score.Score)
+
+	// The card has been used too often, but the customer has not
+	score, err = s.Score(ctx, "third", "jon", address, goodCard, dollars(10))
+	require.NoError(t, err)
+	require.Equal(t, []string{ReasonCardVelocity}, scoreCodes(score))
+	require.Equal(t, 40, score.Score)
+	require.False(t, score.Decline)
+
+	// A different card is fine, for a different customer
+	score, err = s.Score(ctx, "fourth", "ann", address, other, dollars(10))
+	require.NoError(t, err)
+	require.Zero(t, score.Score)
+
+	// Until the customer has made too many payments too
+	score, err = s.Score(ctx, "fifth", "jon", address, goodCard, dollars(10))
+	require.NoError(t, err)
+	require.Equal(t, []string{ReasonCardVelocity, ReasonCustomerVelocity}, scoreCodes(score))
+	require.Equal(t, 70, score.Score)
+	require.True(t, score.Decline)
+}
+
+func TestFraudAmountAnomaly(t *testing.T) {
+	ctx := context.Background()
+	s := newFraudTest(t, "amount_anomaly 5 40; decline_at 40")
+
+	// A customer without enough history can spend anything
+	for i, id := range []string{"first", "second", "third"} {
+		score, err := s.Score(ctx, id, "jon", address, goodCard, dollars(int64(10*(i+1))))
+		require.NoError(t, err)
+		require.False(t, score.Decline)
+	}
+
+	// The customer's average payment is 20
+	score, err := s.Score(ctx, "fourth", "jon", address, goodCard, dollars(100))
+	require.NoError(t, err)
+	require.False(t, score.Decline)
+	// And is then 40
+	score, err = s.Score(ctx, "fifth", "jon", address, goodCard, dollars(250))
+	require.NoError(t, err)
+	require.True(t, score.Decline)
+	require.Equal(t, []string{ReasonAmountAnomaly}, scoreCodes(score))
+	require.Contains(t, score.Reasons[0].Message, "average of 40.00 USD")
+
+	// Payments in other currencies are not compared with the customer's payments in USD
+	score, err = s.Score(ctx, "sixth", "jon", address, goodCard, money.Money{Amount: 25000, Currency: "EUR"})
+	require.NoError(t, err)
+	require.False(t, score.Decline)
+}
+
+func TestFraudCountryMismatch(t *testing.T) {
+	ctx := context.Background()
+	s := newFraudTest(t, "country_mismatch 30")
+	card := goodCard
+
+	// Cards without a known country are not checked
+	score, err := s.Score(ctx, "first", "jon", address, card, dollars(10))
+	require.NoError(t, err)
+	require.Zero(t, score.Score)
+
+	card.Country = "germany"
+	score, err = s.Score(ctx, "second", "jon", address, card, dollars(10))
+	require.NoError(t, err)
+	require.Zero(t, score.Score)
+
+	card.Country = "France"
+	score, err = s.Score(ctx, "third", "jon", address, card, dollars(10))
+	require.NoError(t, err)
+	require.Equal(t, []string{ReasonCountryMismatch}, scoreCodes(score))
+	require.Equal(t, 30, score.Score)
+	require.False(t, score.Decline)
+}
+
+func TestPaymentFraudDeclined(t *testing.T) {
+	ctx := context.Background()
+	s, gateway := newPaymentTest(t, "card_velocity 1 1m 40; country_mismatch 30; decline_at 70")
+
+	// Invalid cards are declined without being scored
+	expired := goodCard
+	expired.Expires = "0120"
+	auth, err := s.Authorise(ctx, "jon", address, expired, dollars(10))
+	require.NoError(t, err)
+	require.False(t, auth.Authorised)
+	require.Equal(t, []string{ReasonCardExpired}, codes(auth))
+
+	// Risks found in an authorised payment are recorded
+	foreign := goodCard
+	foreign.Country = "France"
+	first, err := s.Authorise(ctx, "jon", address, foreign, dollars(10))
+	require.NoError(t, err)
+	require.True(t, first.Authorised)
+	require.Equal(t, 30, first.RiskScore)
+	require.Equal(t, []string{ReasonCountryMismatch}, codes(first))
+
+	// Enough risks decline the payment without sending it to the gateway
+	second, err := s.Authorise(ctx, "jon", address, foreign, dollars(10))
+	require.NoError(t, err)
+	require.False(t, second.Authorised)
+	require.Equal(t, 70, second.RiskScore)
+	require.Equal(t, []string{ReasonCardVelocity, ReasonCountryMismatch}, codes(second))
+	require.Contains(t, second.Message, "card made more than 1 payments within 1m0s")
+	require.Len(t, gateway.authorisations, 1)
+
+	// The ledger records why each payment was declined
+	payments, err := s.ledger.FindMany(ctx, nil)
+	require.NoError(t, err)
+	var recorded []Payment
+	require.NoError(t, payments.All(ctx, &recorded))
+	require.Len(t, recorded, 3)
+	for _, payment := range recorded {
+		require.Equal(t, "jon", payment.CustomerID)
+		if payment.ID == first.ID {
+			require.Equal(t, StatusAuthorised, payment.Status)
+			require.Equal(t, first.Reasons, payment.Reasons)
+		} else {
+			require.Equal(t, StatusDeclined, payment.Status)
+			require.NotEmpty(t, payment.Reasons)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/user"
+	errors_ "github.com/pkg/errors"
+)
+
+// Gateway is a payment gateway, through which the payment service takes payments from
+// cards.
+//
+// Each call is identified by a transaction ID chosen by the caller.  Repeating a call
+// with the same transaction ID has no further effect, so a call whose outcome is unknown,
+// such as one that timed out, can be retried.
+type Gateway interface {
+	// Authorises a payment of amount from card, holding the amount on the card.  The
+	// authorisation is identified by transactionID.  Returns an error wrapping
+	// [ErrDeclined] or [ErrInsufficientFunds] if the payment is declined.
+	Authorise(ctx context.Context, transactionID string, card user.Card, amount money.Money) error
+
+	// Captures an authorisation, taking the held amount from the card.
+	Capture(ctx context.Context, transactionID string, authorisationID string) error
+
+	// Voids an authorisation that has not been captured, releasing the held amount.
+	// Voiding an authorisation that the gateway never made has no effect.
+	Void(ctx context.Context, transactionID string, authorisationID string) error
+
+	// Refunds a captured authorisation, returning the captured amount to the card.
+	Refund(ctx context.Context, transactionID string, authorisationID string) error
+}
+
+// ErrDeclined is returned by a gateway when the card's issuer declines a payment.
+var ErrDeclined = errors.New("card declined")
+
+// ErrInsufficientFunds is returned by a gateway when the card's funds do not cover a payment.
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// ErrGatewayTimeout is returned by a gateway that did not reply in time.  The call may or
+// may not have taken effect.
+var ErrGatewayTimeout = errors.New("payment gateway timed out")
+
+// The script of the fake gateway used by the SockShop wiring specs.  Its card numbers
+// follow the test cards of real payment gateways.
+const DefaultGatewayScript = "4000000000000002 decline; 4000000000009995 funds 10 USD; 4000000000000119 timeout"
+
+// Returns a fake payment gateway whose outcomes for each card number are scripted.
+//
+// script is a list of rules separated by semicolons.  Each rule is a card number followed
+// by an outcome for payments from that card:
+//
+//	4000000000000002 decline         payments are declined
+//	4000000000009995 funds 10 USD    the card holds funds of 10.00 USD, and payments it cannot cover are declined
+//	4000000000000119 timeout         authorisations time out, though the gateway makes them
+//
+// A card with funds cannot cover payments in any other currency than its funds'.
+//
+// Payments from cards without a rule are authorised.
+func NewFakeGateway(ctx context.Context, script string) (Gateway, error) {
+	g := &fakeGateway{
+		rules:          make(map[string]string),
+		funds:          make(map[string]money.Money),
+		authorisations: make(map[string]*fakeAuthorisation),
+		transactions:   make(map[string]bool),
+	}
+	for _, rule := range strings.Split(script, ";") {
+		fields := strings.Fields(rule)
+		if len(fields) == 0 {
+			continue
+		} else if len(fields) == 2 && (fields[1] == "decline" || fields[1] == "timeout") {
+			g.rules[fields[0]] = fields[1]
+			continue
+		} else if len(fields) == 4 && fields[1] == "funds" {
+			funds, err := money.ParseAmount(fields[2], fields[3])
+			if err == nil && funds.Amount >= 0 {
+				g.rules[fields[0]] = fields[1]
+				g.funds[fields[0]] = funds
+				continue
+			}
+		}
+		return nil, errors_.Errorf("invalid gateway rule %q; expected a card number followed by decline, timeout, or funds and an amount of money", strings.TrimSpace(rule))
+	}
+	return g, nil
+}
+
+// An authorisation made by the fake gateway
+type fakeAuthorisation struct {
+	card   string
+	amount money.Money
+	status string
+}
+
+type fakeGateway struct {
+	lock           sync.Mutex
+	rules          map[string]string             // The outcome for each card number with a rule
+	funds          map[string]money.Money        // The remaining funds of each card with a funds rule
+	authorisations map[string]*fakeAuthorisation // Authorisations by transaction ID
+	transactions   map[string]bool               // The IDs of completed captures, voids, and refunds
+}
+
+// Authorise implements Gateway.
+func (g *fakeGateway) Authorise(ctx context.Context, transactionID string, card user.Card, amount money.Money) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if _, exists := g.authorisations[transactionID]; exists {
+		return nil
+	}
+
+	switch g.rules[card.LongNum] {
+	case "decline":
+		return ErrDeclined
+	case "funds":
+		funds := g.funds[card.LongNum]
+		if funds.Currency != amount.Currency || funds.Amount < amount.Amount {
+			return errors_.Wrapf(ErrInsufficientFunds, "%v wanted but only %v available", amount, funds)
+		}
+		g.funds[card.LongNum] = money.Money{Amount: funds.Amount - amount.Amount, Currency: funds.Currency}
+	}
+	g.authorisations[transactionID] = &fakeAuthorisation{card: card.LongNum, amount: amount, status: StatusAuthorised}
+	if g.rules[card.LongNum] == "timeout" {
+		return ErrGatewayTimeout
+	}
+	return nil
+}
+
+// Capture implements Gateway.
+func (g *fakeGateway) Capture(ctx context.Context, transactionID string, authorisationID string) error {
+	return g.complete(transactionID, authorisationID, StatusAuthorised, StatusCaptured)
+}
+
+// Void implements Gateway.
+func (g *fakeGateway) Void(ctx context.Context, transactionID string, authorisationID string) error {
+	return g.complete(transactionID, authorisationID, StatusAuthorised, StatusVoided)
+}
+
+// Refund implements Gateway.
+func (g *fakeGateway) Refund(ctx context.Context, transactionID string, authorisationID string) error {
+	return g.complete(transactionID, authorisationID, StatusCaptured, StatusRefunded)
+}
+
+// Moves an authorisation from one status to another, returning held or captured funds
+// to the card if it is voided or refunded.  Voiding an unknown authorisation has no
+// effect.
+func (g *fakeGateway) complete(transactionID, authorisationID, from, to string) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.transactions[transactionID] {
+		return nil
+	}
+	auth, exists := g.authorisations[authorisationID]
+	if !exists && to == StatusVoided {
+		return nil
+	} else if !exists {
+		return errors_.Errorf("no authorisation %v", authorisationID)
+	} else if auth.status != from {
+		return errors_.Errorf("authorisation %v is %v", authorisationID, auth.status)
+	}
+
+	auth.status = to
+	if funds, hasFunds := g.funds[auth.card]; hasFunds && (to == StatusVoided || to == StatusRefunded) {
+		g.funds[auth.card] = money.Money{Amount: funds.Amount + auth.amount.Amount, Currency: funds.Currency}
+	}
+	g.transactions[transactionID] = true
+	return nil
+}
@@ -1,62 +1,402 @@
 // Package payment implements the SockShop payment microservice.
 //
-// The service fakes payments, implementing simple logic whereby payments
-// are authorized when they're below a predefined threshold, and rejected
-// when they are above that threshold.
+// The service takes payments from customers' cards through a payment [Gateway],
+// following the lifecycle of a card payment: a payment is first authorised, which
+// holds the amount on the card, and is then either captured, which takes the held
+// amount, or voided, which releases it.  A captured payment can be refunded.
+//
+// Before a payment is sent to the gateway, the card's number is checked with the Luhn
+// algorithm, its expiry is checked, and the payment is scored by a [FraudScorer].
+// Payments with invalid or expired cards, payments that score too highly, and payments
+// above a predefined threshold are declined, with [DeclineReason]s saying why.
+//
+// Every payment, and every gateway transaction made for it, is recorded in a payment
+// ledger.  [NewFakeGateway] provides a fake gateway whose outcomes for each card can be
+// scripted, and [NewFraudScorer] a fraud scorer whose rules can be configured.
 package payment
 
 import (
 	"context"
 	"errors"
 	"fmt"
-	"strconv"
+	"strings"
+	"time"
 
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/user"
+	"github.com/blueprint-uservices/blueprint/runtime/core/backend"
+	"github.com/google/uuid"
 	errors_ "github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 // PaymentService provides payment services
 type PaymentService interface {
-	Authorise(ctx context.Context, amount float32) (Authorisation, error)
+	// Authorises a payment of amount from card, made by customerID to an address,
+	// holding the amount on the card until the payment is captured or voided.  A
+	// payment that is declined, by the service or by the gateway, returns an
+	// Authorisation that is not Authorised, with the reasons it was declined.  Returns
+	// an error if the gateway could not be reached; any amount held by an
+	// authorisation that failed this way is voided.
+	Authorise(ctx context.Context, customerID string, address user.Address, card user.Card, amount money.Money) (Authorisation, error)
+
+	// Captures an authorised payment, taking the held amount from the card.
+	// Capturing a payment that was already captured has no effect.  Returns an error
+	// if the payment was voided or refunded.
+	Capture(ctx context.Context, authorisationID string) error
+
+	// Voids a successful authorisation that has not been captured, releasing the
+	// held amount.  Used to compensate an authorisation when an order cannot be
+	// completed.  Returns an error if the payment was captured; a captured payment
+	// is refunded instead.  Voiding an authorisation that was already voided or
+	// refunded has no effect.
+	Void(ctx context.Context, authorisationID string) error
+
+	// Refunds a captured payment, returning the captured amount to the card.  Used
+	// to reverse the payment for an order that is cancelled or refunded.  A payment
+	// that was authorised but not captured is voided instead, so that any payment
+	// can be reversed by refunding it.  Refunding a payment that was already
+	// refunded or voided has no effect.
+	Refund(ctx context.Context, authorisationID string) error
+
+	// Gets a payment from the payment ledger, with its gateway transactions
+	GetPayment(ctx context.Context, authorisationID string) (Payment, error)
 }
 
 type Authorisation struct {
-	Authorised bool   `json:"authorised"`
-	Message    string `json:"message"`
+	ID         string          `json:"id"` // Empty if the payment was not authorised
+	Authorised bool            `json:"authorised"`
+	Message    string          `json:"message"`
+	RiskScore  int             `json:"risk_score"` // The payment's fraud score, if it was scored
+	Reasons    []DeclineReason `json:"reasons"`    // Why the payment was declined or, if it was authorised, the risks found in it
 }
 
-// Returns a payment service where any transaction above the preconfigured
-// threshold will return an invalid payment amount
-func NewPaymentService(ctx context.Context, declineOverAmount string) (PaymentService, error) {
-	amount, err := strconv.ParseFloat(declineOverAmount, 32)
+// A reason that a payment was declined, or a risk found in a payment
+type DeclineReason struct {
+	Code    string `json:"code"`    // A code such as ReasonCardExpired
+	Message string `json:"message"` // A description of the reason
+	Score   int    `json:"score"`   // What the reason added to the payment's fraud score, if anything
+}
+
+// The codes of decline reasons
+const (
+	ReasonInvalidCardNumber = "invalid_card_number" // The card's number fails the Luhn check
+	ReasonInvalidExpiry     = "invalid_expiry"      // The card's expiry is not a valid MMYY date
+	ReasonCardExpired       = "card_expired"        // The card's expiry has passed
+	ReasonAmountLimit       = "amount_limit"        // The amount is above the service's threshold
+	ReasonCardVelocity      = "card_velocity"       // The card was used for too many recent payments
+	ReasonCustomerVelocity  = "customer_velocity"   // The customer made too many recent payments
+	ReasonAmountAnomaly     = "amount_anomaly"      // The amount is unusually large for the customer
+	ReasonCountryMismatch   = "country_mismatch"    // The card was issued in a different country from the address
+	ReasonIssuerDeclined    = "issuer_declined"     // The gateway declined the payment
+	ReasonInsufficientFunds = "insufficient_funds"  // The gateway declined the payment for lack of funds
+)
+
+// The statuses of a payment
+const (
+	StatusPending    = "pending"    // The payment is being authorised
+	StatusAuthorised = "authorised" // The payment's amount is held on the card
+	StatusDeclined   = "declined"   // The payment was not authorised
+	StatusCaptured   = "captured"   // The payment's amount was taken from the card
+	StatusVoided     = "voided"     // The payment's authorisation was voided, releasing the held amount
+	StatusRefunded   = "refunded"   // The payment's captured amount was returned to the card
+)
+
+// The types of gateway transaction
+const (
+	TransactionAuthorise = "authorise"
+	TransactionCapture   = "capture"
+	TransactionVoid      = "void"
+	TransactionRefund    = "refund"
+)
+
+// A payment, as recorded in the payment ledger
+type Payment struct {
+	ID           string          // The payment's authorisation ID
+	CustomerID   string          // The customer that made the payment
+	Card         string          // The card's number, masked but for its last four digits
+	CardID       string          // The ID of the card with the user service
+	Amount       money.Money     // The amount of the payment
+	Status       string          // The payment's current status
+	Time         int64           // Unix time in nanoseconds at which the payment was authorised
+	RiskScore    int             // The payment's fraud score, if it was scored
+	Reasons      []DeclineReason // Why the payment was declined, or the risks found in it
+	Transactions []Transaction   // The gateway transactions made for the payment, oldest first
+}
+
+// A transaction with the payment gateway
+type Transaction struct {
+	ID     string // The transaction's ID with the gateway
+	Type   string // The type of the transaction, such as TransactionCapture
+	Amount money.Money
+	Time   int64  // Unix time in nanoseconds at which the transaction was made
+	Error  string // Why the transaction failed, if it did
+}
+
+// Returns a payment service that takes payments through gateway and records them in
+// ledgerDB.  Payments are scored by fraud before they are sent to the gateway.  Any
+// payment above the preconfigured threshold will be declined without being sent to the
+// gateway.  The threshold is money such as 500.00 USD; payments in other currencies are
+// converted into its currency using rates to compare them with it.
+func NewPaymentService(ctx context.Context, gateway Gateway, fraud FraudScorer, ledgerDB backend.NoSQLDatabase, rates money.ExchangeRates, declineOverAmount string) (PaymentService, error) {
+	amount, err := money.Parse(declineOverAmount)
 	if err != nil {
-		return nil, errors_.Errorf("invalid declineOverAmount %v; expected a float32", declineOverAmount)
+		return nil, errors_.Wrapf(err, "invalid declineOverAmount %v", declineOverAmount)
+	}
+	ledger, err := ledgerDB.GetCollection(ctx, "payment_service", "payments")
+	if err != nil {
+		return nil, err
 	}
 	return &paymentImpl{
-		declineOverAmount: float32(amount),
+		declineOverAmount: amount,
+		gateway:           gateway,
+		fraud:             fraud,
+		rates:             rates,
+		ledger:            ledger,
 	}, nil
 }
 
 type paymentImpl struct {
-	declineOverAmount float32
+	declineOverAmount money.Money
+	gateway           Gateway
+	fraud             FraudScorer
+	rates             money.ExchangeRates
+	ledger            backend.NoSQLCollection
 }
 
 var ErrInvalidPaymentAmount = errors.New("invalid payment amount")
+var ErrUnknownAuthorisation = errors.New("unknown authorisation")
+
+// ErrInvalidPaymentStatus is returned when a payment's status does not allow an operation,
+// such as capturing a voided payment.
+var ErrInvalidPaymentStatus = errors.New("invalid payment status")
 
-func (s *paymentImpl) Authorise(ctx context.Context, amount float32) (Authorisation, error) {
-	if amount == 0 {
+func (s *paymentImpl) Authorise(ctx context.Context, customerID string, address user.Address, card user.Card, amount money.Money) (Authorisation, error) {
+	if amount.Amount == 0 {
 		return Authorisation{}, ErrInvalidPaymentAmount
 	}
-	if amount < 0 {
+	if amount.Amount < 0 {
 		return Authorisation{}, ErrInvalidPaymentAmount
 	}
-	if amount <= s.declineOverAmount {
-		return Authorisation{
-			Authorised: true,
-			Message:    "Payment authorised",
-		}, nil
+	converted, err := s.rates.Convert(ctx, amount, s.declineOverAmount.Currency)
+	if err != nil {
+		return Authorisation{}, errors_.Wrapf(err, "unable to authorise payment of %v", amount)
+	}
+
+	payment := Payment{
+		ID:           uuid.NewString(),
+		CustomerID:   customerID,
+		Card:         maskCard(card.LongNum),
+		CardID:       card.ID,
+		Amount:       amount,
+		Status:       StatusPending,
+		Time:         time.Now().UnixNano(),
+		Reasons:      validateCard(card, time.Now()),
+		Transactions: []Transaction{},
+	}
+	if converted.Amount > s.declineOverAmount.Amount {
+		payment.Reasons = append(payment.Reasons, DeclineReason{
+			Code:    ReasonAmountLimit,
+			Message: fmt.Sprintf("amount exceeds %v", s.declineOverAmount),
+		})
+	}
+
+	// Payments that are already declined are not scored, so that they are not counted
+	// in the customer's payment history
+	declined := len(payment.Reasons) > 0
+	if !declined {
+		score, err := s.fraud.Score(ctx, payment.ID, customerID, address, card, amount)
+		if err != nil {
+			return Authorisation{}, errors_.Wrap(err, "unable to score payment")
+		}
+		payment.RiskScore = score.Score
+		payment.Reasons = append(payment.Reasons, score.Reasons...)
+		declined = score.Decline
+	}
+	if payment.Reasons == nil {
+		payment.Reasons = []DeclineReason{}
+	}
+	if declined {
+		payment.Status = StatusDeclined
+		if err := s.ledger.InsertOne(ctx, payment); err != nil {
+			return Authorisation{}, errors_.Wrap(err, "unable to record payment")
+		}
+		return declinedAuthorisation(payment), nil
+	}
+
+	// The payment is recorded before it is sent to the gateway, so that there is a
+	// record of any amount the gateway holds
+	if err := s.ledger.InsertOne(ctx, payment); err != nil {
+		return Authorisation{}, errors_.Wrap(err, "unable to record payment")
+	}
+	err = s.gateway.Authorise(ctx, payment.ID, card, amount)
+	if errors.Is(err, ErrDeclined) || errors.Is(err, ErrInsufficientFunds) {
+		reason := DeclineReason{Code: ReasonIssuerDeclined, Message: err.Error()}
+		if errors.Is(err, ErrInsufficientFunds) {
+			reason.Code = ReasonInsufficientFunds
+		}
+		payment.Reasons = append(payment.Reasons, reason)
+		if err := s.record(ctx, payment, StatusDeclined, newTransaction(payment.ID, TransactionAuthorise, amount, err)); err != nil {
+			return Authorisation{}, err
+		}
+		return declinedAuthorisation(payment), nil
+	} else if err != nil {
+		// The gateway may have authorised the payment without replying, so the
+		// authorisation is voided
+		if err := s.record(ctx, payment, StatusPending, newTransaction(payment.ID, TransactionAuthorise, amount, err)); err != nil {
+			return Authorisation{}, err
+		}
+		if voidErr := s.void(ctx, payment); voidErr != nil {
+			return Authorisation{}, errors_.Wrapf(voidErr, "unable to void payment %v after failing to authorise it (%v)", payment.ID, err)
+		}
+		return Authorisation{}, errors_.Wrapf(err, "unable to authorise payment %v", payment.ID)
+	}
+
+	if err := s.record(ctx, payment, StatusAuthorised, newTransaction(payment.ID, TransactionAuthorise, amount, nil)); err != nil {
+		return Authorisation{}, err
 	}
 	return Authorisation{
-		Authorised: false,
-		Message:    fmt.Sprintf("Payment declined: amount exceeds %.2f", s.declineOverAmount),
+		ID:         payment.ID,
+		Authorised: true,
+		Message:    "Payment authorised",
+		RiskScore:  payment.RiskScore,
+		Reasons:    payment.Reasons,
 	}, nil
 }
+
+// The authorisation of a declined payment, whose message lists the reasons it was
+// declined
+func declinedAuthorisation(payment Payment) Authorisation {
+	messages := make([]string, 0, len(payment.Reasons))
+	for _, reason := range payment.Reasons {
+		messages = append(messages, reason.Message)
+	}
+	return Authorisation{
+		Authorised: false,
+		Message:    "Payment declined: " + strings.Join(messages, "; "),
+		RiskScore:  payment.RiskScore,
+		Reasons:    payment.Reasons,
+	}
+}
+
+// Capture implements PaymentService.
+func (s *paymentImpl) Capture(ctx context.Context, authorisationID string) error {
+	payment, err := s.GetPayment(ctx, authorisationID)
+	if err != nil {
+		return err
+	} else if payment.Status == StatusCaptured {
+		return nil
+	} else if payment.Status != StatusAuthorised {
+		return errors_.Wrapf(ErrInvalidPaymentStatus, "payment %v cannot be captured as it is %v", authorisationID, payment.Status)
+	}
+
+	id := transactionID(authorisationID, TransactionCapture)
+	if err := s.gateway.Capture(ctx, id, authorisationID); err != nil {
+		return s.failed(ctx, payment, newTransaction(id, TransactionCapture, payment.Amount, err), err)
+	}
+	return s.record(ctx, payment, StatusCaptured, newTransaction(id, TransactionCapture, payment.Amount, nil))
+}
+
+// Void implements PaymentService.
+func (s *paymentImpl) Void(ctx context.Context, authorisationID string) error {
+	payment, err := s.GetPayment(ctx, authorisationID)
+	if err != nil {
+		return errors_.Wrapf(err, "unable to void authorisation %v", authorisationID)
+	}
+	switch payment.Status {
+	case StatusVoided, StatusRefunded, StatusDeclined:
+		return nil
+	case StatusCaptured:
+		return errors_.Wrapf(ErrInvalidPaymentStatus, "authorisation %v cannot be voided as it was captured", authorisationID)
+	}
+	return s.void(ctx, payment)
+}
+
+// Refund implements PaymentService.
+func (s *paymentImpl) Refund(ctx context.Context, authorisationID string) error {
+	payment, err := s.GetPayment(ctx, authorisationID)
+	if err != nil {
+		return errors_.Wrapf(err, "unable to refund payment %v", authorisationID)
+	}
+	switch payment.Status {
+	case StatusVoided, StatusRefunded, StatusDeclined:
+		return nil
+	case StatusPending, StatusAuthorised:
+		return s.void(ctx, payment)
+	}
+
+	id := transactionID(authorisationID, TransactionRefund)
+	if err := s.gateway.Refund(ctx, id, authorisationID); err != nil {
+		return s.failed(ctx, payment, newTransaction(id, TransactionRefund, payment.Amount, err), err)
+	}
+	return s.record(ctx, payment, StatusRefunded, newTransaction(id, TransactionRefund, payment.Amount, nil))
+}
+
+// Voids a payment's authorisation with the gateway
+func (s *paymentImpl) void(ctx context.Context, payment Payment) error {
+	id := transactionID(payment.ID, TransactionVoid)
+	if err := s.gateway.Void(ctx, id, payment.ID); err != nil {
+		return s.failed(ctx, payment, newTransaction(id, TransactionVoid, payment.Amount, err), err)
+	}
+	return s.record(ctx, payment, StatusVoided, newTransaction(id, TransactionVoid, payment.Amount, nil))
+}
+
+// GetPayment implements PaymentService.
+func (s *paymentImpl) GetPayment(ctx context.Context, authorisationID string) (Payment, error) {
+	cursor, err := s.ledger.FindOne(ctx, bson.D{{"id", authorisationID}})
+	if err != nil {
+		return Payment{}, err
+	}
+	var payment Payment
+	if found, err := cursor.One(ctx, &payment); err != nil {
+		return Payment{}, err
+	} else if !found {
+		return Payment{}, errors_.Wrapf(ErrUnknownAuthorisation, "no payment %v", authorisationID)
+	}
+	return payment, nil
+}
+
+// Records a gateway transaction for a payment in the ledger, along with the payment's
+// resulting status and its decline reasons.  The status is only updated if it has not
+// changed since the payment was read.
+func (s *paymentImpl) record(ctx context.Context, payment Payment, status string, transaction Transaction) error {
+	filter := bson.D{{"id", payment.ID}, {"status", payment.Status}}
+	update := bson.D{
+		{"$set", bson.D{{"status", status}, {"reasons", payment.Reasons}}},
+		{"$push", bson.D{{"transactions", transaction}}},
+	}
+	if updated, err := s.ledger.UpdateOne(ctx, filter, update); err != nil {
+		return errors_.Wrapf(err, "unable to record %v of payment %v", transaction.Type, payment.ID)
+	} else if updated == 0 {
+		return errors_.Errorf("payment %v changed while it was being updated; it may be retried", payment.ID)
+	}
+	return nil
+}
+
+// Records a gateway transaction for a payment that failed with err, and returns err
+func (s *paymentImpl) failed(ctx context.Context, payment Payment, transaction Transaction, err error) error {
+	if recordErr := s.record(ctx, payment, payment.Status, transaction); recordErr != nil {
+		return errors_.Wrapf(recordErr, "unable to %v payment %v (%v)", transaction.Type, payment.ID, err)
+	}
+	return errors_.Wrapf(err, "unable to %v payment %v", transaction.Type, payment.ID)
+}
+
+func newTransaction(id, transactionType string, amount money.Money, err error) Transaction {
+	transaction := Transaction{ID: id, Type: transactionType, Amount: amount, Time: time.Now().UnixNano()}
+	if err != nil {
+		transaction.Error = err.Error()
+	}
+	return transaction
+}
+
+// A payment has at most one transaction of each type after its authorisation, so their
+// IDs are derived from the payment's, and retrying a transaction repeats the same one
+func transactionID(authorisationID, transactionType string) string {
+	return authorisationID + "-" + transactionType
+}
+
+// Masks all but the last four digits of a card number
+func maskCard(longNum string) string {
+	l := max(len(longNum)-4, 0)
+	return strings.Repeat("*", l) + longNum[l:]
+}
@@ -0,0 +1,231 @@
+package payment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/user"
+	"github.com/blueprint-uservices/blueprint/runtime/plugins/simplenosqldb"
+	"github.com/stretchr/testify/require"
+)
+
+// Unit tests of the payment lifecycle that don't use gotests plugin
+
+var (
+	goodCard     = user.Card{ID: "good", LongNum: "4242424242424242", Expires: "1239"}
+	declinedCard = user.Card{ID: "declined", LongNum: "4000000000000002", Expires: "1239"}
+	limitedCard  = user.Card{ID: "limited", LongNum: "4000000000009995", Expires: "1239"}
+	timeoutCard  = user.Card{ID: "timeout", LongNum: "4000000000000119", Expires: "1239"}
+)
+
+var address = user.Address{Country: "Germany"}
+
+// Returns a whole number of dollars as USD
+func dollars(amount int64) money.Money {
+	return money.Money{Amount: amount * 100, Currency: "USD"}
+}
+
+func newPaymentTest(t *testing.T, fraudRules string) (*paymentImpl, *fakeGateway) {
+	ctx := context.Background()
+	gateway, err := NewFakeGateway(ctx, DefaultGatewayScript)
+	require.NoError(t, err)
+	db, err := simplenosqldb.NewSimpleNoSQLDB(ctx)
+	require.NoError(t, err)
+	fraud, err := NewFraudScorer(ctx, db, fraudRules)
+	require.NoError(t, err)
+	rates, err := money.NewFixedRates(ctx, money.DefaultExchangeRates)
+	require.NoError(t, err)
+	service, err := NewPaymentService(ctx, gateway, fraud, db, rates, "500.00 USD")
+	require.NoError(t, err)
+	return service.(*paymentImpl), gateway.(*fakeGateway)
+}
+
+// The types of a payment's transactions, and whether each failed
+func transactions(t *testing.T, s *paymentImpl, id string) (types []string, failed []bool) {
+	payment, err := s.GetPayment(context.Background(), id)
+	require.NoError(t, err)
+	for _, transaction := range payment.Transactions {
+		types = append(types, transaction.Type)
+		failed = append(failed, transaction.Error != "")
+	}
+	return types, failed
+}
+
+// The codes of the reasons given by an authorisation
+func codes(auth Authorisation) []string {
+	var codes []string
+	for _, reason := range auth.Reasons {
+		codes = append(codes, reason.Code)
+	}
+	return codes
+}
+
+func TestNewFakeGateway(t *testing.T) {
+	ctx := context.Background()
+	for _, script := range []string{"4242 refuse", "4242 funds", "4242 funds 10", "4242 funds -1 USD", "4242 funds 10 XXX", "4242 decline now", "decline"} {
+		_, err := NewFakeGateway(ctx, script)
+		require.Error(t, err, script)
+	}
+	_, err := NewFakeGateway(ctx, "")
+	require.NoError(t, err)
+}
+
+func TestPaymentLifecycle(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newPaymentTest(t, "")
+
+	auth, err := s.Authorise(ctx, "jon", address, goodCard, dollars(100))
+	require.NoError(t, err)
+	require.True(t, auth.Authorised)
+	require.NotEmpty(t, auth.ID)
+
+	payment, err := s.GetPayment(ctx, auth.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusAuthorised, payment.Status)
+	require.Equal(t, "************4242", payment.Card)
+	require.Equal(t, goodCard.ID, payment.CardID)
+	require.Equal(t, dollars(100), payment.Amount)
+
+	// Capturing and refunding again have no effect
+	require.NoError(t, s.Capture(ctx, auth.ID))
+	require.NoError(t, s.Capture(ctx, auth.ID))
+	require.ErrorIs(t, s.Void(ctx, auth.ID), ErrInvalidPaymentStatus)
+	require.NoError(t, s.Refund(ctx, auth.ID))
+	require.NoError(t, s.Refund(ctx, auth.ID))
+	require.ErrorIs(t, s.Capture(ctx, auth.ID), ErrInvalidPaymentStatus)
+
+	payment, err = s.GetPayment(ctx, auth.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusRefunded, payment.Status)
+	types, failed := transactions(t, s, auth.ID)
+	require.Equal(t, []string{TransactionAuthorise, TransactionCapture, TransactionRefund}, types)
+	require.Equal(t, []bool{false, false, false}, failed)
+
+	_, err = s.GetPayment(ctx, "nonexistent")
+	require.ErrorIs(t, err, ErrUnknownAuthorisation)
+	require.ErrorIs(t, s.Void(ctx, "nonexistent"), ErrUnknownAuthorisation)
+}
+
+func TestPaymentRefundUncaptured(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newPaymentTest(t, "")
+
+	// A payment that was never captured is voided instead
+	auth, err := s.Authorise(ctx, "jon", address, goodCard, dollars(100))
+	require.NoError(t, err)
+	require.NoError(t, s.Refund(ctx, auth.ID))
+	require.NoError(t, s.Void(ctx, auth.ID))
+
+	payment, err := s.GetPayment(ctx, auth.ID)
+	require.NoError(t, err)
+	require.Equal(t, StatusVoided, payment.Status)
+	types, _ := transactions(t, s, auth.ID)
+	require.Equal(t, []string{TransactionAuthorise, TransactionVoid}, types)
+}
+
+func TestPaymentDeclined(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newPaymentTest(t, "")
+
+	_, err := s.Authorise(ctx, "jon", address, goodCard, dollars(0))
+	require.ErrorIs(t, err, ErrInvalidPaymentAmount)
+
+	// Declined by the service, without reaching the gateway
+	auth, err := s.Authorise(ctx, "jon", address, goodCard, dollars(1000))
+	require.NoError(t, err)
+	require.False(t, auth.Authorised)
+	require.Empty(t, auth.ID)
+	require.Contains(t, auth.Message, "amount exceeds 500.00 USD")
+	require.Equal(t, []string{ReasonAmountLimit}, codes(auth))
+
+	// Declined by the gateway
+	auth, err = s.Authorise(ctx, "jon", address, declinedCard, dollars(100))
+	require.NoError(t, err)
+	require.False(t, auth.Authorised)
+	require.Empty(t, auth.ID)
+	require.Contains(t, auth.Message, ErrDeclined.Error())
+	require.Equal(t, []string{ReasonIssuerDeclined}, codes(auth))
+}
+
+func TestPaymentInsufficientFunds(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newPaymentTest(t, "")
+
+	first, err := s.Authorise(ctx, "jon", address, limitedCard, dollars(6))
+	require.NoError(t, err)
+	require.True(t, first.Authorised)
+
+	// The first payment holds most of the card's funds
+	second, err := s.Authorise(ctx, "jon", address, limitedCard, dollars(6))
+	require.NoError(t, err)
+	require.False(t, second.Authorised)
+	require.Contains(t, second.Message, ErrInsufficientFunds.Error())
+	require.Equal(t, []string{ReasonInsufficientFunds}, codes(second))
+
+	// Until it is voided
+	require.NoError(t, s.Void(ctx, first.ID))
+	second, err = s.Authorise(ctx, "jon", address, limitedCard, dollars(6))
+	require.NoError(t, err)
+	require.True(t, second.Authorised)
+
+	// Captured funds are returned to the card when refunded
+	require.NoError(t, s.Capture(ctx, second.ID))
+	third, err := s.Authorise(ctx, "jon", address, limitedCard, dollars(6))
+	require.NoError(t, err)
+	require.False(t, third.Authorised)
+	require.NoError(t, s.Refund(ctx, second.ID))
+	third, err = s.Authorise(ctx, "jon", address, limitedCard, dollars(6))
+	require.NoError(t, err)
+	require.True(t, third.Authorised)
+}
+
+func TestPaymentGatewayTimeout(t *testing.T) {
+	ctx := context.Background()
+	s, gateway := newPaymentTest(t, "")
+
+	_, err := s.Authorise(ctx, "jon", address, timeoutCard, dollars(100))
+	require.ErrorIs(t, err, ErrGatewayTimeout)
+
+	// The gateway made the authorisation without replying, so it was voided
+	require.Len(t, gateway.authorisations, 1)
+	for id, auth := range gateway.authorisations {
+		require.Equal(t, StatusVoided, auth.status)
+		payment, err := s.GetPayment(ctx, id)
+		require.NoError(t, err)
+		require.Equal(t, StatusVoided, payment.Status)
+		types, failed := transactions(t, s, id)
+		require.Equal(t, []string{TransactionAuthorise, TransactionVoid}, types)
+		require.Equal(t, []bool{true, false}, failed)
+	}
+}
+
+func TestPaymentInCurrency(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newPaymentTest(t, "")
+	eur := func(amount int64) money.Money { return money.Money{Amount: amount, Currency: "EUR"} }
+
+	// Payments are made in their own currency, and converted to compare them with the
+	// limit; 460.00 EUR is 500.00 USD
+	auth, err := s.Authorise(ctx, "jon", address, goodCard, eur(46000))
+	require.NoError(t, err)
+	require.True(t, auth.Authorised)
+	payment, err := s.GetPayment(ctx, auth.ID)
+	require.NoError(t, err)
+	require.Equal(t, eur(46000), payment.Amount)
+	require.Equal(t, eur(46000), payment.Transactions[0].Amount)
+
+	auth, err = s.Authorise(ctx, "jon", address, goodCard, eur(46001))
+	require.NoError(t, err)
+	require.False(t, auth.Authorised)
+	require.Equal(t, []string{ReasonAmountLimit}, codes(auth))
+
+	// A card's funds do not cover payments in other currencies
+	auth, err = s.Authorise(ctx, "jon", address, limitedCard, eur(100))
+	require.NoError(t, err)
+	require.Equal(t, []string{ReasonInsufficientFunds}, codes(auth))
+
+	// Payments in a currency without an exchange rate cannot be made
+	_, err = s.Authorise(ctx, "jon", address, goodCard, money.Money{Amount: 100, Currency: "SEK"})
+	require.ErrorIs(t, err, money.ErrUnknownCurrency)
+}
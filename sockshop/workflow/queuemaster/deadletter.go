@@ -0,0 +1,179 @@
+package queuemaster
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/shipping"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+// A shipment that the queue master gave up on
+type DeadLetter struct {
+	Shipment shipping.Shipment
+	Attempts int    // The number of attempts made to ship the shipment
+	Error    string // The error of the last attempt
+	Time     int64  // Unix time in nanoseconds at which the shipment was given up on
+}
+
+// An item of the dead-letter queue.  A backend.Queue can only be read by popping it, so
+// the dead-letter queue is read by rotating it: popping each dead letter and pushing it
+// back.  A marker pushed at the start of the rotation tells where the rotation ends.
+type deadLetterItem struct {
+	DeadLetter
+	Marker string
+}
+
+// How long to wait for the dead-letter queue when pushing a dead letter, and for the first
+// dead letter of a rotation, after which the dead-letter queue is taken to be empty
+var deadLetterWait = 500 * time.Millisecond
+
+// How long a rotation of the dead-letter queue may take.  A rotation is not stopped when
+// its caller's context is cancelled, so that it does not stop between popping a dead
+// letter and pushing it back.
+var rotateTimeout = 30 * time.Second
+
+// Pushes a dead letter to the dead-letter queue, retrying until it is pushed or ctx is
+// cancelled
+func (q *queueMasterImpl) deadLetter(ctx context.Context, letter DeadLetter) {
+	for {
+		err := q.pushDeadLetter(ctx, letter)
+		if err == nil {
+			return
+		}
+		slog.Error(fmt.Sprintf("Unable to move shipment %v to the dead-letter queue due to %v; waiting %v then retrying", letter.Shipment.ID, err, maxBackoff))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(maxBackoff):
+		}
+	}
+}
+
+// Dead letters are not pushed during a rotation, so that a rotation never finds the
+// dead-letter queue full when it pushes back a dead letter that it popped
+func (q *queueMasterImpl) pushDeadLetter(ctx context.Context, letter DeadLetter) error {
+	q.rotating.Lock()
+	defer q.rotating.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, deadLetterWait)
+	defer cancel()
+	if pushed, err := q.deadLetters.Push(ctx, deadLetterItem{DeadLetter: letter}); err != nil || !pushed {
+		return orUnavailable(err)
+	}
+	return nil
+}
+
+// Pops every dead letter in the dead-letter queue and passes it to visit, pushing it back
+// to the queue, in the same order, unless visit reports that it has been dealt with.
+func (q *queueMasterImpl) rotate(ctx context.Context, visit func(letter DeadLetter) (done bool)) error {
+	q.rotating.Lock()
+	defer q.rotating.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rotateTimeout)
+	defer cancel()
+
+	// Nothing else pushes to the dead-letter queue during the rotation, so if the first pop
+	// finds it empty, it stays empty.  The marker is pushed after the first pop, and each
+	// dead letter is pushed back after the next pop, so that there is room for them even
+	// if the queue is full.
+	var first deadLetterItem
+	firstCtx, cancelFirst := context.WithTimeout(ctx, deadLetterWait)
+	popped, err := q.deadLetters.Pop(firstCtx, &first)
+	cancelFirst()
+	if err != nil {
+		return errors.Wrap(err, "unable to read the dead-letter queue")
+	} else if !popped {
+		return nil
+	}
+	marker := uuid.NewString()
+	if pushed, err := q.deadLetters.Push(ctx, deadLetterItem{Marker: marker}); err != nil || !pushed {
+		q.lost(first)
+		return errors.Wrap(orUnavailable(err), "unable to read the dead-letter queue")
+	}
+
+	// Markers left by failed rotations are dropped
+	item := first
+	for {
+		var pending *deadLetterItem
+		if item.Marker == "" && !visit(item.DeadLetter) {
+			pending = &item
+		}
+
+		var next deadLetterItem
+		if popped, err := q.deadLetters.Pop(ctx, &next); err != nil || !popped {
+			if pending != nil {
+				q.lost(*pending)
+			}
+			if err == nil {
+				err = errors.Errorf("timed out")
+			}
+			return errors.Wrap(err, "unable to read the dead-letter queue")
+		}
+		if pending != nil {
+			if pushed, err := q.deadLetters.Push(ctx, *pending); err != nil || !pushed {
+				q.lost(*pending)
+				q.lost(next)
+				return errors.Wrapf(orUnavailable(err), "unable to put shipment %v back in the dead-letter queue", pending.Shipment.ID)
+			}
+		}
+		if next.Marker == marker {
+			return nil
+		}
+		item = next
+	}
+}
+
+// A push that timed out without an error means the queue is full or unavailable
+func orUnavailable(err error) error {
+	if err == nil {
+		return errors.Errorf("queue full or unavailable")
+	}
+	return err
+}
+
+// Logs a dead letter that was popped from the dead-letter queue but could not be pushed
+// back, so that it can be recovered from the logs
+func (q *queueMasterImpl) lost(item deadLetterItem) {
+	if item.Marker == "" {
+		slog.Error(fmt.Sprintf("Lost dead letter of shipment %v: %+v", item.Shipment.ID, item.DeadLetter))
+	}
+}
+
+// DeadLetters implements QueueMaster.
+func (q *queueMasterImpl) DeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	var letters []DeadLetter
+	err := q.rotate(ctx, func(letter DeadLetter) bool {
+		letters = append(letters, letter)
+		return false
+	})
+	return letters, err
+}
+
+// ReplayDeadLetter implements QueueMaster.
+func (q *queueMasterImpl) ReplayDeadLetter(ctx context.Context, shipmentID string) error {
+	var found bool
+	var replayErr error
+	err := q.rotate(ctx, func(letter DeadLetter) bool {
+		if found || letter.Shipment.ID != shipmentID {
+			return false
+		}
+		found = true
+		if pushed, err := q.q.Push(ctx, letter.Shipment); err != nil || !pushed {
+			replayErr = orUnavailable(err)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	} else if replayErr != nil {
+		return errors.Wrapf(replayErr, "unable to replay shipment %v", shipmentID)
+	} else if !found {
+		return errors.Errorf("shipment %v is not in the dead-letter queue", shipmentID)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package queuemaster
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/shipping"
+	"github.com/blueprint-uservices/blueprint/runtime/plugins/simplequeue"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// A shipping service that fails to ship some shipments a number of times
+type testShipping struct {
+	shipping.ShippingService
+	mu       sync.Mutex
+	failures map[string]int // Remaining failures of each shipment; -1 fails forever
+	attempts map[string]int
+	shipped  []string
+}
+
+func (s *testShipping) UpdateStatus(ctx context.Context, id string, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts[id]++
+	if s.failures[id] != 0 {
+		if s.failures[id] > 0 {
+			s.failures[id]--
+		}
+		return errors.Errorf("unable to ship %v", id)
+	}
+	s.shipped = append(s.shipped, id)
+	return nil
+}
+
+func (s *testShipping) fail(id string, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[id] = times
+}
+
+func (s *testShipping) state() (attempts map[string]int, shipped []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts = make(map[string]int)
+	for id, n := range s.attempts {
+		attempts[id] = n
+	}
+	return attempts, append([]string(nil), s.shipped...)
+}
+
+// Starts a queue master that makes three attempts to ship a shipment, with short backoffs
+func newDeadLetterTest(t *testing.T) (*queueMasterImpl, *testShipping) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	q, err := simplequeue.NewSimpleQueue(ctx)
+	require.NoError(t, err)
+	deadLetters, err := simplequeue.NewSimpleQueue(ctx)
+	require.NoError(t, err)
+	ship := &testShipping{failures: make(map[string]int), attempts: make(map[string]int)}
+
+	qMaster, err := NewQueueMaster(ctx, q, deadLetters, ship, "3", "1ms")
+	require.NoError(t, err)
+	go qMaster.(*queueMasterImpl).Run(ctx)
+	return qMaster.(*queueMasterImpl), ship
+}
+
+func push(t *testing.T, q *queueMasterImpl, ids ...string) {
+	for _, id := range ids {
+		pushed, err := q.q.Push(context.Background(), shipping.Shipment{ID: id, Name: "jon", Status: shipping.StatusAwaiting})
+		require.NoError(t, err)
+		require.True(t, pushed)
+	}
+}
+
+func deadLetterIDs(t *testing.T, q *queueMasterImpl) []string {
+	letters, err := q.DeadLetters(context.Background())
+	require.NoError(t, err)
+	var ids []string
+	for _, letter := range letters {
+		ids = append(ids, letter.Shipment.ID)
+	}
+	return ids
+}
+
+func TestNewQueueMasterConfig(t *testing.T) {
+	ctx := context.Background()
+	for _, config := range [][2]string{{"0", ""}, {"three", ""}, {"", "0s"}, {"", "soon"}} {
+		_, err := NewQueueMaster(ctx, nil, nil, nil, config[0], config[1])
+		require.Error(t, err, "maxAttempts %q backoff %q", config[0], config[1])
+	}
+
+	q, err := NewQueueMaster(ctx, nil, nil, nil, "", "")
+	require.NoError(t, err)
+	require.Equal(t, 10, q.(*queueMasterImpl).maxAttempts)
+	require.Equal(t, 100*time.Millisecond, q.(*queueMasterImpl).backoff)
+}
+
+func TestQueueMasterRetries(t *testing.T) {
+	q, ship := newDeadLetterTest(t)
+	ship.fail("first", 2)
+	push(t, q, "first")
+
+	require.Eventually(t, func() bool {
+		_, shipped := ship.state()
+		return len(shipped) == 1
+	}, time.Second, time.Millisecond)
+	attempts, _ := ship.state()
+	require.Equal(t, 3, attempts["first"])
+	require.Empty(t, deadLetterIDs(t, q))
+}
+
+func TestQueueMasterDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	q, ship := newDeadLetterTest(t)
+
+	// A shipment that can't be shipped doesn't hold up those behind it
+	ship.fail("poison", -1)
+	push(t, q, "poison", "second")
+	require.Eventually(t, func() bool {
+		_, shipped := ship.state()
+		return len(shipped) == 1
+	}, time.Second, time.Millisecond)
+	attempts, shipped := ship.state()
+	require.Equal(t, 3, attempts["poison"])
+	require.Equal(t, []string{"second"}, shipped)
+
+	letters, err := q.DeadLetters(ctx)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	require.Equal(t, "poison", letters[0].Shipment.ID)
+	require.Equal(t, 3, letters[0].Attempts)
+	require.Contains(t, letters[0].Error, "unable to ship poison")
+
+	// Listing leaves the dead letters in the queue
+	require.Equal(t, []string{"poison"}, deadLetterIDs(t, q))
+
+	// Once the problem is fixed, the shipment can be replayed
+	require.Error(t, q.ReplayDeadLetter(ctx, "second"))
+	ship.fail("poison", 0)
+	require.NoError(t, q.ReplayDeadLetter(ctx, "poison"))
+	require.Eventually(t, func() bool {
+		_, shipped := ship.state()
+		return len(shipped) == 2
+	}, time.Second, time.Millisecond)
+	require.Empty(t, deadLetterIDs(t, q))
+	require.Error(t, q.ReplayDeadLetter(ctx, "poison"))
+}
+
+func TestQueueMasterDeadLetterOrder(t *testing.T) {
+	ctx := context.Background()
+	q, ship := newDeadLetterTest(t)
+
+	// Fill the dead-letter queue
+	var ids []string
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprintf("poison%v", i)
+		ship.fail(id, -1)
+		ids = append(ids, id)
+	}
+	push(t, q, ids...)
+	require.Eventually(t, func() bool {
+		attempts, _ := ship.state()
+		return attempts[ids[9]] == 3
+	}, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool { return len(deadLetterIDs(t, q)) == 10 }, time.Second, time.Millisecond)
+	require.Equal(t, ids, deadLetterIDs(t, q))
+
+	// Replaying a shipment leaves the others in order
+	ship.fail("poison4", 0)
+	require.NoError(t, q.ReplayDeadLetter(ctx, "poison4"))
+	require.Equal(t, append(append([]string{}, ids[:4]...), ids[5:]...), deadLetterIDs(t, q))
+}
@@ -5,36 +5,91 @@ package queuemaster
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/shipping"
 	"github.com/blueprint-uservices/blueprint/runtime/core/backend"
+	"github.com/pkg/errors"
 	"golang.org/x/exp/slog"
 )
 
 // QueueMaster implements the SockShop queue-master microservice.
 //
-// It is not a service that can be called; instead it pulls shipments from
-// the shipments queue
+// The queue master pulls shipments from the shipments queue in the background, and
+// ships each by updating its status with the shipping service.  It retries a shipment
+// that it cannot ship, backing off exponentially between attempts, and after a maximum
+// number of attempts gives up on the shipment and moves it to a dead-letter queue, so
+// that a shipment that can never be shipped does not hold up those behind it.
+//
+// New for Blueprint: the queue master can be called to list and replay the shipments in
+// its dead-letter queue.  The processing loop is not part of the interface, so that the
+// queue master can be deployed behind an RPC server; Blueprint runs it when the queue
+// master is instantiated.
 type QueueMaster interface {
-	// Runs the background goroutine that continually pulls elements from
-	// the queue.  Does not return until ctx is cancelled or an error is
-	// encountered
-	Run(ctx context.Context) error
+	// Lists the shipments that the queue master has given up on, in the order it gave
+	// up on them.
+	DeadLetters(ctx context.Context) ([]DeadLetter, error)
+
+	// Takes the shipment shipmentID out of the dead-letter queue and pushes it back to
+	// the shipments queue, to be shipped afresh with a new set of attempts.  Returns an
+	// error if the shipment is not in the dead-letter queue.
+	ReplayDeadLetter(ctx context.Context, shipmentID string) error
 }
 
+// The default number of attempts that the queue master makes to ship a shipment before
+// moving it to the dead-letter queue
+const DefaultMaxAttempts = "10"
+
+// The default delay after the first failed attempt to ship a shipment.  The delay doubles
+// after each further failed attempt, up to 10 seconds.
+const DefaultBackoff = "100ms"
+
+// The longest delay between attempts to ship a shipment
+var maxBackoff = 10 * time.Second
+
 // Creates a new QueueMaster service.
 //
 // New: once an order is shipped, it will update the order status in the orderservice.
-func NewQueueMaster(ctx context.Context, queue backend.Queue, shipping shipping.ShippingService) (QueueMaster, error) {
-	return newQueueMasterImpl(queue, shipping, false), nil
+//
+// New for Blueprint: shipments that cannot be shipped after maxAttempts attempts are
+// moved to deadLetters.  The first retry is after backoff, which is a duration such as
+// "100ms", and the delay doubles with each retry.  An empty maxAttempts or backoff
+// means [DefaultMaxAttempts] or [DefaultBackoff] respectively.  The queue master must be
+// the only consumer of deadLetters.
+func NewQueueMaster(ctx context.Context, queue backend.Queue, deadLetters backend.Queue, shipping shipping.ShippingService, maxAttempts string, backoff string) (QueueMaster, error) {
+	q := newQueueMasterImpl(queue, deadLetters, shipping, false)
+	if maxAttempts == "" {
+		maxAttempts = DefaultMaxAttempts
+	}
+	if backoff == "" {
+		backoff = DefaultBackoff
+	}
+	var err error
+	if q.maxAttempts, err = strconv.Atoi(maxAttempts); err != nil {
+		return nil, errors.Wrapf(err, "invalid maxAttempts %v", maxAttempts)
+	} else if q.maxAttempts <= 0 {
+		return nil, errors.Errorf("invalid maxAttempts %v; at least one attempt is needed", maxAttempts)
+	}
+	if q.backoff, err = time.ParseDuration(backoff); err != nil {
+		return nil, errors.Wrapf(err, "invalid backoff %v", backoff)
+	} else if q.backoff <= 0 {
+		return nil, errors.Errorf("invalid backoff %v; it must be positive", backoff)
+	}
+	return q, nil
 }
 
-func newQueueMasterImpl(queue backend.Queue, shipping shipping.ShippingService, exitOnError bool) *queueMasterImpl {
+func newQueueMasterImpl(queue backend.Queue, deadLetters backend.Queue, shipping shipping.ShippingService, exitOnError bool) *queueMasterImpl {
+	maxAttempts, _ := strconv.Atoi(DefaultMaxAttempts)
+	backoff, _ := time.ParseDuration(DefaultBackoff)
 	return &queueMasterImpl{
 		q:           queue,
+		deadLetters: deadLetters,
 		shipping:    shipping,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
 		exitOnError: exitOnError,
 		processed:   0,
 	}
@@ -42,13 +97,19 @@ func newQueueMasterImpl(queue backend.Queue, shipping shipping.ShippingService,
 
 type queueMasterImpl struct {
 	q           backend.Queue
+	deadLetters backend.Queue
 	shipping    shipping.ShippingService
+	maxAttempts int
+	backoff     time.Duration
 	exitOnError bool
 	processed   int32
+	rotating    sync.Mutex // Held while the dead-letter queue is rotated
 }
 
 // Starts a processing loop that continually pulls elements from the queue.
-// Does not exit when an error is encountered; only when ctx is cancelled
+// Does not exit when an error is encountered; only when ctx is cancelled.
+//
+// Blueprint calls Run in a separate goroutine when the queue master is instantiated.
 func (q *queueMasterImpl) Run(ctx context.Context) error {
 	for {
 		select {
@@ -69,21 +130,37 @@ func (q *queueMasterImpl) Run(ctx context.Context) error {
 				msgNumber := atomic.AddInt32(&q.processed, 1)
 				slog.Info(fmt.Sprintf("Received shipment task %v %v: %v", msgNumber, shipment.ID, shipment.Name))
 
-				// Keep attempting to update shipping status
-				for {
-					err := q.shipping.UpdateStatus(ctx, shipment.ID, "shipped")
-					if err != nil {
-						if q.exitOnError {
-							return err
-						} else {
-							slog.Error(fmt.Sprintf("Unable to send shipment %v due to %v; waiting 1 second then retrying", shipment.ID, err))
-							time.Sleep(1 * time.Second)
-						}
-					} else {
-						break
-					}
+				if err := q.ship(ctx, shipment); err != nil {
+					return err
 				}
 			}
 		}
 	}
 }
+
+// Attempts to ship a shipment up to maxAttempts times, backing off exponentially between
+// attempts, then moves it to the dead-letter queue if every attempt failed.  Returns an
+// error only if exitOnError is set; otherwise returns once the shipment is shipped or
+// dead-lettered, or ctx is cancelled.
+func (q *queueMasterImpl) ship(ctx context.Context, shipment shipping.Shipment) error {
+	backoff := q.backoff
+	for attempt := 1; ; attempt++ {
+		err := q.shipping.UpdateStatus(ctx, shipment.ID, shipping.StatusShipped)
+		if err == nil {
+			return nil
+		} else if q.exitOnError {
+			return err
+		} else if attempt >= q.maxAttempts {
+			slog.Error(fmt.Sprintf("Unable to send shipment %v due to %v; giving up after %v attempts and moving it to the dead-letter queue", shipment.ID, err, attempt))
+			q.deadLetter(ctx, DeadLetter{Shipment: shipment, Attempts: attempt, Error: err.Error(), Time: time.Now().UnixNano()})
+			return nil
+		}
+		slog.Error(fmt.Sprintf("Unable to send shipment %v due to %v; waiting %v then retrying", shipment.ID, err, backoff))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
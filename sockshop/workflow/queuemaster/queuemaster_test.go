@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/shipping"
+	"github.com/blueprint-uservices/blueprint/runtime/plugins/golang"
 	"github.com/blueprint-uservices/blueprint/runtime/plugins/simplenosqldb"
 	"github.com/blueprint-uservices/blueprint/runtime/plugins/simplequeue"
 	"github.com/stretchr/testify/require"
@@ -20,21 +21,20 @@ func TestQueueMaster(t *testing.T) {
 	q, err := simplequeue.NewSimpleQueue(ctx)
 	require.NoError(t, err)
 
+	events, err := simplequeue.NewSimpleQueue(ctx)
+	require.NoError(t, err)
+
 	db, err := simplenosqldb.NewSimpleNoSQLDB(ctx)
 	require.NoError(t, err)
 
-	shipService, err := shipping.NewShippingService(ctx, q, db)
+	shipService, err := shipping.NewShippingService(ctx, q, events, db)
 	require.NoError(t, err)
 
-	qMaster := newQueueMasterImpl(q, shipService, true)
-	require.Equal(t, int32(0), qMaster.processed)
+	deadLetters, err := simplequeue.NewSimpleQueue(ctx)
+	require.NoError(t, err)
 
-	exitCount := int32(0)
-	go func() {
-		err = qMaster.Run(ctx)
-		require.NoError(t, err)
-		atomic.AddInt32(&exitCount, 1)
-	}()
+	qMaster := newQueueMasterImpl(q, deadLetters, shipService, true)
+	require.Equal(t, int32(0), qMaster.processed)
 
 	shipment := shipping.Shipment{
 		ID:     "first",
@@ -44,10 +44,37 @@ func TestQueueMaster(t *testing.T) {
 	_, err = shipService.PostShipping(ctx, shipment)
 	require.NoError(t, err)
 
+	// Relay the shipment to the queue, and stop relaying before the queue master updates
+	// the shipment, as the simple NoSQL DB can't be used by both at once
+	relayCtx, stopRelay := context.WithCancel(ctx)
+	relayed := make(chan struct{})
+	go func() {
+		shipService.(golang.Runnable).Run(relayCtx)
+		close(relayed)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	stopRelay()
+	<-relayed
+
+	exitCount := int32(0)
+	go func() {
+		err := qMaster.Run(ctx)
+		require.NoError(t, err)
+		atomic.AddInt32(&exitCount, 1)
+	}()
+
 	time.Sleep(10 * time.Millisecond)
 
 	require.Equal(t, int32(1), atomic.LoadInt32(&qMaster.processed))
 
+	// The shipping service published the update
+	var event shipping.StatusEvent
+	popped, err := events.Pop(ctx, &event)
+	require.NoError(t, err)
+	require.True(t, popped)
+	require.Equal(t, shipment.ID, event.ShipmentID)
+	require.Equal(t, "shipped", event.Status)
+
 	shipment2, err := shipService.GetShipment(ctx, shipment.ID)
 	require.NoError(t, err)
 	require.NotEqual(t, shipment, shipment2)
@@ -0,0 +1,85 @@
+package shipping
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/exp/slog"
+)
+
+// How often the relay checks the outbox for shipments that it has not yet published,
+// in case it was not woken when they were posted or could not publish them
+var relayInterval = 1 * time.Second
+
+// A shipment as stored in the shipment DB.  The DB has no transactions that span
+// documents, so a shipment's outbox record is kept in the shipment's own document,
+// and the two are stored together by a single insert.
+type shipmentRecord struct {
+	Shipment `bson:",inline"`
+	Outbox   bool  // Whether the shipment is in the outbox, waiting to be published to the shipping queue
+	Posted   int64 // Unix time in nanoseconds at which the shipment was posted
+}
+
+// Starts the relay, which publishes shipments from the outbox to the shipping queue
+// whenever a shipment is posted, and every relayInterval.  Does not exit when an error is
+// encountered; only when ctx is cancelled.
+//
+// Blueprint calls Run in a separate goroutine when the shipping service is instantiated,
+// and again for each client of the service in the same process, which is the same
+// instance; Run returns immediately if it is already running.
+func (s *shippingImpl) Run(ctx context.Context) error {
+	if !s.running.CompareAndSwap(false, true) {
+		return nil
+	}
+	defer s.running.Store(false)
+
+	for {
+		if err := s.relay(ctx); err != nil {
+			slog.Error(fmt.Sprintf("Unable to relay shipments to the shipping queue; retrying in %v: %v", relayInterval, err))
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.posted:
+		case <-time.After(relayInterval):
+		}
+	}
+}
+
+// Publishes the shipments in the outbox to the shipping queue in the order they were
+// posted, taking each out of the outbox once it is published.  A shipment is published
+// again if it cannot be taken out of the outbox.  Shipments cancelled before they are
+// published are taken out of the outbox without being published.
+func (s *shippingImpl) relay(ctx context.Context) error {
+	s.relaying.Lock()
+	defer s.relaying.Unlock()
+
+	cursor, err := s.db.FindMany(ctx, bson.D{{"outbox", true}})
+	if err != nil {
+		return err
+	}
+	var records []shipmentRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return err
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Posted < records[j].Posted })
+
+	for _, record := range records {
+		if record.Status != StatusCancelled {
+			pushed, err := s.q.Push(ctx, record.Shipment)
+			if err != nil {
+				return errors.Wrapf(err, "unable to publish shipment %v", record.ID)
+			} else if !pushed {
+				return errors.Errorf("unable to publish shipment %v", record.ID)
+			}
+		}
+		if _, err := s.db.UpdateOne(ctx, bson.D{{"id", record.ID}}, bson.D{{"$set", bson.D{{"outbox", false}}}}); err != nil {
+			return errors.Wrapf(err, "unable to take shipment %v out of the outbox", record.ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package shipping
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blueprint-uservices/blueprint/runtime/core/backend"
+	"github.com/blueprint-uservices/blueprint/runtime/plugins/simplenosqldb"
+	"github.com/blueprint-uservices/blueprint/runtime/plugins/simplequeue"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Unit tests of the shipping outbox that don't use gotests plugin
+
+// A shipping queue that is unavailable on demand
+type testQueue struct {
+	backend.Queue
+	fail atomic.Bool
+}
+
+func (q *testQueue) Push(ctx context.Context, item interface{}) (bool, error) {
+	if q.fail.Load() {
+		return false, errors.Errorf("queue unavailable")
+	}
+	return q.Queue.Push(ctx, item)
+}
+
+func newOutboxTest(t *testing.T) (*shippingImpl, *testQueue) {
+	ctx := context.Background()
+	queue, err := simplequeue.NewSimpleQueue(ctx)
+	require.NoError(t, err)
+	events, err := simplequeue.NewSimpleQueue(ctx)
+	require.NoError(t, err)
+	db, err := simplenosqldb.NewSimpleNoSQLDB(ctx)
+	require.NoError(t, err)
+	q := &testQueue{Queue: queue}
+	service, err := NewShippingService(ctx, q, events, db)
+	require.NoError(t, err)
+	return service.(*shippingImpl), q
+}
+
+// Runs the relay until the returned function is called, which stops it and waits for it
+// to return, so that the test can then use the DB without racing it
+func run(s *shippingImpl) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// Pops the shipments published to the queue
+func published(t *testing.T, q *testQueue) []Shipment {
+	var shipments []Shipment
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		var shipment Shipment
+		popped, err := q.Pop(ctx, &shipment)
+		cancel()
+		require.NoError(t, err)
+		if !popped {
+			return shipments
+		}
+		shipments = append(shipments, shipment)
+	}
+}
+
+// Reports whether a shipment is in the outbox
+func inOutbox(t *testing.T, s *shippingImpl, id string) bool {
+	cursor, err := s.db.FindOne(context.Background(), bson.D{{"id", id}})
+	require.NoError(t, err)
+	var record shipmentRecord
+	found, err := cursor.One(context.Background(), &record)
+	require.NoError(t, err)
+	require.True(t, found)
+	return record.Outbox
+}
+
+func TestOutboxRelaysShipment(t *testing.T) {
+	ctx := context.Background()
+	s, q := newOutboxTest(t)
+	shipment := Shipment{ID: "first", Name: "jon", Status: StatusAwaiting}
+
+	posted, err := s.PostShipping(ctx, shipment)
+	require.NoError(t, err)
+	require.Equal(t, shipment, posted)
+	stop := run(s)
+	require.Equal(t, []Shipment{shipment}, published(t, q))
+	stop()
+	require.False(t, inOutbox(t, s, shipment.ID))
+
+	// Posting again has no effect
+	_, err = s.PostShipping(ctx, shipment)
+	require.NoError(t, err)
+	require.Empty(t, published(t, q))
+}
+
+func TestOutboxRunOnce(t *testing.T) {
+	ctx := context.Background()
+	s, q := newOutboxTest(t)
+	_, err := s.PostShipping(ctx, Shipment{ID: "first", Name: "jon", Status: StatusAwaiting})
+	require.NoError(t, err)
+	stop := run(s)
+	defer stop()
+	require.Len(t, published(t, q), 1)
+
+	// Blueprint also runs the service for each client of it in the same process, which
+	// returns at once rather than running a second relay
+	require.NoError(t, s.Run(ctx))
+}
+
+func TestOutboxQueueUnavailable(t *testing.T) {
+	ctx := context.Background()
+	s, q := newOutboxTest(t)
+	q.fail.Store(true)
+
+	// The shipment is stored even though it can't be published yet
+	shipment := Shipment{ID: "first", Name: "jon", Status: StatusAwaiting}
+	_, err := s.PostShipping(ctx, shipment)
+	require.NoError(t, err)
+	stored, err := s.GetShipment(ctx, shipment.ID)
+	require.NoError(t, err)
+	require.Equal(t, shipment, stored)
+	require.Empty(t, published(t, q))
+	require.True(t, inOutbox(t, s, shipment.ID))
+
+	// Once the queue is available, the shipment is published, once
+	q.fail.Store(false)
+	require.NoError(t, s.relay(ctx))
+	require.NoError(t, s.relay(ctx))
+	require.Equal(t, []Shipment{shipment}, published(t, q))
+	require.False(t, inOutbox(t, s, shipment.ID))
+}
+
+func TestOutboxCancelledShipment(t *testing.T) {
+	ctx := context.Background()
+	s, q := newOutboxTest(t)
+	q.fail.Store(true)
+
+	// A shipment cancelled before it is published is never published
+	_, err := s.PostShipping(ctx, Shipment{ID: "first", Name: "jon", Status: StatusAwaiting})
+	require.NoError(t, err)
+	require.NoError(t, s.CancelShipment(ctx, "first"))
+	q.fail.Store(false)
+	require.NoError(t, s.relay(ctx))
+	require.Empty(t, published(t, q))
+	require.False(t, inOutbox(t, s, "first"))
+
+	// Nor is a shipment cancelled before it is posted
+	require.NoError(t, s.CancelShipment(ctx, "second"))
+	posted, err := s.PostShipping(ctx, Shipment{ID: "second", Name: "jon", Status: StatusAwaiting})
+	require.NoError(t, err)
+	require.Equal(t, StatusCancelled, posted.Status)
+	require.Empty(t, published(t, q))
+}
@@ -1,12 +1,20 @@
 // Package shipping implements the SockShop shipping microservice.
 //
 // All the shipping microservice does is push the shipment to a queue.
-// The queue-master service pulls shipments from the queue and "processes"
-// them.
+// Shipments are pushed through a transactional outbox: each shipment is
+// stored along with an outbox record, and a relay publishes the shipments
+// in the outbox to the queue, so that a shipment is published if and only
+// if it was stored.  The queue-master service pulls shipments from the
+// queue and "processes" them.  Each time a shipment's status changes, the shipping service
+// publishes a [StatusEvent] to a second queue, so that the order service
+// can track the orders being shipped.
 package shipping
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/blueprint-uservices/blueprint/runtime/core/backend"
 	"github.com/pkg/errors"
@@ -15,8 +23,12 @@ import (
 
 // ShippingService implements the SockShop shipping microservice
 type ShippingService interface {
-	// Submit a shipment to be shipped.  The actual handling of the
+	// Submit a shipment to be shipped.  The shipment is stored, then published
+	// to the shipping queue in the background; the actual handling of the
 	// shipment will happen asynchronously by the queue-master service.
+	// Shipments are published at least once, and may be published more than once.
+	//
+	// Posting a shipment that was already posted or cancelled has no effect.
 	//
 	// Returns the submitted shipment or an error
 	PostShipping(ctx context.Context, shipment Shipment) (Shipment, error)
@@ -24,10 +36,28 @@ type ShippingService interface {
 	// Get a shipment's status
 	GetShipment(ctx context.Context, id string) (Shipment, error)
 
-	// Update a shipment's status; called by the queue master
+	// Update a shipment's status; called by the queue master.  The status of a
+	// cancelled shipment is not updated.
+	//
+	// A [StatusEvent] is published for the update; if it cannot be published, an
+	// error is returned and the update should be retried.
 	UpdateStatus(ctx context.Context, id, status string) error
+
+	// Cancel a shipment.  Used to compensate a shipment when an order cannot be
+	// completed; a shipment that was already shipped is recalled, and a shipment
+	// that has not been posted yet will not be shipped.  Cancelling a shipment that
+	// was already cancelled has no effect.
+	CancelShipment(ctx context.Context, id string) error
 }
 
+// The statuses of a shipment
+const (
+	StatusAwaiting  = "awaiting shipment"
+	StatusShipped   = "shipped"
+	StatusDelivered = "delivered"
+	StatusCancelled = "cancelled"
+)
+
 // Represents a shipment for an order
 type Shipment struct {
 	ID     string
@@ -35,42 +65,64 @@ type Shipment struct {
 	Status string
 }
 
-// Instantiates a shipping service that submits all shipments to a queue for asynchronous background processing
-func NewShippingService(ctx context.Context, queue backend.Queue, db backend.NoSQLDatabase) (ShippingService, error) {
+// Published each time a shipment's status changes.  Events are delivered at least
+// once, and may be delivered more than once.
+type StatusEvent struct {
+	ShipmentID string
+	Status     string
+	Time       int64 // Unix time in nanoseconds of the change
+}
+
+// Instantiates a shipping service that submits all shipments to a queue for asynchronous background processing,
+// and publishes a [StatusEvent] to the events queue whenever a shipment's status changes.
+// Shipments are relayed from the outbox in db to queue while the service runs.
+func NewShippingService(ctx context.Context, queue backend.Queue, events backend.Queue, db backend.NoSQLDatabase) (ShippingService, error) {
 	c, err := db.GetCollection(ctx, "shipping_service", "shipments")
+	if err != nil {
+		return nil, err
+	}
 	return &shippingImpl{
-		q:  queue,
-		db: c,
-	}, err
+		q:      queue,
+		events: events,
+		db:     c,
+		posted: make(chan struct{}, 1),
+	}, nil
 }
 
 type shippingImpl struct {
-	q  backend.Queue
-	db backend.NoSQLCollection
+	q      backend.Queue
+	events backend.Queue
+	db     backend.NoSQLCollection
+
+	posted   chan struct{} // Wakes the relay when a shipment is posted
+	relaying sync.Mutex    // Held while relaying, so that shipments are relayed one at a time
+	running  atomic.Bool   // Whether Run is running
 }
 
 // PostShipping implements ShippingService.
-func (service *shippingImpl) PostShipping(ctx context.Context, shipment Shipment) (Shipment, error) {
-	// Push to the queue to be shipped
-	shipped, err := service.q.Push(ctx, shipment)
+func (s *shippingImpl) PostShipping(ctx context.Context, shipment Shipment) (Shipment, error) {
+	// A shipment that is already stored was posted before, or cancelled before it was posted
+	existing, exists, err := s.findShipment(ctx, shipment.ID)
 	if err != nil {
-		return shipment, err
-	} else if !shipped {
-		return shipment, errors.Errorf("Unable to submit shipment %v %v to the shipping queue", shipment.ID, shipment.Name)
+		return Shipment{}, err
+	} else if exists {
+		return existing, nil
 	}
 
-	// Insert into the shipment DB
-	return shipment, service.db.InsertOne(ctx, shipment)
+	// Store the shipment with its outbox record, then wake the relay to publish it
+	if err := s.db.InsertOne(ctx, shipmentRecord{Shipment: shipment, Outbox: true, Posted: time.Now().UnixNano()}); err != nil {
+		return Shipment{}, err
+	}
+	select {
+	case s.posted <- struct{}{}:
+	default:
+	}
+	return shipment, nil
 }
 
 // GetShipment implements ShippingService.
 func (s *shippingImpl) GetShipment(ctx context.Context, id string) (Shipment, error) {
-	cursor, err := s.db.FindOne(ctx, bson.D{{"id", id}})
-	if err != nil {
-		return Shipment{}, err
-	}
-	var shipment Shipment
-	shipmentExists, err := cursor.One(ctx, &shipment)
+	shipment, shipmentExists, err := s.findShipment(ctx, id)
 	if err != nil {
 		return Shipment{}, err
 	} else if !shipmentExists {
@@ -79,13 +131,56 @@ func (s *shippingImpl) GetShipment(ctx context.Context, id string) (Shipment, er
 	return shipment, nil
 }
 
+// Looks up a shipment, reporting whether it exists
+func (s *shippingImpl) findShipment(ctx context.Context, id string) (Shipment, bool, error) {
+	cursor, err := s.db.FindOne(ctx, bson.D{{"id", id}})
+	if err != nil {
+		return Shipment{}, false, err
+	}
+	var shipment Shipment
+	exists, err := cursor.One(ctx, &shipment)
+	return shipment, exists, err
+}
+
 // UpdateStatus implements ShippingService.
 func (s *shippingImpl) UpdateStatus(ctx context.Context, id string, status string) error {
+	shipment, err := s.GetShipment(ctx, id)
+	if err != nil {
+		return err
+	} else if shipment.Status == StatusCancelled {
+		return nil
+	}
 	updated, err := s.db.UpdateOne(ctx, bson.D{{"id", id}}, bson.D{{"$set", bson.D{{"status", status}}}})
 	if err != nil {
 		return err
 	} else if updated == 0 {
 		return errors.Errorf("unknown shipment %v", id)
 	}
+	return s.publish(ctx, id, status)
+}
+
+// CancelShipment implements ShippingService.
+func (s *shippingImpl) CancelShipment(ctx context.Context, id string) error {
+	updated, err := s.db.UpdateOne(ctx, bson.D{{"id", id}}, bson.D{{"$set", bson.D{{"status", StatusCancelled}}}})
+	if err != nil {
+		return err
+	} else if updated == 0 {
+		// The shipment may still be on its way to the queue; record it as cancelled so
+		// that it is not shipped when it arrives
+		if err := s.db.InsertOne(ctx, Shipment{ID: id, Status: StatusCancelled}); err != nil {
+			return err
+		}
+	}
+	return s.publish(ctx, id, StatusCancelled)
+}
+
+// Publishes a status event for the shipment
+func (s *shippingImpl) publish(ctx context.Context, id, status string) error {
+	pushed, err := s.events.Push(ctx, StatusEvent{ShipmentID: id, Status: status, Time: time.Now().UnixNano()})
+	if err != nil {
+		return err
+	} else if !pushed {
+		return errors.Errorf("unable to publish status %v of shipment %v", status, id)
+	}
 	return nil
 }
@@ -0,0 +1,149 @@
+// Package token implements a token service that issues and validates the session tokens
+// with which the SockShop frontend identifies its customers' sessions.
+//
+// Session tokens are JSON Web Tokens signed with HMAC-SHA256 under a key held by the
+// token service.  A token can therefore be validated without looking the session up,
+// and cannot be forged or altered by a customer.
+package token
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// TokenService issues signed, expiring tokens for sessions, and validates them.
+	TokenService interface {
+		// Issues a token for session, which expires after the service's token lifetime.
+		// session.Expires is ignored.
+		Issue(ctx context.Context, session Session) (string, error)
+
+		// Validates a token issued by the service, and returns its session.  Returns an
+		// error wrapping [ErrInvalidToken] if the token is malformed, was not signed by
+		// the service, or has expired.
+		Validate(ctx context.Context, token string) (Session, error)
+	}
+
+	// A customer's session, who may or may not be logged in
+	Session struct {
+		ID      string // The session's ID, under which the cart of a customer who isn't logged in is kept
+		UserID  string // The ID of the logged in user, or empty if the customer isn't logged in
+		Expires int64  // Unix time in seconds at which the session's token expires
+	}
+)
+
+// ErrInvalidToken is returned when validating a token that is malformed, forged, or expired.
+var ErrInvalidToken = errors.New("invalid session token")
+
+// The lifetime of tokens issued by the token service if none is specified
+const DefaultTokenLifetime = "24h"
+
+// The header of every token; tokens are only ever signed with HMAC-SHA256
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// The claims of a token
+type claims struct {
+	Subject   string `json:"sub,omitempty"` // The logged in user
+	SessionID string `json:"sid"`
+	IssuedAt  int64  `json:"iat"`
+	Expires   int64  `json:"exp"`
+}
+
+// Implementation of [TokenService]
+type tokenService struct {
+	key      []byte
+	lifetime time.Duration
+}
+
+// Creates a [TokenService] that signs tokens with signingKey, which must be at least 32
+// bytes.  If signingKey is empty, a random key is generated when the service is created,
+// so that tokens are only valid for the instance of the service that issued them; they
+// are not accepted by other replicas of the service, nor after it restarts.
+//
+// Tokens expire after lifetime, a duration such as "24h" as accepted by
+// [time.ParseDuration]; [DefaultTokenLifetime] is used if lifetime is empty.
+func NewTokenService(ctx context.Context, signingKey string, lifetime string) (TokenService, error) {
+	if lifetime == "" {
+		lifetime = DefaultTokenLifetime
+	}
+	duration, err := time.ParseDuration(lifetime)
+	if err != nil || duration < time.Second {
+		return nil, errors.Errorf("invalid token lifetime %q; expected a duration of at least 1s", lifetime)
+	}
+
+	key := []byte(signingKey)
+	if signingKey == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, errors.Wrap(err, "unable to generate a signing key")
+		}
+	} else if len(key) < 32 {
+		return nil, errors.Errorf("signing key is %v bytes; expected at least 32", len(key))
+	}
+	return &tokenService{key: key, lifetime: duration}, nil
+}
+
+// Issue implements TokenService.
+func (t *tokenService) Issue(ctx context.Context, session Session) (string, error) {
+	return t.issue(session, time.Now())
+}
+
+// Validate implements TokenService.
+func (t *tokenService) Validate(ctx context.Context, token string) (Session, error) {
+	return t.validate(token, time.Now())
+}
+
+func (t *tokenService) issue(session Session, now time.Time) (string, error) {
+	if session.ID == "" {
+		return "", errors.New("cannot issue a token for a session without an ID")
+	}
+	payload, err := json.Marshal(claims{
+		Subject:   session.UserID,
+		SessionID: session.ID,
+		IssuedAt:  now.Unix(),
+		Expires:   now.Add(t.lifetime).Unix(),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "unable to encode token")
+	}
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + t.sign(signed), nil
+}
+
+func (t *tokenService) validate(token string, now time.Time) (Session, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != header {
+		return Session{}, errors.Wrap(ErrInvalidToken, "malformed token")
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(t.sign(parts[0]+"."+parts[1]))) {
+		return Session{}, errors.Wrap(ErrInvalidToken, "bad signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Session{}, errors.Wrap(ErrInvalidToken, "malformed claims")
+	}
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil || c.SessionID == "" {
+		return Session{}, errors.Wrap(ErrInvalidToken, "malformed claims")
+	}
+	if now.Unix() >= c.Expires {
+		return Session{}, errors.Wrap(ErrInvalidToken, "token has expired")
+	}
+	return Session{ID: c.SessionID, UserID: c.Subject, Expires: c.Expires}, nil
+}
+
+// Returns the base64url-encoded HMAC-SHA256 signature of the header and claims
+func (t *tokenService) sign(signed string) string {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,92 @@
+package token
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Unit tests of session tokens that don't use gotests plugin
+
+func newTestTokenService(t *testing.T, key string) *tokenService {
+	service, err := NewTokenService(context.Background(), key, "1h")
+	require.NoError(t, err)
+	return service.(*tokenService)
+}
+
+func TestTokens(t *testing.T) {
+	ctx := context.Background()
+	tokens := newTestTokenService(t, "")
+
+	// Anonymous sessions and logged in sessions
+	for _, session := range []Session{{ID: "session"}, {ID: "session", UserID: "jon"}} {
+		token, err := tokens.Issue(ctx, session)
+		require.NoError(t, err)
+		validated, err := tokens.Validate(ctx, token)
+		require.NoError(t, err)
+		require.Equal(t, session.ID, validated.ID)
+		require.Equal(t, session.UserID, validated.UserID)
+		require.InDelta(t, time.Now().Add(time.Hour).Unix(), validated.Expires, 1)
+	}
+
+	_, err := tokens.Issue(ctx, Session{UserID: "jon"})
+	require.Error(t, err)
+}
+
+func TestTokenExpires(t *testing.T) {
+	tokens := newTestTokenService(t, "")
+	now := time.Now()
+
+	token, err := tokens.issue(Session{ID: "session", UserID: "jon"}, now)
+	require.NoError(t, err)
+	_, err = tokens.validate(token, now.Add(59*time.Minute))
+	require.NoError(t, err)
+	_, err = tokens.validate(token, now.Add(time.Hour))
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestInvalidTokens(t *testing.T) {
+	ctx := context.Background()
+	tokens := newTestTokenService(t, "a signing key that is long enough")
+	token, err := tokens.Issue(ctx, Session{ID: "session", UserID: "jon"})
+	require.NoError(t, err)
+	parts := strings.Split(token, ".")
+
+	// A token whose claims were changed to another user's
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"vaastav","sid":"session","iat":0,"exp":9999999999}`))
+
+	// A token that isn't signed
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+
+	for _, invalid := range []string{
+		"",
+		"jon",
+		parts[0] + "." + parts[1],
+		parts[0] + "." + forged + "." + parts[2],
+		unsigned + "." + parts[1] + ".",
+		parts[0] + "." + parts[1] + "." + parts[2] + "x",
+	} {
+		_, err := tokens.Validate(ctx, invalid)
+		require.ErrorIs(t, err, ErrInvalidToken, invalid)
+	}
+
+	// Tokens signed by a service with a different key are refused
+	other := newTestTokenService(t, "")
+	_, err = other.Validate(ctx, token)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	// A service with the same key accepts them
+	same := newTestTokenService(t, "a signing key that is long enough")
+	session, err := same.Validate(ctx, token)
+	require.NoError(t, err)
+	require.Equal(t, "jon", session.UserID)
+
+	for _, args := range [][2]string{{"too short", "1h"}, {"", "forever"}, {"", "10ms"}} {
+		_, err := NewTokenService(ctx, args[0], args[1])
+		require.Error(t, err, args)
+	}
+}
@@ -0,0 +1,139 @@
+package user
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	errors_ "github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Passwords are stored as bcrypt hashes in modular crypt format, such as $2a$10$..., which
+// records the version of the hash and its cost alongside the hash itself.  Accounts created
+// by earlier versions of the user service instead have a legacy hash: the hex SHA-1 of the
+// user's salt followed by the password.  Legacy hashes are still accepted at login, where
+// they are replaced by bcrypt hashes, so that accounts are upgraded as their users log in.
+
+// The cost of new bcrypt hashes.  Hashes of a lower cost are rehashed at login.
+const passwordCost = bcrypt.DefaultCost
+
+// The most bytes of a password that bcrypt hashes
+const maxPasswordBytes = 72
+
+// The password policy used by the user service if none is specified
+const DefaultPasswordPolicy = "minlength=8"
+
+// ErrWeakPassword is returned when registering a password that does not meet the password policy.
+var ErrWeakPassword = errors.New("password does not meet the password policy")
+
+// Hashes a password to be stored
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return "", errors_.Wrap(err, "unable to hash password")
+	}
+	return string(hash), nil
+}
+
+// Checks password against a stored hash, which is a legacy hash made with salt if it is not
+// in modular crypt format.  Returns whether the password matches, and if it does, whether
+// the stored hash should be replaced by a new hash of the password.
+func checkPassword(hash, salt, password string) (matches bool, rehash bool) {
+	if !strings.HasPrefix(hash, "$") {
+		legacy := calculatePassHash(password, salt)
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(legacy)) == 1, true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return true, err != nil || cost < passwordCost
+}
+
+// Calculates a legacy SHA-1 password hash.  Only used to check passwords that have not
+// yet been rehashed.
+func calculatePassHash(pass, salt string) string {
+	h := sha1.New()
+	io.WriteString(h, salt)
+	io.WriteString(h, pass)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// The requirements that passwords must meet when they are registered
+type passwordPolicy struct {
+	minLength int // The fewest characters in a password
+	classes   int // The fewest character classes in a password
+}
+
+// Parses a password policy, as described by [NewUserServiceImpl]
+func newPasswordPolicy(policy string) (*passwordPolicy, error) {
+	p := &passwordPolicy{}
+	for _, rule := range strings.Split(policy, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		key, value, found := strings.Cut(rule, "=")
+		if !found {
+			return nil, errors_.Errorf("invalid password rule %q; expected key=value", rule)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < 0 {
+			return nil, errors_.Errorf("invalid password rule %q; expected a count", rule)
+		}
+		switch strings.TrimSpace(key) {
+		case "minlength":
+			if n > maxPasswordBytes {
+				return nil, errors_.Errorf("invalid password rule %q; passwords cannot be longer than %v bytes", rule, maxPasswordBytes)
+			}
+			p.minLength = n
+		case "classes":
+			if n > 4 {
+				return nil, errors_.Errorf("invalid password rule %q; there are only 4 character classes", rule)
+			}
+			p.classes = n
+		default:
+			return nil, errors_.Errorf("invalid password rule %q; unknown rule", rule)
+		}
+	}
+	return p, nil
+}
+
+// Checks that the password for username meets the policy.  Returns an error wrapping
+// [ErrWeakPassword] if it does not.
+func (p *passwordPolicy) check(username, password string) error {
+	if password == "" {
+		return errors_.Wrap(ErrWeakPassword, "password is empty")
+	} else if len(password) > maxPasswordBytes {
+		return errors_.Wrapf(ErrWeakPassword, "password is longer than %v bytes", maxPasswordBytes)
+	} else if strings.EqualFold(password, username) {
+		return errors_.Wrap(ErrWeakPassword, "password is the username")
+	} else if utf8.RuneCountInString(password) < p.minLength {
+		return errors_.Wrapf(ErrWeakPassword, "password is shorter than %v characters", p.minLength)
+	}
+
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	if lower+upper+digit+other < p.classes {
+		return errors_.Wrapf(ErrWeakPassword, "password has fewer than %v of lower case letters, upper case letters, digits, and other characters", p.classes)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package user
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/blueprint-uservices/blueprint/runtime/plugins/simplenosqldb"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Unit tests of password storage that don't use gotests plugin
+
+func newTestUserService(t *testing.T, policy string) *userServiceImpl {
+	ctx := context.Background()
+	db, err := simplenosqldb.NewSimpleNoSQLDB(ctx)
+	require.NoError(t, err)
+	service, err := NewUserServiceImpl(ctx, db, policy)
+	require.NoError(t, err)
+	return service.(*userServiceImpl)
+}
+
+// Returns the stored user with the given name
+func storedUser(t *testing.T, s *userServiceImpl, username string) User {
+	u, err := s.users.getUserByName(context.Background(), username)
+	require.NoError(t, err)
+	return u
+}
+
+func TestPasswordHashed(t *testing.T) {
+	ctx := context.Background()
+	s := newTestUserService(t, "")
+
+	_, err := s.Register(ctx, "jon", "secretsauce", "jon@mpi", "Jonathan", "Mace")
+	require.NoError(t, err)
+	u := storedUser(t, s, "jon")
+	require.True(t, strings.HasPrefix(u.Password, "$2a$"), u.Password)
+	require.Empty(t, u.Salt)
+
+	_, err = s.Login(ctx, "jon", "secretsauce")
+	require.NoError(t, err)
+	_, err = s.Login(ctx, "jon", "secretsauc")
+	require.Error(t, err)
+
+	// Logging in with an up to date hash doesn't rehash the password
+	require.Equal(t, u.Password, storedUser(t, s, "jon").Password)
+}
+
+func TestLegacyPasswordRehashed(t *testing.T) {
+	ctx := context.Background()
+	s := newTestUserService(t, "")
+
+	// A user stored with a SHA-1 hash by an earlier version of the service
+	legacy := User{Username: "vaastav", Salt: "9b0b4c1a", Addresses: []Address{}, Cards: []Card{}}
+	legacy.Password = calculatePassHash("supersecret", legacy.Salt)
+	require.NoError(t, s.users.createUser(ctx, &legacy))
+
+	// A wrong password is refused and doesn't upgrade the hash
+	_, err := s.Login(ctx, "vaastav", "supersecre")
+	require.Error(t, err)
+	require.Equal(t, legacy.Password, storedUser(t, s, "vaastav").Password)
+
+	// Logging in replaces the hash with a bcrypt hash of the same password
+	_, err = s.Login(ctx, "vaastav", "supersecret")
+	require.NoError(t, err)
+	u := storedUser(t, s, "vaastav")
+	require.NoError(t, bcrypt.CompareHashAndPassword([]byte(u.Password), []byte("supersecret")))
+	require.Empty(t, u.Salt)
+
+	_, err = s.Login(ctx, "vaastav", "supersecret")
+	require.NoError(t, err)
+
+	// Hashes of a lower cost than the current cost are upgraded too
+	cheap, err := bcrypt.GenerateFromPassword([]byte("supersecret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NoError(t, s.users.updatePassword(ctx, u.UserID, string(cheap)))
+	_, err = s.Login(ctx, "vaastav", "supersecret")
+	require.NoError(t, err)
+	cost, err := bcrypt.Cost([]byte(storedUser(t, s, "vaastav").Password))
+	require.NoError(t, err)
+	require.Equal(t, passwordCost, cost)
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	ctx := context.Background()
+	s := newTestUserService(t, "minlength=10; classes=3")
+
+	for _, password := range []string{
+		"",
+		"Sh0rt!",
+		"alllowercase",
+		"lowerandUPPER",
+		"Deepak-Garg1",
+		strings.Repeat("Aa1", 25),
+	} {
+		_, err := s.Register(ctx, "deepak-garg1", password, "deepak@mpi", "Deepak", "Garg")
+		require.ErrorIs(t, err, ErrWeakPassword, password)
+
+		_, err = s.PostUser(ctx, User{Username: "deepak-garg1", Password: password})
+		require.ErrorIs(t, err, ErrWeakPassword, password)
+	}
+
+	for _, password := range []string{"lowerUPPER123", "lower-and-123", "Ünïcödé-Pässwörd"} {
+		_, err := s.Register(ctx, "deepak", password, "deepak@mpi", "Deepak", "Garg")
+		require.NoError(t, err, password)
+		_, err = s.Login(ctx, "deepak", password)
+		require.NoError(t, err, password)
+		require.NoError(t, s.Delete(ctx, "customers", storedUser(t, s, "deepak").UserID))
+	}
+
+	for _, policy := range []string{"minlength", "minlength=-1", "minlength=100", "classes=5", "length=8", "minlength=eight"} {
+		_, err := newPasswordPolicy(policy)
+		require.Error(t, err, policy)
+	}
+}
@@ -77,6 +77,18 @@ func (s *userStore) createUser(ctx context.Context, user *User) error {
 	return nil
 }
 
+// Replaces a user's password hash, removing the salt of a legacy hash
+func (s *userStore) updatePassword(ctx context.Context, userid string, hash string) error {
+	id, err := primitive.ObjectIDFromHex(userid)
+	if err != nil {
+		return errors.New("Invalid Id Hex")
+	}
+	filter := bson.D{{"_id", id}}
+	update := bson.D{{"$set", bson.D{{"password", hash}, {"salt", ""}}}}
+	_, err = s.customers.UpdateOne(ctx, filter, update)
+	return err
+}
+
 // Get user by their name
 func (s *userStore) getUserByName(ctx context.Context, username string) (User, error) {
 	// Execute query
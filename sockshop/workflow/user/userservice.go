@@ -11,17 +11,16 @@
 //
 // The UserService thus uses three collections for the above information.
 // To get the data for a user also means more than one database call.
+//
+// Passwords are stored as bcrypt hashes.  Passwords that were stored as SHA-1 hashes
+// by earlier versions of the service are rehashed when their users next log in.
 package user
 
 import (
 	"context"
-	"crypto/sha1"
 	"errors"
 	"fmt"
-	"io"
-	"strconv"
 	"strings"
-	"time"
 
 	"github.com/blueprint-uservices/blueprint/runtime/core/backend"
 	errors_ "github.com/pkg/errors"
@@ -34,17 +33,21 @@ type (
 	// in orders that aren't associated with a user account.
 	UserService interface {
 		// Log in to an existing user account.  Returns an error if the password
-		// doesn't match the registered password
+		// doesn't match the registered password.  If the password is stored with an
+		// outdated hash, it is rehashed.
 		Login(ctx context.Context, username, password string) (User, error)
 
 		// Register a new user account.
-		// Returns the user ID
+		// Returns the user ID, or an error wrapping [ErrWeakPassword] if the password
+		// does not meet the service's password policy.
 		Register(ctx context.Context, username, password, email, first, last string) (string, error)
 
 		// Look up a user by id.  If id is the empty string, returns all users.
 		GetUsers(ctx context.Context, id string) ([]User, error)
 
-		// Insert a (possibly new) user into the DB.  Returns the user's ID
+		// Insert a (possibly new) user into the DB.  Returns the user's ID, or an error
+		// wrapping [ErrWeakPassword] if the user's password does not meet the service's
+		// password policy.
 		PostUser(ctx context.Context, user User) (string, error)
 
 		// Look up an address by id.  If id is the empty string, returns all addresses.
@@ -76,7 +79,7 @@ type (
 		Addresses []Address `json:"addresses" bson:"-"`
 		Cards     []Card    `json:"cards" bson:"-"`
 		UserID    string    `json:"id" bson:"-"`
-		Salt      string    `json:"-" bson:"salt"`
+		Salt      string    `json:"-" bson:"salt,omitempty"` // Only set for legacy password hashes
 	}
 
 	// A street address
@@ -94,6 +97,7 @@ type (
 		LongNum string
 		Expires string
 		CCV     string
+		Country string // The country in which the card was issued, if known
 		ID      string
 	}
 )
@@ -104,16 +108,35 @@ type (
 // a user account is optional when placing an order.
 type userServiceImpl struct {
 	UserService
-	users *userStore
+	users  *userStore
+	policy *passwordPolicy
 }
 
 // Creates a UserService implementation that stores user, address, and credit card
 // information in a NoSQLDatabase.
 //
-// Returns an error if unable to get the users, addresses, or cards collection from the DB
-func NewUserServiceImpl(ctx context.Context, db backend.NoSQLDatabase) (UserService, error) {
+// Passwords that are registered must meet passwordPolicy, a semicolon-separated list
+// of rules of the form key=value:
+//
+//   - minlength=8 requires passwords to have at least 8 characters
+//   - classes=3 requires passwords to have characters of at least 3 of the classes lower
+//     case letters, upper case letters, digits, and other characters
+//
+// [DefaultPasswordPolicy] is used if passwordPolicy is empty.  Regardless of the policy,
+// a password cannot be empty, be the user's username, or be longer than 72 bytes.
+//
+// Returns an error if the password policy is invalid, or if unable to get the users,
+// addresses, or cards collection from the DB
+func NewUserServiceImpl(ctx context.Context, db backend.NoSQLDatabase, passwordPolicy string) (UserService, error) {
+	if passwordPolicy == "" {
+		passwordPolicy = DefaultPasswordPolicy
+	}
+	policy, err := newPasswordPolicy(passwordPolicy)
+	if err != nil {
+		return nil, err
+	}
 	users, err := newUserStore(ctx, db)
-	return &userServiceImpl{users: users}, err
+	return &userServiceImpl{users: users, policy: policy}, err
 }
 
 func (s *userServiceImpl) Login(ctx context.Context, username, password string) (User, error) {
@@ -124,10 +147,19 @@ func (s *userServiceImpl) Login(ctx context.Context, username, password string)
 	}
 
 	// Check the password
-	if u.Password != calculatePassHash(password, u.Salt) {
+	matches, rehash := checkPassword(u.Password, u.Salt, password)
+	if !matches {
 		return newUser(), errors.New("Unauthorized")
 	}
 
+	// Upgrade an outdated hash now that the password is known.  The user is logged in
+	// regardless; if the upgrade fails, it is tried again at the user's next login
+	if rehash {
+		if hash, err := hashPassword(password); err == nil {
+			s.users.updatePassword(ctx, u.UserID, hash)
+		}
+	}
+
 	// Fetch user's card and address data, mask out CC numbers
 	err = s.users.getUserAttributes(ctx, &u)
 	u.maskCCs()
@@ -135,10 +167,18 @@ func (s *userServiceImpl) Login(ctx context.Context, username, password string)
 }
 
 func (s *userServiceImpl) Register(ctx context.Context, username, password, email, first, last string) (string, error) {
+	if err := s.policy.check(username, password); err != nil {
+		return "", err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return "", err
+	}
+
 	// Create the public user info
 	u := newUser()
 	u.Username = username
-	u.Password = calculatePassHash(password, u.Salt)
+	u.Password = hash
 	u.Email = email
 	u.FirstName = first
 	u.LastName = last
@@ -146,7 +186,7 @@ func (s *userServiceImpl) Register(ctx context.Context, username, password, emai
 	u.Cards = []Card{}
 
 	// Save the user in the DB
-	err := s.users.createUser(ctx, &u)
+	err = s.users.createUser(ctx, &u)
 	return u.UserID, err
 }
 
@@ -160,9 +200,15 @@ func (s *userServiceImpl) GetUsers(ctx context.Context, userid string) ([]User,
 }
 
 func (s *userServiceImpl) PostUser(ctx context.Context, u User) (string, error) {
-	u.newSalt()
-	u.Password = calculatePassHash(u.Password, u.Salt)
-	err := s.users.createUser(ctx, &u)
+	if err := s.policy.check(u.Username, u.Password); err != nil {
+		return "", err
+	}
+	hash, err := hashPassword(u.Password)
+	if err != nil {
+		return "", err
+	}
+	u.Password, u.Salt = hash, ""
+	err = s.users.createUser(ctx, &u)
 	return u.UserID, err
 }
 
@@ -198,11 +244,9 @@ func (s *userServiceImpl) Delete(ctx context.Context, entity string, id string)
 	return s.users.delete(ctx, entity, id)
 }
 
-// Creates a new, empty user, with a salt.
+// Creates a new, empty user.
 func newUser() User {
-	u := User{Addresses: make([]Address, 0), Cards: make([]Card, 0)}
-	u.newSalt()
-	return u
+	return User{Addresses: make([]Address, 0), Cards: make([]Card, 0)}
 }
 
 var (
@@ -232,12 +276,6 @@ func (u *User) maskCCs() {
 	}
 }
 
-func (u *User) newSalt() {
-	h := sha1.New()
-	io.WriteString(h, strconv.Itoa(int(time.Now().UnixNano())))
-	u.Salt = fmt.Sprintf("%x", h.Sum(nil))
-}
-
 func (u *User) addressIDs() []string {
 	ids := []string{}
 	for _, address := range u.Addresses {
@@ -259,10 +297,3 @@ func (c *Card) maskCC() {
 	l := len(c.LongNum) - 4
 	c.LongNum = fmt.Sprintf("%v%v", strings.Repeat("*", l), c.LongNum[l:])
 }
-
-func calculatePassHash(pass, salt string) string {
-	h := sha1.New()
-	io.WriteString(h, salt)
-	io.WriteString(h, pass)
-	return fmt.Sprintf("%x", h.Sum(nil))
-}
@@ -0,0 +1,104 @@
+// Command report compares the results of workload generator runs against different wiring
+// specs.  It reads the JSON files written by the workload generator's -out flag, ranks the
+// specs, compares them against a baseline spec, and writes a Markdown or HTML report.
+// Result files with the same label are treated as repeated runs of the same spec.
+//
+// Usage:
+//
+//	go run ./cmd/report -baseline cmp_grpc_nozipkin_micro -o report.md ../performance_results
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workload/report"
+	"github.com/pkg/errors"
+)
+
+var baseline = flag.String("baseline", "", "spec to compare against, e.g. cmp_grpc_nozipkin_micro")
+var sortBy = flag.String("sort", "throughput", "metric to rank specs by: "+strings.Join(metrics(), ", "))
+var op = flag.String("op", "total", "operation to compare; \"total\" for all operations, or \"journey\" for user-session journeys")
+var format = flag.String("format", "", "report format, \"markdown\" or \"html\"; by default inferred from the -o filename, else markdown")
+var output = flag.String("o", "", "file to write the report to; by default stdout")
+var cdfOut = flag.String("cdf", "", "file to write the latency CDF of every spec to, as CSV")
+var confidence = flag.Float64("confidence", 0.95, "confidence level of the confidence intervals over repeated runs")
+var alpha = flag.Float64("alpha", 0.05, "significance level of the comparisons against the baseline")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [flags] results.json|dir ...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := run(flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(paths []string) error {
+	if len(paths) == 0 {
+		return errors.Errorf("no result files or directories given")
+	}
+	runs, err := report.Load(paths...)
+	if err != nil {
+		return err
+	}
+	r, err := report.New(runs, report.Options{Op: *op, SortBy: *sortBy, Baseline: *baseline, Confidence: *confidence, Alpha: *alpha})
+	if err != nil {
+		return err
+	}
+
+	if *format == "" {
+		*format = "markdown"
+		if ext := filepath.Ext(*output); ext == ".html" || ext == ".htm" {
+			*format = "html"
+		}
+	}
+	var write func(io.Writer) error
+	switch *format {
+	case "markdown", "md":
+		write = r.WriteMarkdown
+	case "html":
+		write = r.WriteHTML
+	default:
+		return errors.Errorf("unknown report format %v", *format)
+	}
+
+	if err := writeTo(*output, write); err != nil {
+		return err
+	}
+	if *cdfOut != "" {
+		return writeTo(*cdfOut, r.WriteCDF)
+	}
+	return nil
+}
+
+// Writes to the named file, or to stdout if filename is empty
+func writeTo(filename string, write func(io.Writer) error) error {
+	if filename == "" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func metrics() []string {
+	var names []string
+	for _, m := range report.Metrics {
+		names = append(names, m.Name)
+	}
+	return names
+}
@@ -0,0 +1,362 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A table of formatted cells, rendered as Markdown or HTML
+type table struct {
+	Title  string
+	Header []string
+	Rows   [][]string
+}
+
+// Writes the report as Markdown
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Performance comparison\n\n%v\n", r.description())
+	for _, t := range r.tables() {
+		fmt.Fprintf(&b, "\n## %v\n\n", t.Title)
+		fmt.Fprintf(&b, "| %v |\n", strings.Join(t.Header, " | "))
+		fmt.Fprintf(&b, "|%v\n", strings.Repeat(" --- |", len(t.Header)))
+		for _, row := range t.Rows {
+			fmt.Fprintf(&b, "| %v |\n", strings.Join(row, " | "))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Writes the report as a standalone HTML page, including a plot of the latency CDFs
+func (r *Report) WriteHTML(w io.Writer) error {
+	return htmlTemplate.Execute(w, map[string]any{
+		"Description": r.description(),
+		"Tables":      r.tables(),
+		"Plot":        r.plot(),
+	})
+}
+
+// Writes the latency CDF of every run as CSV, with columns name, latency_ns, and fraction
+func (r *Report) WriteCDF(w io.Writer) error {
+	rows := [][]string{{"name", "latency_ns", "fraction"}}
+	for _, c := range r.CDFs {
+		for _, p := range c.Points {
+			rows = append(rows, []string{c.Name, strconv.FormatInt(int64(p.Latency), 10), strconv.FormatFloat(p.Fraction, 'f', -1, 64)})
+		}
+	}
+	return csv.NewWriter(w).WriteAll(rows)
+}
+
+func (r *Report) description() string {
+	s := fmt.Sprintf("Operation `%v`, ranked by %v. Values are means over each spec's runs, "+
+		"± the half-width of the %v%% confidence interval where there are at least two runs.", r.Op, r.SortBy, 100*r.Confidence)
+	if r.Baseline != "" {
+		s += fmt.Sprintf(" Changes are relative to the baseline `%v`; * marks changes that are significant at the %v%% level (Welch's t-test).",
+			r.Baseline, 100*r.Alpha)
+	}
+	return s
+}
+
+// The metrics shown in the ranking and significance tables
+var summaryMetrics = []string{"throughput", "mean", "p50", "p99", "errors"}
+
+// The metrics shown in the latency percentiles table
+var percentileMetrics = []string{"min", "p50", "p90", "p95", "p99", "p999", "max"}
+
+var metricTitles = map[string]string{
+	"throughput": "Throughput (req/s)",
+	"mean":       "Mean",
+	"min":        "Min",
+	"p50":        "p50",
+	"p90":        "p90",
+	"p95":        "p95",
+	"p99":        "p99",
+	"p999":       "p99.9",
+	"max":        "Max",
+	"errors":     "Error rate",
+}
+
+func (r *Report) tables() []table {
+	ranking := table{Title: "Ranking", Header: []string{"Rank", "Spec", "Runs", "Requests"}}
+	percentiles := table{Title: "Latency percentiles", Header: []string{"Spec"}}
+	significance := table{Title: "Significance", Header: []string{"Spec"}}
+	for _, name := range summaryMetrics {
+		ranking.Header = append(ranking.Header, metricTitles[name])
+		significance.Header = append(significance.Header, metricTitles[name]+" p-value")
+	}
+	for _, name := range percentileMetrics {
+		percentiles.Header = append(percentiles.Header, metricTitles[name])
+	}
+
+	for _, row := range r.Rows {
+		isBaseline := row.Name == r.Baseline
+		name := row.Name
+		if isBaseline {
+			name += " (baseline)"
+		}
+
+		cells := []string{strconv.Itoa(row.Rank), name, strconv.Itoa(len(row.Ops)), strconv.FormatInt(row.Requests, 10)}
+		for _, m := range summaryMetrics {
+			cell := formatEstimate(FindMetric(m), row.Estimates[m])
+			if c, ok := row.Comparisons[m]; ok && !isBaseline {
+				cell += formatComparison(FindMetric(m), c)
+			}
+			cells = append(cells, cell)
+		}
+		ranking.Rows = append(ranking.Rows, cells)
+
+		cells = []string{row.Name}
+		for _, m := range percentileMetrics {
+			cells = append(cells, formatEstimate(FindMetric(m), row.Estimates[m]))
+		}
+		percentiles.Rows = append(percentiles.Rows, cells)
+
+		if row.Comparisons != nil && !isBaseline {
+			cells = []string{row.Name}
+			for _, m := range summaryMetrics {
+				cells = append(cells, formatPValue(row.Comparisons[m]))
+			}
+			significance.Rows = append(significance.Rows, cells)
+		}
+	}
+
+	cdf := table{Title: "Latency CDF", Header: []string{"Latency"}}
+	for _, c := range r.CDFs {
+		cdf.Header = append(cdf.Header, c.Name)
+	}
+	for _, threshold := range r.thresholds() {
+		cells := []string{"≤ " + formatDuration(threshold)}
+		for _, c := range r.CDFs {
+			cells = append(cells, fmt.Sprintf("%.2f%%", 100*c.fractionWithin(threshold)))
+		}
+		cdf.Rows = append(cdf.Rows, cells)
+	}
+
+	tables := []table{ranking, percentiles}
+	if r.Baseline != "" {
+		tables = append(tables, significance)
+	}
+	return append(tables, cdf)
+}
+
+// Returns latencies of 1, 2 and 5 times powers of ten, spanning the latencies of all runs
+func (r *Report) thresholds() []time.Duration {
+	lo, hi := r.latencyRange()
+	if hi == 0 {
+		return nil
+	}
+	var thresholds []time.Duration
+	for decade := time.Duration(1); decade > 0; decade *= 10 {
+		for _, m := range []time.Duration{1, 2, 5} {
+			if t := m * decade; t >= lo {
+				thresholds = append(thresholds, t)
+				if t >= hi {
+					return thresholds
+				}
+			}
+		}
+	}
+	return thresholds
+}
+
+// Returns the smallest and largest latencies recorded by any run
+func (r *Report) latencyRange() (lo, hi time.Duration) {
+	for _, c := range r.CDFs {
+		if len(c.Points) == 0 {
+			continue
+		}
+		if first := c.Points[0].Latency; lo == 0 || first < lo {
+			lo = first
+		}
+		if last := c.Points[len(c.Points)-1].Latency; last > hi {
+			hi = last
+		}
+	}
+	return lo, hi
+}
+
+// Returns the fraction of requests that completed within the given latency
+func (c CDF) fractionWithin(latency time.Duration) float64 {
+	i := sort.Search(len(c.Points), func(i int) bool { return c.Points[i].Latency > latency })
+	if i == 0 {
+		return 0
+	}
+	return c.Points[i-1].Fraction
+}
+
+type plotLine struct {
+	Name   string
+	Color  string
+	Points string
+}
+
+type plotAxisTick struct {
+	X     float64
+	Label string
+}
+
+const plotWidth, plotHeight, plotMargin = 800.0, 400.0, 50.0
+
+var plotColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+// Lays out the CDFs as lines on a log-scale latency axis
+func (r *Report) plot() map[string]any {
+	lo, hi := r.latencyRange()
+	if lo < 1 {
+		lo = 1
+	}
+	if hi <= lo {
+		hi = lo + 1
+	}
+	logLo, logHi := math.Log10(float64(lo)), math.Log10(float64(hi))
+	x := func(d time.Duration) float64 {
+		v := math.Log10(math.Max(float64(d), float64(lo)))
+		return plotMargin + (v-logLo)/(logHi-logLo)*(plotWidth-2*plotMargin)
+	}
+	y := func(fraction float64) float64 {
+		return plotHeight - plotMargin - fraction*(plotHeight-2*plotMargin)
+	}
+
+	var lines []plotLine
+	for i, c := range r.CDFs {
+		var points []string
+		for _, p := range c.Points {
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x(p.Latency), y(p.Fraction)))
+		}
+		lines = append(lines, plotLine{Name: c.Name, Color: plotColors[i%len(plotColors)], Points: strings.Join(points, " ")})
+	}
+
+	var ticks []plotAxisTick
+	for _, t := range r.thresholds() {
+		if t <= hi && strconv.FormatInt(int64(t), 10)[0] == '1' {
+			ticks = append(ticks, plotAxisTick{X: x(t), Label: formatDuration(t)})
+		}
+	}
+
+	return map[string]any{
+		"Width":  plotWidth,
+		"Height": plotHeight,
+		"Left":   plotMargin,
+		"Right":  plotWidth - plotMargin,
+		"Top":    plotMargin,
+		"Bottom": plotHeight - plotMargin,
+		"Lines":  lines,
+		"Ticks":  ticks,
+	}
+}
+
+// Formats a latency to about three significant figures
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return formatFloat(d.Seconds()) + "s"
+	case d >= time.Millisecond:
+		return formatFloat(float64(d)/float64(time.Millisecond)) + "ms"
+	case d >= time.Microsecond:
+		return formatFloat(float64(d)/float64(time.Microsecond)) + "µs"
+	default:
+		return strconv.FormatInt(int64(d), 10) + "ns"
+	}
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case v < 10:
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	case v < 100:
+		return strconv.FormatFloat(v, 'f', 1, 64)
+	default:
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+}
+
+// Formats a metric's value
+func formatValue(m *Metric, v float64) string {
+	switch {
+	case m.Duration:
+		return formatDuration(time.Duration(v))
+	case m.Name == "errors":
+		return fmt.Sprintf("%.2f%%", v)
+	default:
+		return fmt.Sprintf("%.1f", v)
+	}
+}
+
+// Formats the mean of an estimate, with its confidence interval if there is one
+func formatEstimate(m *Metric, e Estimate) string {
+	if math.IsNaN(e.CI) {
+		return formatValue(m, e.Mean)
+	}
+	return formatValue(m, e.Mean) + " ± " + formatValue(m, e.CI)
+}
+
+// Formats the change relative to the baseline, marking significant changes with *
+func formatComparison(m *Metric, c Comparison) string {
+	if math.IsNaN(c.Change) {
+		return " (n/a)"
+	}
+	unit := "%"
+	if m.Absolute {
+		unit = "pp"
+	}
+	if c.Significant {
+		unit += "*"
+	}
+	return fmt.Sprintf(" (%+.1f%v)", c.Change, unit)
+}
+
+func formatPValue(c Comparison) string {
+	if math.IsNaN(c.PValue) {
+		return "n/a"
+	}
+	if c.Significant {
+		return fmt.Sprintf("%.3f*", c.PValue)
+	}
+	return fmt.Sprintf("%.3f", c.PValue)
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Performance comparison</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: right; }
+th:nth-child(-n+2), td:nth-child(-n+2) { text-align: left; }
+</style>
+</head>
+<body>
+<h1>Performance comparison</h1>
+<p>{{.Description}}</p>
+{{range .Tables}}
+<h2>{{.Title}}</h2>
+<table>
+<tr>{{range .Header}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}
+{{with .Plot}}
+<h2>Latency CDF plot</h2>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}">
+<line x1="{{.Left}}" y1="{{.Bottom}}" x2="{{.Right}}" y2="{{.Bottom}}" stroke="black"/>
+<line x1="{{.Left}}" y1="{{.Top}}" x2="{{.Left}}" y2="{{.Bottom}}" stroke="black"/>
+<text x="{{.Left}}" y="{{.Top}}" text-anchor="end" dx="-4">100%</text>
+<text x="{{.Left}}" y="{{.Bottom}}" text-anchor="end" dx="-4">0%</text>
+{{$bottom := .Bottom}}{{range .Ticks}}<text x="{{.X}}" y="{{$bottom}}" text-anchor="middle" dy="16">{{.Label}}</text>
+{{end}}{{range .Lines}}<polyline fill="none" stroke="{{.Color}}" stroke-width="1.5" points="{{.Points}}"><title>{{.Name}}</title></polyline>
+{{end}}</svg>
+<ul>{{range .Lines}}<li style="color: {{.Color}}">{{.Name}}</li>{{end}}</ul>
+{{end}}
+</body>
+</html>
+`))
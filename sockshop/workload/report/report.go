@@ -0,0 +1,275 @@
+// Package report compares the results of workload generator runs against different wiring
+// specs, and renders the comparison as a Markdown or HTML report.
+//
+// Each spec may be run several times.  The report estimates each metric by its mean over
+// a spec's runs, with a confidence interval, and tests whether differences from a
+// baseline spec are statistically significant.
+package report
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workload/results"
+	"github.com/pkg/errors"
+)
+
+type (
+	// The results of one run, and the name of the spec it measured
+	Run struct {
+		Name    string
+		Results *results.Results
+	}
+
+	// Options for building a report
+	Options struct {
+		Op         string  // The operation to compare; "total" for all operations, or "journey"
+		SortBy     string  // The metric to rank specs by; see Metrics
+		Baseline   string  // The spec to compare against; if empty, no comparisons are made
+		Confidence float64 // The confidence level of confidence intervals; 0.95 by default
+		Alpha      float64 // The significance level of comparisons against the baseline; 0.05 by default
+	}
+
+	// A comparison of several specs
+	Report struct {
+		Options
+		Rows []Row // Sorted by rank
+		CDFs []CDF // In the same order as Rows
+	}
+
+	// One spec in the comparison, estimated from all of its runs
+	Row struct {
+		Rank        int
+		Name        string
+		Ops         []results.Operation   // The operation's statistics in each run
+		Requests    int64                 // Total over all runs
+		Estimates   map[string]Estimate   // By metric name
+		Comparisons map[string]Comparison // Against the baseline, by metric name; nil if there is no baseline
+	}
+
+	// A metric that specs are estimated, ranked, and compared by
+	Metric struct {
+		Name           string
+		HigherIsBetter bool
+		Absolute       bool // Changes are reported as absolute differences rather than percentages
+		Duration       bool // Values are durations in nanoseconds
+		Value          func(op *results.Operation) float64
+	}
+
+	// The cumulative distribution of a spec's latencies, pooled over all of its runs
+	CDF struct {
+		Name   string
+		Points []Point
+	}
+
+	// The fraction of requests that completed within Latency
+	Point struct {
+		Latency  time.Duration
+		Fraction float64
+	}
+)
+
+// The metrics computed for each spec.  The error rate is a percentage, and is compared
+// against the baseline in percentage points.
+var Metrics = []Metric{
+	{Name: "throughput", HigherIsBetter: true, Value: func(op *results.Operation) float64 { return op.Throughput }},
+	{Name: "mean", Duration: true, Value: func(op *results.Operation) float64 { return float64(op.Latency.Mean) }},
+	{Name: "min", Duration: true, Value: func(op *results.Operation) float64 { return float64(op.Latency.Min) }},
+	{Name: "p50", Duration: true, Value: func(op *results.Operation) float64 { return float64(op.Latency.P50) }},
+	{Name: "p90", Duration: true, Value: func(op *results.Operation) float64 { return float64(op.Latency.P90) }},
+	{Name: "p95", Duration: true, Value: func(op *results.Operation) float64 { return float64(op.Latency.P95) }},
+	{Name: "p99", Duration: true, Value: func(op *results.Operation) float64 { return float64(op.Latency.P99) }},
+	{Name: "p999", Duration: true, Value: func(op *results.Operation) float64 { return float64(op.Latency.P999) }},
+	{Name: "max", Duration: true, Value: func(op *results.Operation) float64 { return float64(op.Latency.Max) }},
+	{Name: "errors", Absolute: true, Value: func(op *results.Operation) float64 {
+		if op.Requests == 0 {
+			return 0
+		}
+		return 100 * float64(op.Errors) / float64(op.Requests)
+	}},
+}
+
+// Returns the metric with the given name, or nil if there is none
+func FindMetric(name string) *Metric {
+	for i := range Metrics {
+		if Metrics[i].Name == name {
+			return &Metrics[i]
+		}
+	}
+	return nil
+}
+
+// Loads runs from result files written by the workload generator's -out flag.  Paths that
+// are directories are expanded to the .json files they contain.  Each run is named by the
+// label it was recorded with, or else by its filename.
+func Load(paths ...string) ([]Run, error) {
+	var filenames []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			filenames = append(filenames, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		filenames = append(filenames, matches...)
+	}
+
+	var runs []Run
+	for _, filename := range filenames {
+		r, err := results.Load(filename)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to load results from %v", filename)
+		}
+		name := r.Config.Label
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+		}
+		runs = append(runs, Run{Name: name, Results: r})
+	}
+	return runs, nil
+}
+
+// Groups runs by spec, estimates each spec's metrics over its runs, ranks the specs, and
+// compares them against the baseline.  Runs with the same name are repetitions of the
+// same spec.
+func New(runs []Run, opts Options) (*Report, error) {
+	if opts.Op == "" {
+		opts.Op = "total"
+	}
+	if opts.SortBy == "" {
+		opts.SortBy = "throughput"
+	}
+	if opts.Confidence == 0 {
+		opts.Confidence = 0.95
+	}
+	if opts.Alpha == 0 {
+		opts.Alpha = 0.05
+	}
+	sortBy := FindMetric(opts.SortBy)
+	if sortBy == nil {
+		return nil, errors.Errorf("unknown metric %v to sort by", opts.SortBy)
+	}
+	if opts.Confidence <= 0 || opts.Confidence >= 1 || opts.Alpha <= 0 || opts.Alpha >= 1 {
+		return nil, errors.Errorf("confidence level and significance level must be between 0 and 1")
+	}
+	if len(runs) == 0 {
+		return nil, errors.Errorf("no results to report")
+	}
+
+	report := &Report{Options: opts}
+	specs := make(map[string]int)
+	for _, run := range runs {
+		op := findOp(run.Results, opts.Op)
+		if op == nil {
+			return nil, errors.Errorf("results for %v do not contain operation %v", run.Name, opts.Op)
+		}
+		i, exists := specs[run.Name]
+		if !exists {
+			i = len(report.Rows)
+			specs[run.Name] = i
+			report.Rows = append(report.Rows, Row{Name: run.Name})
+		}
+		report.Rows[i].Ops = append(report.Rows[i].Ops, *op)
+		report.Rows[i].Requests += op.Requests
+	}
+
+	for i := range report.Rows {
+		row := &report.Rows[i]
+		row.Estimates = make(map[string]Estimate)
+		for _, m := range Metrics {
+			var values []float64
+			for j := range row.Ops {
+				values = append(values, m.Value(&row.Ops[j]))
+			}
+			row.Estimates[m.Name] = estimate(values, opts.Confidence)
+		}
+	}
+
+	sort.SliceStable(report.Rows, func(i, j int) bool {
+		a, b := report.Rows[i].Estimates[sortBy.Name].Mean, report.Rows[j].Estimates[sortBy.Name].Mean
+		if a != b {
+			return (a > b) == sortBy.HigherIsBetter
+		}
+		return report.Rows[i].Name < report.Rows[j].Name
+	})
+
+	var baseline *Row
+	for i := range report.Rows {
+		report.Rows[i].Rank = i + 1
+		if report.Rows[i].Name == opts.Baseline {
+			baseline = &report.Rows[i]
+		}
+	}
+	if opts.Baseline != "" && baseline == nil {
+		return nil, errors.Errorf("no results for baseline %v", opts.Baseline)
+	}
+
+	for i := range report.Rows {
+		row := &report.Rows[i]
+		if baseline != nil {
+			row.Comparisons = make(map[string]Comparison)
+			for _, m := range Metrics {
+				row.Comparisons[m.Name] = compare(m, row.Estimates[m.Name], baseline.Estimates[m.Name], opts.Alpha)
+			}
+		}
+		report.CDFs = append(report.CDFs, CDF{Name: row.Name, Points: cdf(row.Ops)})
+	}
+	return report, nil
+}
+
+// Compares the estimate of a metric against the baseline's
+func compare(m Metric, e, baseline Estimate, alpha float64) Comparison {
+	c := Comparison{Change: e.Mean - baseline.Mean, PValue: welchTTest(e, baseline)}
+	if !m.Absolute {
+		c.Change = math.NaN()
+		if baseline.Mean != 0 {
+			c.Change = 100 * (e.Mean - baseline.Mean) / baseline.Mean
+		}
+	}
+	c.Significant = c.PValue < alpha
+	return c
+}
+
+// Returns the named operation, or the journey statistics for "journey"
+func findOp(r *results.Results, name string) *results.Operation {
+	if name == "journey" && r.Journeys != nil {
+		return &r.Journeys.Operation
+	}
+	return r.Op(name)
+}
+
+// Computes the cumulative distribution of the latencies of all runs, with one point at
+// the upper bound of each histogram bucket
+func cdf(ops []results.Operation) []Point {
+	counts := make(map[results.Bucket]int64)
+	var total int64
+	for _, op := range ops {
+		for _, b := range op.Histogram {
+			counts[results.Bucket{Lower: b.Lower, Upper: b.Upper}] += b.Count
+			total += b.Count
+		}
+	}
+	var buckets []results.Bucket
+	for b := range counts {
+		buckets = append(buckets, b)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Upper < buckets[j].Upper })
+
+	var points []Point
+	var seen int64
+	for _, b := range buckets {
+		seen += counts[b]
+		points = append(points, Point{Latency: b.Upper, Fraction: float64(seen) / float64(total)})
+	}
+	return points
+}
@@ -0,0 +1,222 @@
+package report
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workload/results"
+	"github.com/stretchr/testify/require"
+)
+
+func makeRun(name string, throughput float64, p50, p99 time.Duration, errs int64) Run {
+	total := results.Operation{
+		Name:       "total",
+		Requests:   1000,
+		Errors:     errs,
+		Throughput: throughput,
+		Latency:    results.LatencyStats{Min: p50 / 2, Mean: p50, P50: p50, P90: p99 / 2, P95: p99 / 2, P99: p99, P999: p99, Max: 2 * p99},
+		Histogram: []results.Bucket{
+			{Lower: p50 / 2, Upper: p50 / 2, Count: 100},
+			{Lower: p50, Upper: p50, Count: 400},
+			{Lower: p99 / 2, Upper: p99 / 2, Count: 450},
+			{Lower: 2 * p99, Upper: 2 * p99, Count: 50},
+		},
+	}
+	return Run{Name: name, Results: &results.Results{Config: results.Config{Label: name}, Total: total}}
+}
+
+func testRuns() []Run {
+	return []Run{
+		makeRun("cmp_grpc_zipkin_micro", 400, 2*time.Millisecond, 20*time.Millisecond, 0),
+		makeRun("cmp_grpc_nozipkin_micro", 500, 1*time.Millisecond, 10*time.Millisecond, 10),
+		makeRun("cmp_zipkin_mono", 800, 500*time.Microsecond, 40*time.Millisecond, 0),
+	}
+}
+
+func names(r *Report) []string {
+	var names []string
+	for _, row := range r.Rows {
+		names = append(names, row.Name)
+	}
+	return names
+}
+
+func TestRanking(t *testing.T) {
+	r, err := New(testRuns(), Options{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"cmp_zipkin_mono", "cmp_grpc_nozipkin_micro", "cmp_grpc_zipkin_micro"}, names(r))
+	for i, row := range r.Rows {
+		require.Equal(t, i+1, row.Rank)
+		require.Nil(t, row.Comparisons)
+	}
+
+	r, err = New(testRuns(), Options{SortBy: "p99"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"cmp_grpc_nozipkin_micro", "cmp_grpc_zipkin_micro", "cmp_zipkin_mono"}, names(r))
+
+	r, err = New(testRuns(), Options{SortBy: "p50"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"cmp_zipkin_mono", "cmp_grpc_nozipkin_micro", "cmp_grpc_zipkin_micro"}, names(r))
+
+	_, err = New(testRuns(), Options{SortBy: "avg_latency"})
+	require.Error(t, err)
+
+	_, err = New(testRuns(), Options{Op: "neworder"})
+	require.Error(t, err)
+}
+
+func TestBaselineComparisons(t *testing.T) {
+	r, err := New(testRuns(), Options{Baseline: "cmp_grpc_nozipkin_micro"})
+	require.NoError(t, err)
+
+	comparisons := make(map[string]map[string]Comparison)
+	for _, row := range r.Rows {
+		comparisons[row.Name] = row.Comparisons
+	}
+	require.Equal(t, 0.0, comparisons["cmp_grpc_nozipkin_micro"]["throughput"].Change)
+	require.InDelta(t, -20.0, comparisons["cmp_grpc_zipkin_micro"]["throughput"].Change, 1e-9)
+	require.InDelta(t, 100.0, comparisons["cmp_grpc_zipkin_micro"]["p50"].Change, 1e-9)
+	require.InDelta(t, 100.0, comparisons["cmp_grpc_zipkin_micro"]["p99"].Change, 1e-9)
+	require.InDelta(t, -1.0, comparisons["cmp_grpc_zipkin_micro"]["errors"].Change, 1e-9)
+	require.InDelta(t, 60.0, comparisons["cmp_zipkin_mono"]["throughput"].Change, 1e-9)
+	require.InDelta(t, -50.0, comparisons["cmp_zipkin_mono"]["mean"].Change, 1e-9)
+	require.InDelta(t, 300.0, comparisons["cmp_zipkin_mono"]["p99"].Change, 1e-9)
+
+	// A single run per spec cannot be tested for significance
+	require.True(t, math.IsNaN(comparisons["cmp_zipkin_mono"]["throughput"].PValue))
+	require.False(t, comparisons["cmp_zipkin_mono"]["throughput"].Significant)
+
+	_, err = New(testRuns(), Options{Baseline: "cmp_thrift_zipkin_micro"})
+	require.Error(t, err)
+
+	runs := testRuns()
+	runs[1].Results.Total.Throughput = 0
+	r, err = New(runs, Options{Baseline: "cmp_grpc_nozipkin_micro"})
+	require.NoError(t, err)
+	require.True(t, math.IsNaN(r.Rows[0].Comparisons["throughput"].Change))
+}
+
+func TestRepetitions(t *testing.T) {
+	var runs []Run
+	for _, throughput := range []float64{100, 102, 98} {
+		runs = append(runs, makeRun("baseline", throughput, time.Millisecond, 10*time.Millisecond, 0))
+	}
+	for _, throughput := range []float64{110, 111, 109} {
+		runs = append(runs, makeRun("faster", throughput, time.Millisecond, 10*time.Millisecond, 0))
+	}
+	for _, throughput := range []float64{100, 101, 102} {
+		runs = append(runs, makeRun("same", throughput, time.Millisecond, 10*time.Millisecond, 0))
+	}
+
+	r, err := New(runs, Options{Baseline: "baseline"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"faster", "same", "baseline"}, names(r))
+
+	baseline := r.Rows[2]
+	require.Len(t, baseline.Ops, 3)
+	require.Equal(t, int64(3000), baseline.Requests)
+	throughput := baseline.Estimates["throughput"]
+	require.Equal(t, 3, throughput.N)
+	require.InDelta(t, 100.0, throughput.Mean, 1e-9)
+	require.InDelta(t, 2.0, throughput.StdDev, 1e-9)
+	require.InDelta(t, 4.303*2/math.Sqrt(3), throughput.CI, 1e-3)
+
+	faster := r.Rows[0].Comparisons["throughput"]
+	require.InDelta(t, 10.0, faster.Change, 1e-9)
+	require.Less(t, faster.PValue, 0.01)
+	require.True(t, faster.Significant)
+
+	same := r.Rows[1].Comparisons["throughput"]
+	require.Greater(t, same.PValue, 0.3)
+	require.False(t, same.Significant)
+
+	// Identical runs have no variance, and no significant difference
+	require.Equal(t, 1.0, r.Rows[1].Comparisons["p99"].PValue)
+	require.Equal(t, 0.0, r.Rows[1].Estimates["p99"].CI)
+
+	// The CDF pools the latencies of all runs
+	require.Len(t, r.CDFs[0].Points, 4)
+	require.Equal(t, 0.1, r.CDFs[0].Points[0].Fraction)
+
+	var md bytes.Buffer
+	require.NoError(t, r.WriteMarkdown(&md))
+	require.Contains(t, md.String(), "| 1 | faster | 3 | 3000 | 110.0 ± 2.5 (+10.0%*) |")
+	require.Contains(t, md.String(), "| 3 | baseline (baseline) | 3 | 3000 | 100.0 ± 5.0 |")
+	require.Contains(t, md.String(), "## Significance")
+	require.Contains(t, md.String(), "| same | 0.")
+}
+
+func TestCDF(t *testing.T) {
+	r, err := New(testRuns(), Options{})
+	require.NoError(t, err)
+	require.Len(t, r.CDFs, 3)
+	for i, c := range r.CDFs {
+		require.Equal(t, r.Rows[i].Name, c.Name)
+		require.Len(t, c.Points, 4)
+		for j := 1; j < len(c.Points); j++ {
+			require.Greater(t, c.Points[j].Latency, c.Points[j-1].Latency)
+			require.Greater(t, c.Points[j].Fraction, c.Points[j-1].Fraction)
+		}
+		require.Equal(t, 1.0, c.Points[len(c.Points)-1].Fraction)
+	}
+
+	c := r.CDFs[1] // cmp_grpc_nozipkin_micro
+	require.Equal(t, 0.0, c.fractionWithin(100*time.Microsecond))
+	require.Equal(t, 0.1, c.fractionWithin(500*time.Microsecond))
+	require.Equal(t, 0.5, c.fractionWithin(2*time.Millisecond))
+	require.Equal(t, 1.0, c.fractionWithin(time.Second))
+
+	thresholds := r.thresholds()
+	require.Equal(t, 250*time.Microsecond, r.CDFs[0].Points[0].Latency)
+	require.Equal(t, 500*time.Microsecond, thresholds[0])
+	require.Equal(t, 100*time.Millisecond, thresholds[len(thresholds)-1])
+}
+
+func TestRender(t *testing.T) {
+	r, err := New(testRuns(), Options{Baseline: "cmp_grpc_nozipkin_micro"})
+	require.NoError(t, err)
+
+	var md bytes.Buffer
+	require.NoError(t, r.WriteMarkdown(&md))
+	require.Contains(t, md.String(), "| 1 | cmp_zipkin_mono | 1 | 1000 | 800.0 (+60.0%) | 500µs (-50.0%) |")
+	require.Contains(t, md.String(), "| 2 | cmp_grpc_nozipkin_micro (baseline) | 1 | 1000 | 500.0 | 1.00ms |")
+	require.Contains(t, md.String(), "| cmp_zipkin_mono | n/a | n/a |")
+	require.Contains(t, md.String(), "| ≤ 5.00ms | 50.00% | 95.00% | 50.00% |")
+	require.Less(t, strings.Index(md.String(), "cmp_zipkin_mono"), strings.Index(md.String(), "cmp_grpc_zipkin_micro"))
+
+	var html bytes.Buffer
+	require.NoError(t, r.WriteHTML(&html))
+	require.Contains(t, html.String(), "<td>cmp_zipkin_mono</td>")
+	require.Contains(t, html.String(), "<polyline")
+
+	var cdf bytes.Buffer
+	require.NoError(t, r.WriteCDF(&cdf))
+	require.Equal(t, 1+12, strings.Count(cdf.String(), "\n"))
+	require.True(t, strings.HasPrefix(cdf.String(), "name,latency_ns,fraction\ncmp_zipkin_mono,250000,0.1\n"))
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	for _, run := range testRuns() {
+		require.NoError(t, run.Results.WriteJSON(filepath.Join(dir, run.Name+".json")))
+	}
+	unlabelled := makeRun("", 100, time.Millisecond, time.Millisecond, 0)
+	other := filepath.Join(t.TempDir(), "cmp_thrift_zipkin_micro.json")
+	require.NoError(t, unlabelled.Results.WriteJSON(other))
+
+	runs, err := Load(dir, other)
+	require.NoError(t, err)
+	require.Len(t, runs, 4)
+	require.Equal(t, "cmp_thrift_zipkin_micro", runs[3].Name)
+
+	r, err := New(runs, Options{Baseline: "cmp_thrift_zipkin_micro"})
+	require.NoError(t, err)
+	require.Equal(t, "cmp_zipkin_mono", r.Rows[0].Name)
+
+	_, err = Load(filepath.Join(dir, "missing.json"))
+	require.Error(t, err)
+}
@@ -0,0 +1,145 @@
+package report
+
+import (
+	"math"
+)
+
+// The estimate of a metric from repeated runs
+type Estimate struct {
+	N      int       // Number of runs
+	Values []float64 // The value from each run
+	Mean   float64
+	StdDev float64 // Sample standard deviation; NaN if there is only one run
+	CI     float64 // Half-width of the confidence interval of the mean; NaN if there is only one run
+}
+
+// A comparison of a metric against the baseline
+type Comparison struct {
+	Change      float64 // Change in the mean, as a percentage of the baseline mean; NaN if the baseline mean is zero
+	PValue      float64 // Two-sided p-value of Welch's t-test; NaN if either has fewer than two runs
+	Significant bool    // Whether PValue is below the significance level
+}
+
+// Estimates the mean of values, with a confidence interval at the given level (e.g. 0.95)
+func estimate(values []float64, confidence float64) Estimate {
+	e := Estimate{N: len(values), Values: values, StdDev: math.NaN(), CI: math.NaN()}
+	for _, v := range values {
+		e.Mean += v
+	}
+	e.Mean /= float64(e.N)
+	if e.N < 2 {
+		return e
+	}
+	e.StdDev = math.Sqrt(variance(values, e.Mean))
+	e.CI = studentTQuantile(1-(1-confidence)/2, float64(e.N-1)) * e.StdDev / math.Sqrt(float64(e.N))
+	return e
+}
+
+func variance(values []float64, mean float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += (v - mean) * (v - mean)
+	}
+	return sum / float64(len(values)-1)
+}
+
+// Returns the two-sided p-value of Welch's t-test of the hypothesis that a and b have
+// equal means, without assuming equal variances
+func welchTTest(a, b Estimate) float64 {
+	if a.N < 2 || b.N < 2 {
+		return math.NaN()
+	}
+	va, vb := a.StdDev*a.StdDev/float64(a.N), b.StdDev*b.StdDev/float64(b.N)
+	if va+vb == 0 {
+		if a.Mean == b.Mean {
+			return 1
+		}
+		return 0
+	}
+	t := (a.Mean - b.Mean) / math.Sqrt(va+vb)
+	df := (va + vb) * (va + vb) / (va*va/float64(a.N-1) + vb*vb/float64(b.N-1))
+	return 2 * (1 - studentTCDF(math.Abs(t), df))
+}
+
+// The cumulative distribution function of Student's t distribution with df degrees of freedom
+func studentTCDF(t, df float64) float64 {
+	p := 0.5 * regularizedIncompleteBeta(df/(df+t*t), df/2, 0.5)
+	if t > 0 {
+		return 1 - p
+	}
+	return p
+}
+
+// The quantile function of Student's t distribution, computed by bisection
+func studentTQuantile(p, df float64) float64 {
+	lo, hi := -1e6, 1e6
+	for i := 0; i < 200; i++ {
+		mid := (lo + hi) / 2
+		if studentTCDF(mid, df) < p {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// The regularized incomplete beta function I_x(a, b), evaluated by its continued fraction
+// expansion (Numerical Recipes, section 6.4)
+func regularizedIncompleteBeta(x, a, b float64) float64 {
+	if x <= 0 {
+		return 0
+	} else if x >= 1 {
+		return 1
+	}
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	lgab, _ := math.Lgamma(a + b)
+	front := math.Exp(lgab - lga - lgb + a*math.Log(x) + b*math.Log(1-x))
+	if x < (a+1)/(a+b+2) {
+		return front * betaContinuedFraction(x, a, b) / a
+	}
+	return 1 - front*betaContinuedFraction(1-x, b, a)/b
+}
+
+func betaContinuedFraction(x, a, b float64) float64 {
+	const epsilon, tiny = 1e-15, 1e-300
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1.0; m <= 300; m++ {
+		// Even step
+		num := m * (b - m) * x / ((a + 2*m - 1) * (a + 2*m))
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		h *= d * c
+
+		// Odd step
+		num = -(a + m) * (a + b + m) * x / ((a + 2*m) * (a + 2*m + 1))
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+	return h
+}
@@ -0,0 +1,46 @@
+package report
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStudentT(t *testing.T) {
+	require.InDelta(t, 0.5, studentTCDF(0, 5), 1e-12)
+	require.InDelta(t, 0.975, studentTCDF(2.570582, 5), 1e-6)
+	require.InDelta(t, 0.025, studentTCDF(-2.570582, 5), 1e-6)
+
+	// Critical values of the two-sided 95% interval
+	require.InDelta(t, 12.7062, studentTQuantile(0.975, 1), 1e-4)
+	require.InDelta(t, 4.3027, studentTQuantile(0.975, 2), 1e-4)
+	require.InDelta(t, 2.7764, studentTQuantile(0.975, 4), 1e-4)
+	require.InDelta(t, 2.0423, studentTQuantile(0.975, 30), 1e-4)
+	require.InDelta(t, 1.9600, studentTQuantile(0.975, 1e6), 1e-4)
+}
+
+func TestEstimate(t *testing.T) {
+	e := estimate([]float64{2, 4, 4, 4, 5, 5, 7, 9}, 0.95)
+	require.Equal(t, 8, e.N)
+	require.Equal(t, 5.0, e.Mean)
+	require.InDelta(t, 2.13809, e.StdDev, 1e-5)
+	require.InDelta(t, 2.36462*2.13809/math.Sqrt(8), e.CI, 1e-4)
+
+	e = estimate([]float64{42}, 0.95)
+	require.Equal(t, 42.0, e.Mean)
+	require.True(t, math.IsNaN(e.StdDev))
+	require.True(t, math.IsNaN(e.CI))
+}
+
+func TestWelchTTest(t *testing.T) {
+	a := estimate([]float64{1, 2, 3, 4, 5}, 0.95)
+	b := estimate([]float64{3, 4, 5, 6, 7}, 0.95)
+	// t = -2 with 8 degrees of freedom
+	require.InDelta(t, 0.08052, welchTTest(a, b), 1e-4)
+	require.InDelta(t, welchTTest(a, b), welchTTest(b, a), 1e-12)
+	require.InDelta(t, 1.0, welchTTest(a, a), 1e-12)
+
+	require.True(t, math.IsNaN(welchTTest(a, estimate([]float64{3}, 0.95))))
+	require.Equal(t, 0.0, welchTTest(estimate([]float64{1, 1}, 0.95), estimate([]float64{2, 2}, 0.95)))
+}
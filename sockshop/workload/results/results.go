@@ -0,0 +1,199 @@
+// Package results defines the machine-readable results written by the SockShop workload
+// generator, and reads and writes them as JSON and CSV.
+package results
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type (
+	// The results of a single run of the workload generator
+	Results struct {
+		Config    Config            `json:"config"`
+		Start     time.Time         `json:"start"`
+		Duration  float64           `json:"duration_s"` // Wall-clock duration of the run, in seconds
+		Total     Operation         `json:"total"`      // Statistics over all operations
+		Ops       []Operation       `json:"operations"` // Statistics for each operation, sorted by name
+		Journeys  *Journeys         `json:"journeys,omitempty"`
+		Intervals []Interval        `json:"intervals"` // Time series of reporting intervals
+		Errors    []ErrorCount      `json:"errors"`    // Errors by operation and message
+		Dropped   int64             `json:"dropped"`   // Requests not sent because too many were outstanding
+		Extra     map[string]string `json:"extra,omitempty"`
+	}
+
+	// The configuration of the workload generator for a run
+	Config struct {
+		Label string            `json:"label"` // The configuration being measured, e.g. the wiring spec name
+		Mode  string            `json:"mode"`  // "closed-loop" or "open-loop"
+		Flags map[string]string `json:"flags"` // Values of all command-line flags
+	}
+
+	// Statistics for an operation over a whole run
+	Operation struct {
+		Name       string       `json:"name"`
+		Requests   int64        `json:"requests"`
+		Errors     int64        `json:"errors"`
+		Throughput float64      `json:"throughput"` // Requests per second
+		Latency    LatencyStats `json:"latency"`    // Latencies of successful requests
+		Histogram  []Bucket     `json:"histogram,omitempty"`
+	}
+
+	// Summary statistics of a latency distribution.  Durations are in nanoseconds.
+	LatencyStats struct {
+		Min  time.Duration `json:"min_ns"`
+		Mean time.Duration `json:"mean_ns"`
+		P50  time.Duration `json:"p50_ns"`
+		P90  time.Duration `json:"p90_ns"`
+		P95  time.Duration `json:"p95_ns"`
+		P99  time.Duration `json:"p99_ns"`
+		P999 time.Duration `json:"p999_ns"`
+		Max  time.Duration `json:"max_ns"`
+	}
+
+	// A histogram bucket, containing Count latencies between Lower and Upper inclusive
+	Bucket struct {
+		Lower time.Duration `json:"lower_ns"`
+		Upper time.Duration `json:"upper_ns"`
+		Count int64         `json:"count"`
+	}
+
+	// Statistics for end-to-end user-session journeys
+	Journeys struct {
+		Operation
+		Failures map[string]int64 `json:"failures"` // Failed journeys, by the stage that failed
+	}
+
+	// Statistics for one reporting interval
+	Interval struct {
+		Start float64     `json:"start_s"` // Seconds since the start of the run
+		End   float64     `json:"end_s"`
+		Total Operation   `json:"total"`
+		Ops   []Operation `json:"operations"` // Without histograms
+	}
+
+	// The number of times an operation failed with a particular error message
+	ErrorCount struct {
+		Operation string `json:"operation"`
+		Message   string `json:"message"`
+		Count     int64  `json:"count"`
+	}
+)
+
+// Reads results from a JSON file
+func Load(filename string) (*Results, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var r Results
+	return &r, json.Unmarshal(data, &r)
+}
+
+// Writes the results to a JSON file
+func (r *Results) WriteJSON(filename string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// Writes the results as CSV tables, to files prefix_summary.csv, prefix_intervals.csv,
+// prefix_histograms.csv, and prefix_errors.csv
+func (r *Results) WriteCSV(prefix string) error {
+	summary := [][]string{append([]string{"label", "operation", "requests", "errors", "throughput"}, latencyHeader...)}
+	for _, op := range append([]Operation{r.Total}, r.Ops...) {
+		summary = append(summary, append([]string{r.Config.Label, op.Name, itoa(op.Requests), itoa(op.Errors), ftoa(op.Throughput)}, op.Latency.row()...))
+	}
+	if r.Journeys != nil {
+		op := r.Journeys.Operation
+		summary = append(summary, append([]string{r.Config.Label, op.Name, itoa(op.Requests), itoa(op.Errors), ftoa(op.Throughput)}, op.Latency.row()...))
+	}
+
+	intervals := [][]string{append([]string{"label", "start_s", "end_s", "operation", "requests", "errors", "throughput"}, latencyHeader...)}
+	for _, interval := range r.Intervals {
+		for _, op := range append([]Operation{interval.Total}, interval.Ops...) {
+			intervals = append(intervals, append([]string{r.Config.Label, ftoa(interval.Start), ftoa(interval.End), op.Name, itoa(op.Requests), itoa(op.Errors), ftoa(op.Throughput)}, op.Latency.row()...))
+		}
+	}
+
+	histograms := [][]string{{"label", "operation", "lower_ns", "upper_ns", "count"}}
+	for _, op := range append([]Operation{r.Total}, r.Ops...) {
+		for _, b := range op.Histogram {
+			histograms = append(histograms, []string{r.Config.Label, op.Name, itoa(int64(b.Lower)), itoa(int64(b.Upper)), itoa(b.Count)})
+		}
+	}
+
+	errors := [][]string{{"label", "operation", "message", "count"}}
+	for _, e := range r.Errors {
+		errors = append(errors, []string{r.Config.Label, e.Operation, e.Message, itoa(e.Count)})
+	}
+
+	for suffix, rows := range map[string][][]string{"summary": summary, "intervals": intervals, "histograms": histograms, "errors": errors} {
+		if err := writeCSV(prefix+"_"+suffix+".csv", rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the operation with the given name, or nil if there is none.
+// The name "total" returns the statistics over all operations.
+func (r *Results) Op(name string) *Operation {
+	if name == r.Total.Name {
+		return &r.Total
+	}
+	for i := range r.Ops {
+		if r.Ops[i].Name == name {
+			return &r.Ops[i]
+		}
+	}
+	return nil
+}
+
+// Sorts operations by name and errors by operation then decreasing count
+func (r *Results) Sort() {
+	sort.Slice(r.Ops, func(i, j int) bool { return r.Ops[i].Name < r.Ops[j].Name })
+	sort.Slice(r.Errors, func(i, j int) bool {
+		if r.Errors[i].Operation != r.Errors[j].Operation {
+			return r.Errors[i].Operation < r.Errors[j].Operation
+		}
+		return r.Errors[i].Count > r.Errors[j].Count
+	})
+}
+
+var latencyHeader = []string{"min_ns", "mean_ns", "p50_ns", "p90_ns", "p95_ns", "p99_ns", "p999_ns", "max_ns"}
+
+func (l LatencyStats) row() []string {
+	var row []string
+	for _, d := range []time.Duration{l.Min, l.Mean, l.P50, l.P90, l.P95, l.P99, l.P999, l.Max} {
+		row = append(row, itoa(int64(d)))
+	}
+	return row
+}
+
+func writeCSV(filename string, rows [][]string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if err := w.WriteAll(rows); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func itoa(i int64) string {
+	return strconv.FormatInt(i, 10)
+}
+
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
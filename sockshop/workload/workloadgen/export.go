@@ -0,0 +1,137 @@
+package workloadgen
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workload/results"
+)
+
+var out = flag.String("out", "", "file to write machine-readable JSON results to")
+var csvOut = flag.String("csv", "", "prefix of CSV files to write results to: <prefix>_summary.csv, <prefix>_intervals.csv, <prefix>_histograms.csv and <prefix>_errors.csv")
+var label = flag.String("label", "", "name of the configuration being measured, e.g. the wiring spec; recorded in the results")
+
+// Ends the current reporting interval for all operations and records it in the
+// time series; the caller must hold the lock
+func (s *workloadGen) endInterval(now time.Time) results.Interval {
+	if s.lastInterval.IsZero() {
+		s.lastInterval = s.startTime
+	}
+	seconds := now.Sub(s.lastInterval).Seconds()
+	interval := results.Interval{
+		Start: s.lastInterval.Sub(s.startTime).Seconds(),
+		End:   now.Sub(s.startTime).Seconds(),
+		Total: toOperation("total", s.total.rotate(), seconds, false),
+	}
+	for _, name := range s.opNames() {
+		interval.Ops = append(interval.Ops, toOperation(name, s.ops[name].rotate(), seconds, false))
+	}
+	s.intervals = append(s.intervals, interval)
+	s.lastInterval = now
+	return interval
+}
+
+// Collects the results of the run
+func (s *workloadGen) results() *results.Results {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seconds := s.endTime.Sub(s.startTime).Seconds()
+	r := &results.Results{
+		Config: results.Config{
+			Label: *label,
+			Mode:  "closed-loop",
+			Flags: make(map[string]string),
+		},
+		Start:     s.startTime,
+		Duration:  seconds,
+		Total:     toOperation("total", s.total.cumulative, seconds, true),
+		Intervals: s.intervals,
+		Dropped:   s.dropped,
+	}
+	if *workers <= 0 {
+		r.Config.Mode = "open-loop"
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		r.Config.Flags[f.Name] = f.Value.String()
+	})
+
+	for _, name := range s.opNames() {
+		stats := s.ops[name]
+		r.Ops = append(r.Ops, toOperation(name, stats.cumulative, seconds, true))
+		for message, count := range stats.errorMessages {
+			r.Errors = append(r.Errors, results.ErrorCount{Operation: name, Message: message, Count: count})
+		}
+	}
+	if s.journeys != nil {
+		r.Journeys = &results.Journeys{
+			Operation: toOperation("journey", s.journeys.cumulative, seconds, true),
+			Failures:  make(map[string]int64),
+		}
+		for stage, count := range s.journeys.failures {
+			r.Journeys.Failures[stage] = count
+		}
+	}
+	r.Sort()
+	return r
+}
+
+// Writes the results to the files specified by the -out and -csv flags, if any
+func (s *workloadGen) exportResults() error {
+	if *out == "" && *csvOut == "" {
+		return nil
+	}
+	r := s.results()
+	if *out != "" {
+		if err := r.WriteJSON(*out); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote results to %v\n", *out)
+	}
+	if *csvOut != "" {
+		if err := r.WriteCSV(*csvOut); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote CSV results to %v_*.csv\n", *csvOut)
+	}
+	return nil
+}
+
+// Returns the names of all operations recorded so far, sorted; the caller must hold the lock
+func (s *workloadGen) opNames() []string {
+	var names []string
+	for name := range s.ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func toOperation(name string, p statsPeriod, seconds float64, withHistogram bool) results.Operation {
+	op := results.Operation{
+		Name:     name,
+		Requests: p.requests,
+		Errors:   p.errors,
+		Latency: results.LatencyStats{
+			Min:  p.latencies.percentile(0),
+			Mean: p.latencies.mean(),
+			P50:  p.latencies.percentile(50),
+			P90:  p.latencies.percentile(90),
+			P95:  p.latencies.percentile(95),
+			P99:  p.latencies.percentile(99),
+			P999: p.latencies.percentile(99.9),
+			Max:  p.latencies.percentile(100),
+		},
+	}
+	if seconds > 0 {
+		op.Throughput = float64(p.requests) / seconds
+	}
+	if withHistogram {
+		p.latencies.forEachBucket(func(lower, upper time.Duration, count int64) {
+			op.Histogram = append(op.Histogram, results.Bucket{Lower: lower, Upper: upper, Count: count})
+		})
+	}
+	return op
+}
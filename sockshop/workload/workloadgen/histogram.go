@@ -0,0 +1,137 @@
+package workloadgen
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// The histogram has 2^subBucketBits linear sub-buckets per power of two, so
+// every recorded value is accurate to within 1/2^(subBucketBits-1), i.e. 0.8%.
+const (
+	subBucketBits  = 8
+	subBucketCount = 1 << subBucketBits
+	subBucketHalf  = subBucketCount / 2
+	numBuckets     = subBucketCount + (63-subBucketBits)*subBucketHalf
+)
+
+// A high-dynamic-range histogram of durations.
+//
+// Values are stored in log-linear buckets, so the histogram uses a fixed amount of
+// memory regardless of how many values are recorded, while keeping a bounded relative
+// error across the whole range from nanoseconds to hours.  Histograms can be merged,
+// e.g. to combine per-interval histograms into a cumulative one.
+type histogram struct {
+	counts []int64
+	count  int64
+	sum    float64 // In nanoseconds; used to compute the exact mean
+	min    time.Duration
+	max    time.Duration
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]int64, numBuckets)}
+}
+
+func bucketIndex(d time.Duration) int {
+	v := uint64(d)
+	if d < 0 {
+		v = 0
+	}
+	if v < subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBucketBits
+	sub := v >> shift
+	return subBucketCount + (shift-1)*subBucketHalf + int(sub-subBucketHalf)
+}
+
+// Returns the smallest and largest values that fall into bucket i
+func bucketBounds(i int) (time.Duration, time.Duration) {
+	if i < subBucketCount {
+		return time.Duration(i), time.Duration(i)
+	}
+	shift := (i-subBucketCount)/subBucketHalf + 1
+	sub := uint64((i-subBucketCount)%subBucketHalf + subBucketHalf)
+	return time.Duration(sub << shift), time.Duration(((sub + 1) << shift) - 1)
+}
+
+func (h *histogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[bucketIndex(d)]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += float64(d)
+}
+
+// Adds all values recorded in other to h
+func (h *histogram) merge(other *histogram) {
+	if other.count == 0 {
+		return
+	}
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.count += other.count
+	h.sum += other.sum
+}
+
+func (h *histogram) copy() *histogram {
+	c := newHistogram()
+	c.merge(h)
+	return c
+}
+
+func (h *histogram) mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / float64(h.count))
+}
+
+// Returns the value at percentile p (0-100).  The value is the midpoint of the bucket
+// containing the percentile, clamped to the recorded min and max.  The 0th and 100th
+// percentiles are the exact min and max.
+func (h *histogram) percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p / 100 * float64(h.count)))
+	if rank <= 1 {
+		return h.min
+	} else if rank >= h.count {
+		return h.max
+	}
+	seen := int64(0)
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			lower, upper := bucketBounds(i)
+			return min(max(lower+(upper-lower)/2, h.min), h.max)
+		}
+	}
+	return h.max
+}
+
+// Calls f for each non-empty bucket, in increasing order of value
+func (h *histogram) forEachBucket(f func(lower, upper time.Duration, count int64)) {
+	for i, c := range h.counts {
+		if c > 0 {
+			lower, upper := bucketBounds(i)
+			f(lower, upper, c)
+		}
+	}
+}
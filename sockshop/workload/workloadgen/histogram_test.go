@@ -0,0 +1,62 @@
+package workloadgen
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	for _, v := range []time.Duration{0, 1, 255, 256, 257, 1000, time.Millisecond, 37 * time.Second, time.Hour, 1<<63 - 1} {
+		lower, upper := bucketBounds(bucketIndex(v))
+		require.LessOrEqual(t, lower, v)
+		require.GreaterOrEqual(t, upper, v)
+	}
+	require.Equal(t, numBuckets-1, bucketIndex(1<<63-1))
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	h := newHistogram()
+	for i := 1; i <= 100000; i++ {
+		h.record(time.Duration(i) * time.Microsecond)
+	}
+
+	require.Equal(t, int64(100000), h.count)
+	require.Equal(t, time.Microsecond, h.min)
+	require.Equal(t, 100*time.Millisecond, h.max)
+	require.InEpsilon(t, float64(50*time.Millisecond), float64(h.mean()), 0.0001)
+	require.InEpsilon(t, float64(50*time.Millisecond), float64(h.percentile(50)), 0.01)
+	require.InEpsilon(t, float64(95*time.Millisecond), float64(h.percentile(95)), 0.01)
+	require.InEpsilon(t, float64(99*time.Millisecond), float64(h.percentile(99)), 0.01)
+	require.Equal(t, 100*time.Millisecond, h.percentile(100))
+	require.Equal(t, time.Microsecond, h.percentile(0))
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, b, all := newHistogram(), newHistogram(), newHistogram()
+	for i := 0; i < 10000; i++ {
+		v := time.Duration(rand.ExpFloat64() * float64(time.Millisecond))
+		if i%3 == 0 {
+			a.record(v)
+		} else {
+			b.record(v)
+		}
+		all.record(v)
+	}
+
+	merged := a.copy()
+	merged.merge(b)
+	require.Equal(t, all.counts, merged.counts)
+	require.Equal(t, all.count, merged.count)
+	require.Equal(t, all.min, merged.min)
+	require.Equal(t, all.max, merged.max)
+	for _, p := range []float64{50, 95, 99, 99.9} {
+		require.Equal(t, all.percentile(p), merged.percentile(p))
+	}
+
+	empty := newHistogram()
+	require.Equal(t, time.Duration(0), empty.percentile(99))
+	require.Equal(t, time.Duration(0), empty.mean())
+}
@@ -0,0 +1,197 @@
+package workloadgen
+
+import (
+	"context"
+	"flag"
+	"math/rand"
+	"time"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/order"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+var journeys = flag.Bool("journeys", false, "run stateful user-session journeys instead of the operation mix; -workers and -rate then apply to journeys")
+var returning = flag.Float64("returning", 0.5, "fraction of journeys that log in to an existing account rather than registering a new one")
+var shipWait = flag.Duration("shipwait", 30*time.Second, "how long a journey polls GetOrder for its order to be shipped before giving up")
+var pollInterval = flag.Duration("pollinterval", 250*time.Millisecond, "interval between GetOrder calls while waiting for an order to be shipped")
+
+// The stages of a journey, in order.  Used to report where journeys fail.
+const (
+	stageBrowse        = "browse"
+	stageAddItem       = "additem"
+	stageAuthenticate  = "authenticate"
+	stageCheckoutSetup = "checkoutsetup"
+	stageNewOrder      = "neworder"
+	stageAwaitShipment = "awaitshipment"
+)
+
+// End-to-end statistics for journeys
+type journeyStats struct {
+	*opStats
+	failures map[string]int64 // Number of failed journeys, by the stage that failed
+}
+
+// Runs a single user-session journey, recording the latency of each call as well as
+// the end-to-end latency and outcome of the journey:
+//   - an anonymous user browses the catalogue and looks at a few socks
+//   - the user adds items to a fresh session cart
+//   - the user registers a new account, or logs in to an existing one; either merges the session cart
+//   - a new user adds an address and a card
+//   - the user places an order
+//   - the user polls the order until it has been shipped
+//
+// In open-loop mode the journey's latency is measured from its scheduled start time.
+func (s *workloadGen) executeJourney(ctx context.Context, scheduled time.Time) {
+	start := time.Now()
+	if !scheduled.IsZero() {
+		start = scheduled
+	}
+	stage, err := s.runJourney(ctx)
+	latency := time.Since(start)
+	if ctx.Err() != nil {
+		// The workload ended while the journey was in progress
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.journeys.record(latency, err)
+	if err != nil {
+		s.journeys.failures[stage]++
+	}
+}
+
+func (s *workloadGen) runJourney(ctx context.Context) (string, error) {
+	// Anonymous browsing
+	err := s.timed("browse", func() error {
+		_, err := s.frontend.ListItems(ctx, []string{}, "", 1, 100, "")
+		return err
+	})
+	if err != nil {
+		return stageBrowse, err
+	}
+	for i, n := 0, 1+rand.Intn(3); i < n; i++ {
+		err := s.timed("getsock", func() error {
+			_, err := s.frontend.GetSock(ctx, s.randomItem(), "")
+			return err
+		})
+		if err != nil {
+			return stageBrowse, err
+		}
+	}
+
+	// Fill a cart; the first AddItem creates the session
+	sessionToken := ""
+	for i, n := 0, 1+rand.Intn(3); i < n; i++ {
+		err := s.timed("additem", func() error {
+			var err error
+			sessionToken, err = s.frontend.AddItem(ctx, sessionToken, s.randomItem(), "")
+			return err
+		})
+		if err != nil {
+			return stageAddItem, err
+		}
+	}
+
+	// Register or log in; either way the user gets the token of a new session
+	var a *account
+	if rand.Float64() < *returning {
+		select {
+		case a = <-s.returningUsers:
+		default:
+		}
+	}
+	isNewUser := a == nil
+	if isNewUser {
+		a = &account{username: "wlgen-" + uuid.NewString(), password: "password", card: newWorkloadCard()}
+		err = s.timed("register", func() error {
+			var err error
+			a.token, err = s.frontend.Register(ctx, sessionToken, a.username, a.password, a.username+"@example.com", "Workload", "User")
+			return err
+		})
+	} else {
+		err = s.timed("login", func() error {
+			var err error
+			a.token, _, err = s.frontend.Login(ctx, sessionToken, a.username, a.password)
+			return err
+		})
+	}
+	if err != nil {
+		if !isNewUser {
+			s.releaseReturningUser(a)
+		}
+		return stageAuthenticate, err
+	}
+
+	// Only new users need to add an address and card
+	if isNewUser {
+		err = s.timed("postaddress", func() error {
+			var err error
+			a.addressID, err = s.frontend.PostAddress(ctx, a.token, workloadAddress)
+			return err
+		})
+		if err == nil {
+			err = s.timed("postcard", func() error {
+				var err error
+				a.cardID, err = s.frontend.PostCard(ctx, a.token, a.card)
+				return err
+			})
+		}
+		if err != nil {
+			return stageCheckoutSetup, err
+		}
+	}
+
+	// Check out, then make the account available to later journeys
+	var o order.Order
+	key := uuid.NewString()
+	err = s.timed("neworder", func() error {
+		var err error
+		o, err = s.frontend.NewOrder(ctx, a.token, a.addressID, a.cardID, "", key)
+		return err
+	})
+	s.releaseReturningUser(a)
+	if err != nil {
+		return stageNewOrder, err
+	}
+
+	// Wait for the order to be shipped
+	deadline := time.Now().Add(*shipWait)
+	for o.Shipment.Status != "shipped" {
+		if time.Now().After(deadline) {
+			return stageAwaitShipment, errors.Errorf("order %v not shipped after %v", o.ID, *shipWait)
+		}
+		select {
+		case <-ctx.Done():
+			return stageAwaitShipment, ctx.Err()
+		case <-time.After(*pollInterval):
+		}
+		err = s.timed("getorder", func() error {
+			var err error
+			o, err = s.frontend.GetOrder(ctx, a.token, o.ID)
+			return err
+		})
+		if err != nil {
+			return stageAwaitShipment, err
+		}
+	}
+	return "", nil
+}
+
+// Makes an account available to later journeys.  If enough accounts are already
+// available, the account is not reused.
+func (s *workloadGen) releaseReturningUser(a *account) {
+	select {
+	case s.returningUsers <- a:
+	default:
+	}
+}
+
+// Runs and records the latency of a single call made as part of a journey
+func (s *workloadGen) timed(opName string, call func() error) error {
+	start := time.Now()
+	err := call()
+	s.record(opName, time.Since(start), err)
+	return err
+}
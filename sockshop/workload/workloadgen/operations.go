@@ -0,0 +1,304 @@
+package workloadgen
+
+import (
+	"bufio"
+	"context"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/order"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/user"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// An operation is one kind of request that the workload generator issues against the frontend.
+type operation struct {
+	name string
+
+	// Whether the operation is issued on behalf of a registered user account
+	needsAccount bool
+
+	// Optional untimed setup, e.g. filling a cart before placing an order
+	prepare func(ctx context.Context, s *workloadGen, a *account) error
+
+	// The request whose latency is recorded
+	execute func(ctx context.Context, s *workloadGen, a *account) error
+}
+
+// All operations that can be used in an operation mix
+var operations = []*operation{
+	{
+		name: "browse",
+		execute: func(ctx context.Context, s *workloadGen, a *account) error {
+			_, err := s.frontend.ListItems(ctx, []string{}, "", 1, 100, "")
+			return err
+		},
+	},
+	{
+		name: "getsock",
+		execute: func(ctx context.Context, s *workloadGen, a *account) error {
+			_, err := s.frontend.GetSock(ctx, s.randomItem(), "")
+			return err
+		},
+	},
+	{
+		name:         "additem",
+		needsAccount: true,
+		execute: func(ctx context.Context, s *workloadGen, a *account) error {
+			_, err := s.frontend.AddItem(ctx, a.token, s.randomItem(), "")
+			return err
+		},
+	},
+	{
+		name:         "updateitem",
+		needsAccount: true,
+		execute: func(ctx context.Context, s *workloadGen, a *account) error {
+			_, err := s.frontend.UpdateItem(ctx, a.token, s.randomItem(), 1+rand.Intn(5), "")
+			return err
+		},
+	},
+	{
+		name:         "login",
+		needsAccount: true,
+		execute: func(ctx context.Context, s *workloadGen, a *account) error {
+			_, _, err := s.frontend.Login(ctx, "", a.username, a.password)
+			return err
+		},
+	},
+	{
+		name: "register",
+		execute: func(ctx context.Context, s *workloadGen, a *account) error {
+			username := "wlgen-" + uuid.NewString()
+			_, err := s.frontend.Register(ctx, "", username, "password", username+"@example.com", "Workload", "User")
+			return err
+		},
+	},
+	{
+		name:         "postaddress",
+		needsAccount: true,
+		execute: func(ctx context.Context, s *workloadGen, a *account) error {
+			_, err := s.frontend.PostAddress(ctx, a.token, workloadAddress)
+			return err
+		},
+	},
+	{
+		name:         "postcard",
+		needsAccount: true,
+		execute: func(ctx context.Context, s *workloadGen, a *account) error {
+			_, err := s.frontend.PostCard(ctx, a.token, a.card)
+			return err
+		},
+	},
+	{
+		name:         "neworder",
+		needsAccount: true,
+		prepare: func(ctx context.Context, s *workloadGen, a *account) error {
+			_, err := s.frontend.AddItem(ctx, a.token, s.randomItem(), "")
+			return err
+		},
+		execute: func(ctx context.Context, s *workloadGen, a *account) error {
+			_, err := s.frontend.NewOrder(ctx, a.token, a.addressID, a.cardID, "", uuid.NewString())
+			return err
+		},
+	},
+	{
+		name:         "getorders",
+		needsAccount: true,
+		execute: func(ctx context.Context, s *workloadGen, a *account) error {
+			_, err := s.frontend.GetOrders(ctx, a.token, order.OrderQuery{})
+			return err
+		},
+	},
+}
+
+func getOperation(name string) *operation {
+	for _, op := range operations {
+		if op.name == name {
+			return op
+		}
+	}
+	return nil
+}
+
+// A weighted set of operations.  Each request picks an operation at random,
+// proportionally to the operation's weight.
+type operationMix struct {
+	ops     []*operation
+	weights []int
+	total   int
+}
+
+// Parses an operation mix of the form "browse:60,getsock:20,neworder:5".
+// Entries can be separated by commas or newlines; blank lines and lines
+// starting with '#' are ignored.
+func parseMix(spec string) (*operationMix, error) {
+	mix := &operationMix{}
+	for _, line := range strings.Split(spec, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, entry := range strings.Split(line, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			name, weightStr, hasWeight := strings.Cut(entry, ":")
+			name = strings.ToLower(strings.TrimSpace(name))
+			op := getOperation(name)
+			if op == nil {
+				return nil, errors.Errorf("unknown operation %v in mix; expected one of %v", name, operationNames())
+			}
+			weight := 1
+			if hasWeight {
+				var err error
+				weight, err = strconv.Atoi(strings.TrimSpace(weightStr))
+				if err != nil || weight < 0 {
+					return nil, errors.Errorf("invalid weight %v for operation %v", weightStr, name)
+				}
+			}
+			if weight == 0 {
+				continue
+			}
+			mix.ops = append(mix.ops, op)
+			mix.weights = append(mix.weights, weight)
+			mix.total += weight
+		}
+	}
+	if mix.total == 0 {
+		return nil, errors.Errorf("operation mix %q contains no operations", spec)
+	}
+	return mix, nil
+}
+
+// Reads an operation mix from a file; see [parseMix] for the format
+func parseMixFile(filename string) (*operationMix, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return parseMix(strings.Join(lines, "\n"))
+}
+
+// Picks an operation at random according to the weights of the mix
+func (m *operationMix) pick() *operation {
+	n := rand.Intn(m.total)
+	for i, w := range m.weights {
+		if n < w {
+			return m.ops[i]
+		}
+		n -= w
+	}
+	return m.ops[len(m.ops)-1]
+}
+
+// Reports whether any operation in the mix needs a registered user account
+func (m *operationMix) needsAccounts() bool {
+	for _, op := range m.ops {
+		if op.needsAccount {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *operationMix) String() string {
+	var entries []string
+	for i, op := range m.ops {
+		entries = append(entries, op.name+":"+strconv.Itoa(m.weights[i]))
+	}
+	return strings.Join(entries, ",")
+}
+
+func operationNames() []string {
+	var names []string
+	for _, op := range operations {
+		names = append(names, op.name)
+	}
+	return names
+}
+
+// A registered user account, with an address and card, that operations can act on behalf of
+type account struct {
+	username  string
+	password  string
+	token     string // The token of the account's session
+	addressID string
+	cardID    string
+	card      user.Card // The card the account pays with
+}
+
+var workloadAddress = user.Address{
+	Street:   "Campus",
+	Number:   "E1 5",
+	Country:  "Germany",
+	City:     "Saarbruecken",
+	PostCode: "66123",
+}
+
+// Returns a card with a random number that passes the Luhn check.  Each account pays
+// with its own card, so that the payment service's fraud scorer doesn't see the whole
+// workload's payments as one card being used many times a minute.
+func newWorkloadCard() user.Card {
+	digits := make([]byte, 16)
+	digits[0] = 4
+	for i := 1; i < len(digits)-1; i++ {
+		digits[i] = byte(rand.Intn(10))
+	}
+
+	// Choose the check digit so that the Luhn sum is a multiple of 10, doubling every
+	// second digit starting from the one before the check digit
+	sum := 0
+	for i := len(digits) - 2; i >= 0; i-- {
+		digit := int(digits[i])
+		if (len(digits)-1-i)%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	digits[len(digits)-1] = byte((10 - sum%10) % 10)
+	for i := range digits {
+		digits[i] += '0'
+	}
+	return user.Card{LongNum: string(digits), Expires: "0731", CCV: "456"}
+}
+
+// Registers a new user account and gives it an address and a card
+func (s *workloadGen) registerAccount(ctx context.Context) (*account, error) {
+	a := &account{
+		username: "wlgen-" + uuid.NewString(),
+		password: "password",
+		card:     newWorkloadCard(),
+	}
+
+	var err error
+	a.token, err = s.frontend.Register(ctx, "", a.username, a.password, a.username+"@example.com", "Workload", "User")
+	if err != nil {
+		return nil, err
+	}
+	a.addressID, err = s.frontend.PostAddress(ctx, a.token, workloadAddress)
+	if err != nil {
+		return nil, err
+	}
+	a.cardID, err = s.frontend.PostCard(ctx, a.token, a.card)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
@@ -0,0 +1,45 @@
+package workloadgen
+
+import (
+	"context"
+	"testing"
+
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/money"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/payment"
+	"github.com/blueprint-uservices/blueprint/runtime/plugins/simplenosqldb"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// The default number of accounts, each ordering 6 times within a minute, must not have
+// their payments declined by the payment service's default fraud rules, as they would be
+// if the accounts shared a card
+func TestWorkloadPaymentsNotDeclined(t *testing.T) {
+	ctx := context.Background()
+	db, err := simplenosqldb.NewSimpleNoSQLDB(ctx)
+	require.NoError(t, err)
+	gateway, err := payment.NewFakeGateway(ctx, payment.DefaultGatewayScript)
+	require.NoError(t, err)
+	fraud, err := payment.NewFraudScorer(ctx, db, payment.DefaultFraudRules)
+	require.NoError(t, err)
+	rates, err := money.NewFixedRates(ctx, money.DefaultExchangeRates)
+	require.NoError(t, err)
+	payments, err := payment.NewPaymentService(ctx, gateway, fraud, db, rates, "500.00 USD")
+	require.NoError(t, err)
+
+	cards := make(map[string]bool)
+	accounts := make([]*account, 50)
+	for i := range accounts {
+		accounts[i] = &account{username: "wlgen-" + uuid.NewString(), card: newWorkloadCard()}
+		require.False(t, cards[accounts[i].card.LongNum])
+		cards[accounts[i].card.LongNum] = true
+	}
+
+	for i := 0; i < 6; i++ {
+		for _, a := range accounts {
+			auth, err := payments.Authorise(ctx, a.username, workloadAddress, a.card, money.Money{Amount: 2499, Currency: "USD"})
+			require.NoError(t, err)
+			require.True(t, auth.Authorised, "%v: %v", a.card.LongNum, auth.Reasons)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package workloadgen
+
+import (
+	"context"
+	"flag"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var arrival = flag.String("arrival", "constant", "inter-arrival distribution in rate-limited mode: \"constant\" or \"poisson\"")
+var maxOutstanding = flag.Int("maxoutstanding", 10000, "maximum number of outstanding requests in rate-limited mode; requests that are due while at the limit are dropped")
+
+// Returns a function that generates successive inter-arrival times, in nanoseconds,
+// for requests arriving at the given rate per second
+func newArrivalProcess(distribution string, rate int) (func() float64, error) {
+	if rate <= 0 {
+		return nil, errors.Errorf("rate must be positive in rate-limited mode; got %v", rate)
+	}
+	mean := float64(time.Second) / float64(rate)
+	switch distribution {
+	case "constant":
+		return func() float64 { return mean }, nil
+	case "poisson":
+		return func() float64 { return rand.ExpFloat64() * mean }, nil
+	default:
+		return nil, errors.Errorf("unknown arrival distribution %v; expected constant or poisson", distribution)
+	}
+}
+
+// Runs the workload open-loop.  The send time of every request is determined up front by
+// the arrival process, independently of how long earlier requests take, and latencies are
+// measured from the intended send time rather than from when the request was actually
+// sent.  This corrects for coordinated omission: if the application (or the generator)
+// stalls, the requests that should have been sent during the stall still count their
+// full waiting time.
+//
+// At most maxoutstanding requests are in flight at once.  Requests that are due while at
+// the limit are not sent, and are counted as dropped.
+func (s *workloadGen) runRateLimited(ctx context.Context) error {
+	nextArrival, err := newArrivalProcess(*arrival, *rate)
+	if err != nil {
+		return err
+	}
+
+	startTime, endTime, stopWarmup := s.begin()
+	defer stopWarmup()
+
+	// Print stats every 5 seconds
+	statsCtx, stopStats := context.WithCancel(ctx)
+	defer stopStats()
+	go func() {
+		statsTicker := time.NewTicker(5 * time.Second)
+		defer statsTicker.Stop()
+		for {
+			select {
+			case <-statsCtx.Done():
+				return
+			case <-statsTicker.C:
+				s.printStats()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	outstanding := make(chan struct{}, *maxOutstanding)
+	timer := time.NewTimer(0)
+	<-timer.C
+
+	offset := 0.0
+schedule:
+	for scheduled := startTime; scheduled.Before(endTime); scheduled = startTime.Add(time.Duration(offset)) {
+		offset += nextArrival()
+
+		// Wait until the request is due; if we are behind schedule, send immediately
+		if wait := time.Until(scheduled); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				break schedule
+			case <-timer.C:
+			}
+		}
+
+		select {
+		case outstanding <- struct{}{}:
+			wg.Add(1)
+			go func(scheduled time.Time) {
+				defer wg.Done()
+				s.execute(ctx, scheduled)
+				<-outstanding
+			}(scheduled)
+		default:
+			s.mu.Lock()
+			s.dropped++
+			s.mu.Unlock()
+		}
+	}
+
+	// Wait for outstanding requests to complete
+	wg.Wait()
+	stopStats()
+	s.printFinalStats()
+	return nil
+}
@@ -0,0 +1,95 @@
+package workloadgen
+
+import (
+	"time"
+)
+
+// Request counts and latencies over a period of time
+type statsPeriod struct {
+	requests  int64
+	errors    int64
+	latencies *histogram // Latencies of successful requests
+}
+
+// Latency and error statistics for a single operation, or for all operations combined.
+//
+// Statistics are kept cumulatively, as well as for the current reporting interval.
+type opStats struct {
+	cumulative    statsPeriod
+	window        statsPeriod
+	errorMessages map[string]int64 // Number of errors, by error message
+}
+
+// The maximum number of distinct error messages counted per operation; further
+// messages are counted together
+const maxErrorMessages = 100
+
+const otherErrorsMessage = "(other errors)"
+
+// Summary statistics computed from a [statsPeriod]
+type statsSummary struct {
+	requests int64
+	errors   int64
+	avg      time.Duration
+	p50      time.Duration
+	p95      time.Duration
+	p99      time.Duration
+}
+
+func newStatsPeriod() statsPeriod {
+	return statsPeriod{latencies: newHistogram()}
+}
+
+func (p *statsPeriod) record(latency time.Duration, err error) {
+	p.requests++
+	if err != nil {
+		p.errors++
+	} else {
+		p.latencies.record(latency)
+	}
+}
+
+func (p *statsPeriod) summarize() statsSummary {
+	return statsSummary{
+		requests: p.requests,
+		errors:   p.errors,
+		avg:      p.latencies.mean(),
+		p50:      p.latencies.percentile(50),
+		p95:      p.latencies.percentile(95),
+		p99:      p.latencies.percentile(99),
+	}
+}
+
+func newOpStats() *opStats {
+	return &opStats{
+		cumulative:    newStatsPeriod(),
+		window:        newStatsPeriod(),
+		errorMessages: make(map[string]int64),
+	}
+}
+
+// Records a request; the caller must hold the lock
+func (o *opStats) record(latency time.Duration, err error) {
+	o.cumulative.record(latency, err)
+	o.window.record(latency, err)
+	if err != nil {
+		message := err.Error()
+		if _, exists := o.errorMessages[message]; !exists && len(o.errorMessages) >= maxErrorMessages {
+			message = otherErrorsMessage
+		}
+		o.errorMessages[message]++
+	}
+}
+
+// Computes cumulative summary statistics; the caller must hold the lock
+func (o *opStats) summarize() statsSummary {
+	return o.cumulative.summarize()
+}
+
+// Ends the current reporting interval, returning its statistics, and starts
+// a new one; the caller must hold the lock
+func (o *opStats) rotate() statsPeriod {
+	window := o.window
+	o.window = newStatsPeriod()
+	return window
+}
@@ -3,13 +3,15 @@ package workloadgen
 import (
 	"context"
 	"fmt"
-	"sort"
+	"math/rand"
 	"sync"
 	"time"
 
 	"flag"
 
 	"github.com/blueprint-uservices/blueprint/examples/sockshop/workflow/frontend"
+	"github.com/blueprint-uservices/blueprint/examples/sockshop/workload/results"
+	"github.com/pkg/errors"
 )
 
 // The WorkloadGen interface, which the Blueprint compiler will treat as a
@@ -23,60 +25,123 @@ type workloadGen struct {
 	SimpleWorkload
 
 	frontend frontend.Frontend
-	
-	// Performance metrics
-	mu        sync.Mutex
-	latencies []time.Duration
-	requests  int64
-	errors    int64
+
+	mix      *operationMix
+	items    []string      // IDs of the socks in the catalogue
+	accounts chan *account // Registered user accounts, checked out by one request at a time
+
+	returningUsers chan *account // Accounts registered by earlier journeys, for returning users to log in to
+
+	// Performance metrics, overall and per operation
+	mu    sync.Mutex
+	total *opStats
+	ops   map[string]*opStats
+
+	dropped int64 // Requests not sent because too many requests were outstanding
+
+	startTime    time.Time
+	endTime      time.Time
+	lastInterval time.Time          // When the current reporting interval started
+	intervals    []results.Interval // Statistics for each completed reporting interval
+
+	journeys *journeyStats // Only used when running journeys
 }
 
 var myarg = flag.Int("myarg", 12345, "help message for myarg")
 var duration = flag.Int("duration", 60, "duration of workload in seconds")
 var workers = flag.Int("workers", 100, "number of concurrent workers (0 for rate-limited mode)")
 var rate = flag.Int("rate", 0, "requests per second (only used if workers=0)")
+var mix = flag.String("mix", "browse:100", "weighted operation mix, e.g. \"browse:60,getsock:20,additem:10,neworder:5,getorders:5\"")
+var mixFile = flag.String("mixfile", "", "file containing the operation mix, one \"operation:weight\" entry per line; overrides -mix")
+var warmup = flag.Int("warmup", 0, "warm-up period in seconds before the measured duration; statistics recorded during the warm-up are discarded")
+var numAccounts = flag.Int("accounts", 50, "number of user accounts to register before starting, if the mix contains operations on behalf of a user; with -journeys, the maximum number of accounts kept for returning users")
 
 func NewSimpleWorkload(ctx context.Context, frontend frontend.Frontend) (SimpleWorkload, error) {
 	return &workloadGen{
-		frontend:  frontend,
-		latencies: make([]time.Duration, 0, 10000),
+		frontend: frontend,
+		total:    newOpStats(),
+		ops:      make(map[string]*opStats),
 	}, nil
 }
 
 func (s *workloadGen) Run(ctx context.Context) error {
-	_, err := s.frontend.LoadCatalogue(ctx)
+	var err error
+	if *mixFile != "" {
+		s.mix, err = parseMixFile(*mixFile)
+	} else {
+		s.mix, err = parseMix(*mix)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.frontend.LoadCatalogue(ctx)
 	if err != nil {
 		fmt.Println("Failed to load catalogue")
 		return err
 	}
-	
+
+	socks, err := s.frontend.ListItems(ctx, []string{}, "", 1, 1000, "")
+	if err != nil {
+		return err
+	} else if len(socks) == 0 {
+		return errors.Errorf("the catalogue is empty")
+	}
+	for _, sock := range socks {
+		s.items = append(s.items, sock.ID)
+	}
+
+	if *journeys {
+		s.journeys = &journeyStats{opStats: newOpStats(), failures: make(map[string]int64)}
+		s.returningUsers = make(chan *account, max(*numAccounts, 1))
+	} else if s.mix.needsAccounts() {
+		if *numAccounts <= 0 {
+			return errors.Errorf("the operation mix %v requires at least one account", s.mix)
+		}
+		fmt.Printf("Registering %d user accounts\n", *numAccounts)
+		s.accounts = make(chan *account, *numAccounts)
+		for i := 0; i < *numAccounts; i++ {
+			a, err := s.registerAccount(ctx)
+			if err != nil {
+				return errors.Wrap(err, "unable to register workload user account")
+			}
+			s.accounts <- a
+		}
+	}
+
 	if *workers > 0 {
 		fmt.Printf("Starting workload generator (Max Throughput Mode):\n")
-		fmt.Printf("  Duration: %d seconds\n", *duration)
+		fmt.Printf("  Duration: %d seconds (after %d seconds warm-up)\n", *duration, *warmup)
 		fmt.Printf("  Workers: %d\n", *workers)
+		s.printWorkloadDescription()
 		fmt.Println()
-		return s.runMaxThroughput(ctx)
+		err = s.runMaxThroughput(ctx)
 	} else {
 		fmt.Printf("Starting workload generator (Rate Limited Mode):\n")
-		fmt.Printf("  Duration: %d seconds\n", *duration)
-		fmt.Printf("  Rate: %d req/s\n", *rate)
+		fmt.Printf("  Duration: %d seconds (after %d seconds warm-up)\n", *duration, *warmup)
+		fmt.Printf("  Rate: %d req/s (%v arrivals, at most %d outstanding)\n", *rate, *arrival, *maxOutstanding)
+		s.printWorkloadDescription()
 		fmt.Println()
-		return s.runRateLimited(ctx)
+		err = s.runRateLimited(ctx)
+	}
+	if err != nil {
+		return err
 	}
+	return s.exportResults()
 }
 
 func (s *workloadGen) runMaxThroughput(ctx context.Context) error {
-	startTime := time.Now()
-	endTime := startTime.Add(time.Duration(*duration) * time.Second)
-	
+	_, endTime, stopWarmup := s.begin()
+	defer stopWarmup()
+
 	// Print stats every 5 seconds
 	statsTicker := time.NewTicker(5 * time.Second)
 	defer statsTicker.Stop()
-	
+
 	// Create a context that will be cancelled after duration
 	workCtx, cancel := context.WithDeadline(ctx, endTime)
 	defer cancel()
-	
+
 	// Start worker goroutines
 	var wg sync.WaitGroup
 	for i := 0; i < *workers; i++ {
@@ -88,12 +153,12 @@ func (s *workloadGen) runMaxThroughput(ctx context.Context) error {
 				case <-workCtx.Done():
 					return
 				default:
-					s.executeRequest(workCtx)
+					s.execute(workCtx, time.Time{})
 				}
 			}
 		}()
 	}
-	
+
 	// Print stats periodically
 	go func() {
 		for {
@@ -101,111 +166,195 @@ func (s *workloadGen) runMaxThroughput(ctx context.Context) error {
 			case <-workCtx.Done():
 				return
 			case <-statsTicker.C:
-				s.printStats(startTime)
+				s.printStats()
 			}
 		}
 	}()
-	
+
 	// Wait for all workers to finish
 	wg.Wait()
-	s.printFinalStats(startTime)
+	s.printFinalStats()
 	return nil
 }
 
-func (s *workloadGen) runRateLimited(ctx context.Context) error {
-	startTime := time.Now()
-	endTime := startTime.Add(time.Duration(*duration) * time.Second)
-	ticker := time.NewTicker(time.Second / time.Duration(*rate))
-	defer ticker.Stop()
-	
-	// Print stats every 5 seconds
-	statsTicker := time.NewTicker(5 * time.Second)
-	defer statsTicker.Stop()
-	
-	for {
+// Starts measuring the run, returning when the run starts and ends.  The run lasts for
+// the warm-up period followed by the measured duration.  When the warm-up period is
+// over, all statistics recorded so far are discarded and measurement restarts.
+func (s *workloadGen) begin() (startTime, endTime time.Time, stopWarmup func()) {
+	startTime = time.Now()
+	warmupTime := time.Duration(*warmup) * time.Second
+	s.mu.Lock()
+	s.startTime = startTime
+	s.mu.Unlock()
+
+	endTime = startTime.Add(warmupTime + time.Duration(*duration)*time.Second)
+	if warmupTime <= 0 {
+		return startTime, endTime, func() {}
+	}
+	timer := time.AfterFunc(warmupTime, s.resetStats)
+	return startTime, endTime, func() { timer.Stop() }
+}
+
+// Discards all statistics recorded so far and restarts measurement
+func (s *workloadGen) resetStats() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total = newOpStats()
+	s.ops = make(map[string]*opStats)
+	if s.journeys != nil {
+		s.journeys = &journeyStats{opStats: newOpStats(), failures: make(map[string]int64)}
+	}
+	s.dropped = 0
+	s.intervals = nil
+	s.startTime = time.Now()
+	s.lastInterval = s.startTime
+	fmt.Println("Warm-up complete; statistics reset")
+}
+
+func (s *workloadGen) printWorkloadDescription() {
+	if *journeys {
+		fmt.Printf("  Journeys: %.0f%% returning users\n", *returning*100)
+	} else {
+		fmt.Printf("  Mix: %v\n", s.mix)
+	}
+}
+
+// Executes either a journey or a single request, depending on the mode.
+//
+// In open-loop mode, scheduled is the time at which the request was meant to be sent,
+// and the recorded latency includes any delay between then and when the request was
+// actually sent.  In closed-loop mode, scheduled is the zero time.
+func (s *workloadGen) execute(ctx context.Context, scheduled time.Time) {
+	if *journeys {
+		s.executeJourney(ctx, scheduled)
+	} else {
+		s.executeRequest(ctx, scheduled)
+	}
+}
+
+// Picks an operation from the mix and executes it, recording its latency
+func (s *workloadGen) executeRequest(ctx context.Context, scheduled time.Time) {
+	op := s.mix.pick()
+
+	var a *account
+	if op.needsAccount {
 		select {
+		case a = <-s.accounts:
+			defer func() { s.accounts <- a }()
 		case <-ctx.Done():
-			s.printFinalStats(startTime)
-			return nil
-		case now := <-ticker.C:
-			if now.After(endTime) {
-				s.printFinalStats(startTime)
-				return nil
-			}
-			go s.executeRequest(ctx)
-		case <-statsTicker.C:
-			s.printStats(startTime)
+			return
+		}
+	}
+
+	// Time spent waiting to be sent counts towards the latency; untimed setup does not
+	var delay time.Duration
+	if !scheduled.IsZero() {
+		delay = time.Since(scheduled)
+	}
+
+	if op.prepare != nil {
+		if err := op.prepare(ctx, s, a); err != nil {
+			s.record(op.name, 0, err)
+			return
 		}
 	}
-}
 
-func (s *workloadGen) executeRequest(ctx context.Context) {
 	start := time.Now()
-	_, err := s.frontend.ListItems(ctx, []string{}, "", 1, 100)
-	latency := time.Since(start)
-	
+	err := op.execute(ctx, s, a)
+	latency := delay + time.Since(start)
+	s.record(op.name, latency, err)
+}
+
+func (s *workloadGen) record(opName string, latency time.Duration, err error) {
 	s.mu.Lock()
-	s.requests++
-	if err != nil {
-		s.errors++
-	} else {
-		s.latencies = append(s.latencies, latency)
+	defer s.mu.Unlock()
+
+	stats, exists := s.ops[opName]
+	if !exists {
+		stats = newOpStats()
+		s.ops[opName] = stats
 	}
-	s.mu.Unlock()
+	stats.record(latency, err)
+	s.total.record(latency, err)
+}
+
+func (s *workloadGen) randomItem() string {
+	return s.items[rand.Intn(len(s.items))]
 }
 
-func (s *workloadGen) printStats(startTime time.Time) {
+// Prints the cumulative statistics since measurement started, followed by the
+// statistics of the interval since stats were last printed
+func (s *workloadGen) printStats() {
 	s.mu.Lock()
-	elapsed := time.Since(startTime)
-	requests := s.requests
-	errors := s.errors
-	latenciesCopy := make([]time.Duration, len(s.latencies))
-	copy(latenciesCopy, s.latencies)
+	now := time.Now()
+	elapsed := now.Sub(s.startTime)
+	total := s.total.summarize()
+	interval := s.endInterval(now).Total
 	s.mu.Unlock()
-	
-	if len(latenciesCopy) == 0 {
+
+	if total.requests == total.errors {
 		return
 	}
-	
-	sort.Slice(latenciesCopy, func(i, j int) bool {
-		return latenciesCopy[i] < latenciesCopy[j]
-	})
-	
-	throughput := float64(requests) / elapsed.Seconds()
-	avg := average(latenciesCopy)
-	p50 := percentile(latenciesCopy, 50)
-	p95 := percentile(latenciesCopy, 95)
-	p99 := percentile(latenciesCopy, 99)
-	
-	fmt.Printf("[%.1fs] Requests: %d | Errors: %d | Throughput: %.1f req/s | Avg: %v | p50: %v | p95: %v | p99: %v\n",
-		elapsed.Seconds(), requests, errors, throughput, avg, p50, p95, p99)
-}
-
-func (s *workloadGen) printFinalStats(startTime time.Time) {
-	fmt.Println("\n=== Final Results ===")
-	s.printStats(startTime)
+
+	throughput := float64(total.requests) / elapsed.Seconds()
+	fmt.Printf("[%.1fs] Requests: %d | Errors: %d | Throughput: %.1f req/s | Avg: %v | p50: %v | p95: %v | p99: %v | Interval: %.1f req/s, %d errors, p50 %v, p99 %v\n",
+		elapsed.Seconds(), total.requests, total.errors, throughput, total.avg, total.p50, total.p95, total.p99,
+		interval.Throughput, interval.Errors, interval.Latency.P50, interval.Latency.P99)
 }
 
-func average(latencies []time.Duration) time.Duration {
-	if len(latencies) == 0 {
-		return 0
+func (s *workloadGen) printFinalStats() {
+	fmt.Println("\n=== Final Results ===")
+	s.printStats()
+
+	s.mu.Lock()
+	s.endTime = time.Now()
+	elapsed := s.endTime.Sub(s.startTime)
+	names := s.opNames()
+	summaries := make(map[string]statsSummary)
+	for _, name := range names {
+		summaries[name] = s.ops[name].summarize()
 	}
-	var sum time.Duration
-	for _, l := range latencies {
-		sum += l
+	dropped := s.dropped
+	s.mu.Unlock()
+
+	fmt.Println("\n=== Per-Operation Results ===")
+	for _, name := range names {
+		op := summaries[name]
+		throughput := float64(op.requests) / elapsed.Seconds()
+		fmt.Printf("%-12s Requests: %d | Errors: %d | Throughput: %.1f req/s | Avg: %v | p50: %v | p95: %v | p99: %v\n",
+			name, op.requests, op.errors, throughput, op.avg, op.p50, op.p95, op.p99)
+	}
+
+	if s.journeys != nil {
+		s.printJourneyStats(elapsed)
+	}
+
+	if *workers <= 0 {
+		fmt.Printf("\nDropped: %d requests that were due while %d requests were outstanding\n", dropped, *maxOutstanding)
 	}
-	return sum / time.Duration(len(latencies))
 }
 
-func percentile(sorted []time.Duration, p int) time.Duration {
-	if len(sorted) == 0 {
-		return 0
+func (s *workloadGen) printJourneyStats(elapsed time.Duration) {
+	s.mu.Lock()
+	journeys := s.journeys.summarize()
+	failures := make(map[string]int64)
+	for stage, count := range s.journeys.failures {
+		failures[stage] = count
 	}
-	idx := (len(sorted) * p) / 100
-	if idx >= len(sorted) {
-		idx = len(sorted) - 1
+	s.mu.Unlock()
+
+	successRate := 0.0
+	if journeys.requests > 0 {
+		successRate = 100 * float64(journeys.requests-journeys.errors) / float64(journeys.requests)
+	}
+	fmt.Println("\n=== Journey Results ===")
+	fmt.Printf("Journeys: %d | Failed: %d | Success Rate: %.1f%% | Throughput: %.1f journeys/s | Avg: %v | p50: %v | p95: %v | p99: %v\n",
+		journeys.requests, journeys.errors, successRate, float64(journeys.requests)/elapsed.Seconds(), journeys.avg, journeys.p50, journeys.p95, journeys.p99)
+	for _, stage := range []string{stageBrowse, stageAddItem, stageAuthenticate, stageCheckoutSetup, stageNewOrder, stageAwaitShipment} {
+		if failures[stage] > 0 {
+			fmt.Printf("  failed at %-14s %d\n", stage+":", failures[stage])
+		}
 	}
-	return sorted[idx]
 }
 
 func (s *workloadGen) ImplementsSimpleWorkload(context.Context) error {